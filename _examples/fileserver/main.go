@@ -22,13 +22,12 @@ package main
 
 import (
 	"context"
-	"github.com/valyala/fasthttp"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
 )
 
 func main() {
@@ -43,28 +42,7 @@ func main() {
 	// the ./data/ folder.
 	workDir, _ := os.Getwd()
 	filesDir := http.Dir(filepath.Join(workDir, "data"))
-	FileServer(r, "/files", filesDir)
+	r.Mount("/files", fchi.FileServerWithOptions(filesDir, fchi.FileServerOptions{ListDirectories: true}))
 
 	fasthttp.ListenAndServe(":3333", fchi.RequestHandler(r))
 }
-
-// FileServer conveniently sets up a http.FileServer handler to serve
-// static files from a http.FileSystem.
-func FileServer(r fchi.Router, path string, root http.FileSystem) {
-	if strings.ContainsAny(path, "{}*") {
-		panic("FileServer does not permit any URL parameters.")
-	}
-
-	if path != "/" && path[len(path)-1] != '/' {
-		r.Get(path, fchi.Adapt(http.RedirectHandler(path+"/", 301)))
-		path += "/"
-	}
-	path += "*"
-
-	r.Get(path, fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
-		rctx := fchi.RouteContext(rc)
-		pathPrefix := strings.TrimSuffix(rctx.RoutePattern(), "/*")
-		fs := http.StripPrefix(pathPrefix, http.FileServer(root))
-		fchi.Adapt(fs).ServeHTTP(ctx, rc)
-	}))
-}
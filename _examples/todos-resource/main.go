@@ -1,8 +1,9 @@
 //
 // Todos Resource
 // ==============
-// This example demonstrates a project structure that defines a subrouter and its
-// handlers on a struct, and mounting them as subrouters to a parent router.
+// This example demonstrates a project structure that defines resource handlers
+// on a struct, and registering them with Resource on a parent router. Collection
+// and item routes are derived from the methods the struct implements.
 // See also _examples/rest for an in-depth example of a REST service, and apply
 // those same patterns to this structure.
 //
@@ -26,8 +27,11 @@ func main() {
 		rc.Write([]byte("."))
 	}))
 
-	r.Mount("/users", usersResource{}.Routes())
-	r.Mount("/todos", todosResource{}.Routes())
+	r.Resource("/users", usersResource{})
+
+	// Item router of todos resource accepts additional routes: GET /todos/{id}/sync.
+	todos := r.Resource("/todos", todosResource{})
+	todos.Get("/sync", fchi.HandlerFunc(todosResource{}.Sync))
 
 	fasthttp.ListenAndServe(":3333", fchi.RequestHandler(r))
 }
@@ -2,9 +2,8 @@ package data
 
 import (
 	"errors"
-	"net/http"
 
-	"github.com/go-chi/render"
+	"github.com/valyala/fasthttp"
 )
 
 var (
@@ -13,16 +12,16 @@ var (
 	ErrNotFound     = errors.New("Resource not found")
 )
 
-func PresentError(r *http.Request, err error) (*http.Request, interface{}) {
+// ErrorStatus returns HTTP status code for an error.
+func ErrorStatus(err error) int {
 	switch err {
 	case ErrUnauthorized:
-		render.Status(r, 401)
+		return fasthttp.StatusUnauthorized
 	case ErrForbidden:
-		render.Status(r, 403)
+		return fasthttp.StatusForbidden
 	case ErrNotFound:
-		render.Status(r, 404)
+		return fasthttp.StatusNotFound
 	default:
-		render.Status(r, 500)
+		return fasthttp.StatusInternalServerError
 	}
-	return r, map[string]string{"error": err.Error()}
 }
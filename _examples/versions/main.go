@@ -1,115 +1,112 @@
 //
 // Versions
 // ========
-// This example demonstrates the use of the render subpackage, with
-// a quick concept for how to support multiple api versions.
+// This example demonstrates the use of the versioning subpackage to serve
+// multiple api versions from the same service.
+//
+// Version can be requested with path prefix, header or Accept media type parameter:
+//  curl http://localhost:3333/v2/articles
+//  curl -H "X-API-Version: 2" http://localhost:3333/articles
+//  curl -H "Accept: application/json; version=2" http://localhost:3333/articles
+//
+// Version 1 is deprecated and responds with Deprecation and Sunset headers,
+// version 3 does not implement GET /articles/{articleID} and falls back to version 2.
 //
 package main
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"math/rand"
-	"net/http"
 	"time"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/_examples/versions/data"
-	v1 "github.com/go-chi/chi/v5/_examples/versions/presenter/v1"
-	v2 "github.com/go-chi/chi/v5/_examples/versions/presenter/v2"
-	v3 "github.com/go-chi/chi/v5/_examples/versions/presenter/v3"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/render"
+	"github.com/swaggest/fchi"
+	"github.com/swaggest/fchi/_examples/versions/data"
+	v1 "github.com/swaggest/fchi/_examples/versions/presenter/v1"
+	v2 "github.com/swaggest/fchi/_examples/versions/presenter/v2"
+	v3 "github.com/swaggest/fchi/_examples/versions/presenter/v3"
+	"github.com/swaggest/fchi/middleware"
+	"github.com/swaggest/fchi/versioning"
+	"github.com/valyala/fasthttp"
 )
 
 func main() {
-	r := chi.NewRouter()
-
-	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-
-	// API version 3.
-	r.Route("/v3", func(r chi.Router) {
-		r.Use(apiVersionCtx("v3"))
-		r.Mount("/articles", articleRouter())
+	vr := versioning.New(versioning.Options{
+		PathPrefix:     true,
+		Header:         "X-API-Version",
+		MediaTypeParam: "version",
+		Default:        "3",
 	})
 
+	// API version 1.
+	v1r := fchi.NewRouter()
+	v1r.Use(randomErrorMiddleware) // Simulate random error, ie. version 1 is buggy.
+	v1r.Mount("/articles", articleRouter(true))
+	vr.Handle("1", v1r,
+		versioning.Deprecated(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)),
+		versioning.Sunset(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+	)
+
 	// API version 2.
-	r.Route("/v2", func(r chi.Router) {
-		r.Use(apiVersionCtx("v2"))
-		r.Mount("/articles", articleRouter())
-	})
+	v2r := fchi.NewRouter()
+	v2r.Mount("/articles", articleRouter(true))
+	vr.Handle("2", v2r)
 
-	// API version 1.
-	r.Route("/v1", func(r chi.Router) {
-		r.Use(randomErrorMiddleware) // Simulate random error, ie. version 1 is buggy.
-		r.Use(apiVersionCtx("v1"))
-		r.Mount("/articles", articleRouter())
-	})
+	// API version 3, falls back to version 2 for missing routes.
+	v3r := fchi.NewRouter()
+	v3r.Mount("/articles", articleRouter(false))
+	vr.Handle("3", v3r, versioning.FallbackTo("2"))
+
+	r := fchi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(middleware.Recoverer)
 
-	http.ListenAndServe(":3333", r)
+	r.Mount("/", vr)
+
+	fasthttp.ListenAndServe(":3333", fchi.RequestHandler(r))
 }
 
-func apiVersionCtx(version string) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			r = r.WithContext(context.WithValue(r.Context(), "api.version", version))
-			next.ServeHTTP(w, r)
+func articleRouter(withGet bool) fchi.Handler {
+	r := fchi.NewRouter()
+	r.Get("/", fchi.HandlerFunc(listArticles))
+
+	if withGet {
+		r.Route("/{articleID}", func(r fchi.Router) {
+			r.Get("/", fchi.HandlerFunc(getArticle))
+			// r.Put("/", updateArticle)
+			// r.Delete("/", deleteArticle)
 		})
 	}
-}
 
-func articleRouter() http.Handler {
-	r := chi.NewRouter()
-	r.Get("/", listArticles)
-	r.Route("/{articleID}", func(r chi.Router) {
-		r.Get("/", getArticle)
-		// r.Put("/", updateArticle)
-		// r.Delete("/", deleteArticle)
-	})
 	return r
 }
 
-func listArticles(w http.ResponseWriter, r *http.Request) {
-	articles := make(chan render.Renderer, 5)
-
-	// Load data asynchronously into the channel (simulate slow storage):
-	go func() {
-		for i := 1; i <= 10; i++ {
-			article := &data.Article{
-				ID:                     i,
-				Title:                  fmt.Sprintf("Article #%v", i),
-				Data:                   []string{"one", "two", "three", "four"},
-				CustomDataForAuthUsers: "secret data for auth'd users only",
-			}
-
-			apiVersion := r.Context().Value("api.version").(string)
-			switch apiVersion {
-			case "v1":
-				articles <- v1.NewArticleResponse(article)
-			case "v2":
-				articles <- v2.NewArticleResponse(article)
-			default:
-				articles <- v3.NewArticleResponse(article)
-			}
-
-			time.Sleep(100 * time.Millisecond)
+func listArticles(ctx context.Context, rc *fasthttp.RequestCtx) {
+	articles := make([]interface{}, 0, 10)
+
+	for i := 1; i <= 10; i++ {
+		article := &data.Article{
+			ID:                     i,
+			Title:                  fmt.Sprintf("Article #%v", i),
+			Data:                   []string{"one", "two", "three", "four"},
+			CustomDataForAuthUsers: "secret data for auth'd users only",
 		}
-		close(articles)
-	}()
 
-	// Start streaming data from the channel.
-	render.Respond(w, r, articles)
+		articles = append(articles, present(ctx, rc, article))
+	}
+
+	respond(rc, articles)
 }
 
-func getArticle(w http.ResponseWriter, r *http.Request) {
+func getArticle(ctx context.Context, rc *fasthttp.RequestCtx) {
 	// Load article.
-	if chi.URLParam(r, "articleID") != "1" {
-		render.Respond(w, r, data.ErrNotFound)
+	if fchi.URLParam(rc, "articleID") != "1" {
+		respondError(rc, data.ErrNotFound)
 		return
 	}
+
 	article := &data.Article{
 		ID:                     1,
 		Title:                  "Article #1",
@@ -117,42 +114,49 @@ func getArticle(w http.ResponseWriter, r *http.Request) {
 		CustomDataForAuthUsers: "secret data for auth'd users only",
 	}
 
-	// Simulate some context values:
-	// 1. ?auth=true simluates authenticated session/user.
-	// 2. ?error=true simulates random error.
-	if r.URL.Query().Get("auth") != "" {
-		r = r.WithContext(context.WithValue(r.Context(), "auth", true))
-	}
-	if r.URL.Query().Get("error") != "" {
-		render.Respond(w, r, errors.New("error"))
+	// Simulate random error with ?error=true.
+	if len(rc.QueryArgs().Peek("error")) > 0 {
+		respondError(rc, data.ErrForbidden)
 		return
 	}
 
-	var payload render.Renderer
+	respond(rc, present(ctx, rc, article))
+}
 
-	apiVersion := r.Context().Value("api.version").(string)
-	switch apiVersion {
-	case "v1":
-		payload = v1.NewArticleResponse(article)
-	case "v2":
-		payload = v2.NewArticleResponse(article)
+func present(ctx context.Context, rc *fasthttp.RequestCtx, article *data.Article) interface{} {
+	switch versioning.FromContext(ctx).String() {
+	case "1":
+		return v1.NewArticleResponse(article)
+	case "2":
+		return v2.NewArticleResponse(article)
 	default:
-		payload = v3.NewArticleResponse(article)
+		// ?auth=true simulates authenticated session/user.
+		return v3.NewArticleResponse(article, len(rc.QueryArgs().Peek("auth")) > 0)
 	}
+}
 
-	render.Render(w, r, payload)
+func respond(rc *fasthttp.RequestCtx, v interface{}) {
+	rc.SetContentType("application/json")
+
+	if err := json.NewEncoder(rc).Encode(v); err != nil {
+		respondError(rc, err)
+	}
 }
 
-func randomErrorMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rand.Seed(time.Now().Unix())
+func respondError(rc *fasthttp.RequestCtx, err error) {
+	rc.Response.Reset()
+	rc.SetStatusCode(data.ErrorStatus(err))
+	respond(rc, map[string]string{"error": err.Error()})
+}
 
+func randomErrorMiddleware(next fchi.Handler) fchi.Handler {
+	return fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
 		// One in three chance of random error.
 		if rand.Int31n(3) == 0 {
 			errors := []error{data.ErrUnauthorized, data.ErrForbidden, data.ErrNotFound}
-			render.Respond(w, r, errors[rand.Intn(len(errors))])
+			respondError(rc, errors[rand.Intn(len(errors))])
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(ctx, rc)
 	})
 }
@@ -1,9 +1,7 @@
 package v1
 
 import (
-	"net/http"
-
-	"github.com/go-chi/chi/v5/_examples/versions/data"
+	"github.com/swaggest/fchi/_examples/versions/data"
 )
 
 // Article presented in API version 1.
@@ -13,10 +11,6 @@ type Article struct {
 	Data map[string]bool `json:"data" xml:"data"`
 }
 
-func (a *Article) Render(w http.ResponseWriter, r *http.Request) error {
-	return nil
-}
-
 func NewArticleResponse(article *data.Article) *Article {
 	return &Article{Article: article}
 }
@@ -2,9 +2,8 @@ package v2
 
 import (
 	"fmt"
-	"net/http"
 
-	"github.com/go-chi/chi/v5/_examples/versions/data"
+	"github.com/swaggest/fchi/_examples/versions/data"
 )
 
 // Article presented in API version 2.
@@ -20,11 +19,9 @@ type Article struct {
 	URL interface{} `json:"url,omitempty" xml:"url,omitempty"`
 }
 
-func (a *Article) Render(w http.ResponseWriter, r *http.Request) error {
-	a.SelfURL = fmt.Sprintf("http://localhost:3333/v2?id=%v", a.ID)
-	return nil
-}
-
 func NewArticleResponse(article *data.Article) *Article {
-	return &Article{Article: article}
+	return &Article{
+		Article: article,
+		SelfURL: fmt.Sprintf("http://localhost:3333/v2?id=%v", article.ID),
+	}
 }
@@ -3,12 +3,11 @@ package v3
 import (
 	"fmt"
 	"math/rand"
-	"net/http"
 
-	"github.com/go-chi/chi/v5/_examples/versions/data"
+	"github.com/swaggest/fchi/_examples/versions/data"
 )
 
-// Article presented in API version 2.
+// Article presented in API version 3.
 type Article struct {
 	*data.Article `json:",inline" xml:",inline"`
 
@@ -22,18 +21,18 @@ type Article struct {
 	CustomDataForAuthUsers interface{} `json:"custom_data,omitempty" xml:"custom_data,omitempty"`
 }
 
-func (a *Article) Render(w http.ResponseWriter, r *http.Request) error {
-	a.ViewsCount = rand.Int63n(100000)
-	a.URL = fmt.Sprintf("http://localhost:3333/v3/?id=%v", a.ID)
+func NewArticleResponse(article *data.Article, auth bool) *Article {
+	a := &Article{
+		Article:    article,
+		ViewsCount: rand.Int63n(100000),
+		URL:        fmt.Sprintf("http://localhost:3333/v3/?id=%v", article.ID),
+		APIVersion: "v3",
+	}
 
 	// Only show to auth'd user.
-	if _, ok := r.Context().Value("auth").(bool); ok {
-		a.CustomDataForAuthUsers = a.Article.CustomDataForAuthUsers
+	if auth {
+		a.CustomDataForAuthUsers = article.CustomDataForAuthUsers
 	}
 
-	return nil
-}
-
-func NewArticleResponse(article *data.Article) *Article {
-	return &Article{Article: article}
+	return a
 }
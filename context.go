@@ -77,6 +77,21 @@ type Context struct {
 
 	// methodNotAllowed hint
 	methodNotAllowed bool
+
+	// errorRenderer of the innermost routing Mux that has one.
+	errorRenderer ErrorRenderer
+
+	// err is the error recorded with HandleError.
+	err error
+
+	// Route metadata collected during routing, see WithMeta.
+	meta []metaPair
+
+	// routed is set when the endpoint route is found.
+	routed bool
+
+	// keepAlive prevents putting context back to the pool.
+	keepAlive bool
 }
 
 // Reset a routing context to its initial state.
@@ -92,6 +107,11 @@ func (x *Context) Reset() {
 	x.routeParams.Keys = x.routeParams.Keys[:0]
 	x.routeParams.Values = x.routeParams.Values[:0]
 	x.methodNotAllowed = false
+	x.errorRenderer = nil
+	x.err = nil
+	x.meta = x.meta[:0]
+	x.routed = false
+	x.keepAlive = false
 	x.parentCtx = nil
 }
 
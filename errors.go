@@ -0,0 +1,224 @@
+package fchi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ErrHandlerFunc is a handler that returns an error instead of writing it.
+//
+// A non-nil error is passed to HandleError, so that it is rendered by
+// ErrorRenderer of the routing Mux and is available to middlewares with
+// RequestError.
+type ErrHandlerFunc func(ctx context.Context, rc *fasthttp.RequestCtx) error
+
+// ServeHTTP serves http request and handles returned error.
+func (f ErrHandlerFunc) ServeHTTP(ctx context.Context, rc *fasthttp.RequestCtx) {
+	if err := f(ctx, rc); err != nil {
+		HandleError(ctx, rc, err)
+	}
+}
+
+// ErrorRenderer writes an error to the response.
+type ErrorRenderer interface {
+	RenderError(ctx context.Context, rc *fasthttp.RequestCtx, err error)
+}
+
+// ErrorRendererFunc implements ErrorRenderer.
+type ErrorRendererFunc func(ctx context.Context, rc *fasthttp.RequestCtx, err error)
+
+// RenderError renders error.
+func (f ErrorRendererFunc) RenderError(ctx context.Context, rc *fasthttp.RequestCtx, err error) {
+	f(ctx, rc, err)
+}
+
+// DefaultErrorRenderer is used when routing Mux has no ErrorRenderer.
+var DefaultErrorRenderer ErrorRenderer = ProblemRenderer{}
+
+// HandleError records the error of a request and renders it with the
+// ErrorRenderer of the routing Mux.
+//
+// Middlewares can observe recorded error with RequestError after
+// calling the next handler.
+func HandleError(ctx context.Context, rc *fasthttp.RequestCtx, err error) {
+	renderer := DefaultErrorRenderer
+
+	if rctx := RouteContext(rc); rctx != nil {
+		rctx.err = err
+
+		if rctx.errorRenderer != nil {
+			renderer = rctx.errorRenderer
+		}
+	}
+
+	renderer.RenderError(ctx, rc, err)
+}
+
+// SetRequestError records the error of a request without rendering it,
+// for handlers and middlewares that write error response on their own.
+func SetRequestError(rc *fasthttp.RequestCtx, err error) {
+	if rctx := RouteContext(rc); rctx != nil {
+		rctx.err = err
+	}
+}
+
+// RequestError returns the error recorded with HandleError for the request.
+func RequestError(rc *fasthttp.RequestCtx) error {
+	if rctx := RouteContext(rc); rctx != nil {
+		return rctx.err
+	}
+
+	return nil
+}
+
+// StatusCoder is implemented by errors that carry HTTP status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// ValidationError is implemented by errors that describe invalid request parameters.
+type ValidationError interface {
+	error
+	InvalidParams() []InvalidParam
+}
+
+// InvalidParam describes a single invalid request parameter.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Problem is an RFC 9457 problem details object, it can be returned as an error.
+type Problem struct {
+	Type          string         `json:"type,omitempty"`
+	Title         string         `json:"title,omitempty"`
+	Status        int            `json:"status,omitempty"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// Error returns problem detail or title.
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+
+	if p.Title != "" {
+		return p.Title
+	}
+
+	return fasthttp.StatusMessage(p.StatusCode())
+}
+
+// StatusCode returns problem status or 500.
+func (p *Problem) StatusCode() int {
+	if p.Status == 0 {
+		return fasthttp.StatusInternalServerError
+	}
+
+	return p.Status
+}
+
+// ProblemRenderer renders errors as RFC 9457 application/problem+json if
+// client accepts JSON, or as text/plain otherwise.
+//
+// Response status is taken from the first of:
+//  - error that implements StatusCoder (found with errors.As),
+//  - Statuses of a sentinel error that matches with errors.Is,
+//  - 400 for ValidationError,
+//  - 500.
+//
+// Error message is exposed as problem detail for 4xx statuses only.
+type ProblemRenderer struct {
+	// Statuses maps sentinel errors to HTTP statuses.
+	Statuses map[error]int
+}
+
+// RenderError renders error.
+func (pr ProblemRenderer) RenderError(ctx context.Context, rc *fasthttp.RequestCtx, err error) {
+	p := pr.Problem(err)
+
+	rc.Response.ResetBody()
+	rc.SetStatusCode(p.Status)
+
+	if !acceptsJSON(rc.Request.Header.Peek("Accept")) {
+		rc.SetContentType("text/plain; charset=utf-8")
+		rc.SetBodyString(strconv.Itoa(p.Status) + " " + p.Title)
+
+		if p.Detail != "" {
+			rc.Response.AppendBodyString(": " + p.Detail)
+		}
+
+		return
+	}
+
+	body, e := json.Marshal(p)
+	if e != nil {
+		rc.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError), fasthttp.StatusInternalServerError)
+
+		return
+	}
+
+	rc.SetContentType("application/problem+json")
+	rc.SetBody(body)
+}
+
+// Problem converts error to problem details.
+func (pr ProblemRenderer) Problem(err error) Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		res := *p
+		res.Status = p.StatusCode()
+
+		if res.Title == "" {
+			res.Title = fasthttp.StatusMessage(res.Status)
+		}
+
+		return res
+	}
+
+	res := Problem{Status: pr.status(err)}
+	res.Title = fasthttp.StatusMessage(res.Status)
+
+	if res.Status < fasthttp.StatusInternalServerError {
+		res.Detail = err.Error()
+	}
+
+	var ve ValidationError
+	if errors.As(err, &ve) {
+		res.InvalidParams = ve.InvalidParams()
+	}
+
+	return res
+}
+
+func (pr ProblemRenderer) status(err error) int {
+	var sc StatusCoder
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+
+	for sentinel, status := range pr.Statuses {
+		if errors.Is(err, sentinel) {
+			return status
+		}
+	}
+
+	var ve ValidationError
+	if errors.As(err, &ve) {
+		return fasthttp.StatusBadRequest
+	}
+
+	return fasthttp.StatusInternalServerError
+}
+
+// acceptsJSON checks if Accept header has a JSON media type.
+func acceptsJSON(accept []byte) bool {
+	return bytes.Contains(accept, []byte("/json")) || bytes.Contains(accept, []byte("+json"))
+}
@@ -0,0 +1,122 @@
+package fchi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errTestNotFound = errors.New("item not found")
+
+type testStatusError struct {
+	status int
+}
+
+func (e testStatusError) Error() string {
+	return fmt.Sprintf("status %d", e.status)
+}
+
+func (e testStatusError) StatusCode() int {
+	return e.status
+}
+
+type testValidationError struct{}
+
+func (testValidationError) Error() string {
+	return "invalid request"
+}
+
+func (testValidationError) InvalidParams() []InvalidParam {
+	return []InvalidParam{{Name: "age", Reason: "must be positive"}}
+}
+
+func TestErrHandlerFunc(t *testing.T) {
+	var observed []string
+
+	observer := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			next.ServeHTTP(ctx, rc)
+
+			if err := RequestError(rc); err != nil {
+				observed = append(observed, err.Error())
+			}
+		})
+	}
+
+	r := NewRouter()
+	r.Use(observer)
+	r.RenderErrors(ProblemRenderer{Statuses: map[error]int{errTestNotFound: http.StatusNotFound}})
+
+	r.Get("/ok", ErrHandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) error {
+		rc.WriteString("ok")
+
+		return nil
+	}))
+	r.Get("/sentinel", ErrHandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) error {
+		return fmt.Errorf("loading: %w", errTestNotFound)
+	}))
+	r.Get("/status", ErrHandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) error {
+		return testStatusError{status: http.StatusConflict}
+	}))
+	r.Get("/validation", ErrHandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) error {
+		return testValidationError{}
+	}))
+	r.Get("/internal", ErrHandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) error {
+		return errors.New("db password leaked")
+	}))
+
+	sub := NewRouter()
+	sub.RenderErrors(ErrorRendererFunc(func(ctx context.Context, rc *fasthttp.RequestCtx, err error) {
+		rc.Error("custom: "+err.Error(), http.StatusTeapot)
+	}))
+	sub.Get("/", ErrHandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) error {
+		return errors.New("failed")
+	}))
+	r.Mount("/sub", sub)
+
+	cases := []struct {
+		path, accept string
+		status       int
+		contentType  string
+		body         string
+	}{
+		{path: "/ok", status: 200, contentType: "text/plain; charset=utf-8", body: "ok"},
+		{path: "/sentinel", status: 404, contentType: "text/plain; charset=utf-8", body: "404 Not Found: loading: item not found"},
+		{
+			path: "/sentinel", accept: "application/problem+json", status: 404, contentType: "application/problem+json",
+			body: `{"title":"Not Found","status":404,"detail":"loading: item not found"}`,
+		},
+		{path: "/status", status: 409, contentType: "text/plain; charset=utf-8", body: "409 Conflict: status 409"},
+		{
+			path: "/validation", accept: "application/json", status: 400, contentType: "application/problem+json",
+			body: `{"title":"Bad Request","status":400,"detail":"invalid request","invalid-params":[{"name":"age","reason":"must be positive"}]}`,
+		},
+		{path: "/internal", status: 500, contentType: "text/plain; charset=utf-8", body: "500 Internal Server Error"},
+		{path: "/sub", status: 418, contentType: "text/plain; charset=utf-8", body: "custom: failed"},
+	}
+
+	for _, c := range cases {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod("GET")
+		rc.Request.SetRequestURI(c.path)
+		rc.Request.Header.Set("Accept", c.accept)
+
+		r.ServeHTTP(context.Background(), rc)
+
+		if rc.Response.StatusCode() != c.status || string(rc.Response.Body()) != c.body ||
+			string(rc.Response.Header.ContentType()) != c.contentType {
+			t.Errorf("%s: unexpected response %d %s %q", c.path, rc.Response.StatusCode(),
+				rc.Response.Header.ContentType(), rc.Response.Body())
+		}
+	}
+
+	expected := "loading: item not found,loading: item not found,status 409,invalid request,db password leaked,failed"
+	if s := strings.Join(observed, ","); s != expected {
+		t.Fatalf("unexpected observed errors: %s", s)
+	}
+}
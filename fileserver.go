@@ -0,0 +1,402 @@
+package fchi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// FileServerOptions configures FileServerWithOptions.
+type FileServerOptions struct {
+	// IndexFile is served for directory requests, default "index.html".
+	IndexFile string
+
+	// ListDirectories enables HTML listing of directories without index file,
+	// such directories are not found otherwise.
+	ListDirectories bool
+
+	// SPAFallback serves root index file instead of not found response for
+	// paths without file extension, so that client-side routing of a single
+	// page application can handle them.
+	SPAFallback bool
+
+	// Precompressed enables serving of ".br" and ".gz" siblings of a file
+	// to clients that accept such content encoding.
+	Precompressed bool
+}
+
+// FileServer returns a handler that serves files of root, e.g. http.Dir("./public")
+// or http.FS(embedded).
+//
+// File path is relative to the route pattern or the mount the handler is
+// routed with.
+//
+//   r.Mount("/assets", fchi.FileServer(http.Dir("./assets")))
+//
+// Responses support byte ranges and conditional requests with ETag and
+// Last-Modified validators.
+func FileServer(root http.FileSystem) Handler {
+	return FileServerWithOptions(root, FileServerOptions{})
+}
+
+// FileServerWithOptions returns a handler that serves files of root.
+func FileServerWithOptions(root http.FileSystem, opts FileServerOptions) Handler {
+	if opts.IndexFile == "" {
+		opts.IndexFile = "index.html"
+	}
+
+	return &fileServer{root: root, opts: opts}
+}
+
+// Static serves files of root under the pattern, requests to the pattern
+// without trailing slash are redirected.
+//
+//   r.Static("/assets", http.Dir("./assets"))
+func (mx *Mux) Static(pattern string, root http.FileSystem) {
+	if strings.ContainsAny(pattern, "{}*") {
+		panic("chi: Static() does not permit URL parameters in pattern")
+	}
+
+	pattern = strings.TrimSuffix(pattern, "/")
+	h := FileServer(root)
+
+	if pattern != "" {
+		mx.Get(pattern, HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			redirectSlash(rc, string(rc.URI().PathOriginal()))
+		}))
+	}
+
+	mx.Get(pattern+"/*", h)
+	mx.Head(pattern+"/*", h)
+}
+
+type fileServer struct {
+	root http.FileSystem
+	opts FileServerOptions
+}
+
+func (fs *fileServer) ServeHTTP(ctx context.Context, rc *fasthttp.RequestCtx) {
+	if !rc.IsGet() && !rc.IsHead() {
+		rc.Response.Header.Set("Allow", "GET, HEAD")
+		rc.Error(fasthttp.StatusMessage(fasthttp.StatusMethodNotAllowed), fasthttp.StatusMethodNotAllowed)
+
+		return
+	}
+
+	name := fs.filePath(rc)
+
+	fallback := false
+
+	f, fi, err := fs.open(name)
+	if err != nil {
+		if os.IsNotExist(err) && fs.opts.SPAFallback && path.Ext(name) == "" {
+			name, fallback = "/"+fs.opts.IndexFile, true
+			f, fi, err = fs.open(name)
+		}
+
+		if err != nil {
+			fs.fail(ctx, rc, err)
+
+			return
+		}
+	}
+
+	if fi.IsDir() {
+		fs.serveDir(ctx, rc, f, name)
+
+		return
+	}
+
+	if !fallback && strings.HasSuffix(string(rc.URI().PathOriginal()), "/") {
+		// File requested as a directory.
+		_ = f.Close()
+		fs.fail(ctx, rc, os.ErrNotExist)
+
+		return
+	}
+
+	fs.serveFile(ctx, rc, f, fi, name)
+}
+
+// filePath returns cleaned path of the requested file relative to the
+// route pattern prefix, e.g. "/css/app.css" of "/assets/css/app.css" for
+// "/assets/*" pattern.
+func (fs *fileServer) filePath(rc *fasthttp.RequestCtx) string {
+	p := string(rc.URI().PathOriginal())
+
+	if rctx := RouteContext(rc); rctx != nil {
+		prefix := strings.TrimSuffix(strings.TrimSuffix(rctx.RoutePattern(), "*"), "/")
+
+		// URL parameters never match "/", so prefix has as many segments in request path as in pattern.
+		for n := strings.Count(prefix, "/"); n > 0 && p != ""; n-- {
+			if i := strings.IndexByte(p[1:], '/'); i >= 0 {
+				p = p[i+1:]
+			} else {
+				p = ""
+			}
+		}
+	}
+
+	if u, err := url.PathUnescape(p); err == nil {
+		p = u
+	}
+
+	return path.Clean("/" + p)
+}
+
+func (fs *fileServer) open(name string) (http.File, os.FileInfo, error) {
+	f, err := fs.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+
+		return nil, nil, err
+	}
+
+	return f, fi, nil
+}
+
+func (fs *fileServer) fail(ctx context.Context, rc *fasthttp.RequestCtx, err error) {
+	switch {
+	case os.IsNotExist(err):
+		if rctx := RouteContext(rc); rctx != nil {
+			if mx, ok := rctx.Routes.(*Mux); ok {
+				mx.NotFoundHandler().ServeHTTP(ctx, rc)
+
+				return
+			}
+		}
+
+		HandleError(ctx, rc, &Problem{Status: fasthttp.StatusNotFound})
+	case os.IsPermission(err):
+		HandleError(ctx, rc, &Problem{Status: fasthttp.StatusForbidden})
+	default:
+		HandleError(ctx, rc, err)
+	}
+}
+
+func (fs *fileServer) serveDir(ctx context.Context, rc *fasthttp.RequestCtx, dir http.File, name string) {
+	defer dir.Close()
+
+	reqPath := string(rc.URI().PathOriginal())
+	if !strings.HasSuffix(reqPath, "/") {
+		redirectSlash(rc, reqPath)
+
+		return
+	}
+
+	index := path.Join(name, fs.opts.IndexFile)
+
+	f, fi, err := fs.open(index)
+	if err == nil && !fi.IsDir() {
+		fs.serveFile(ctx, rc, f, fi, index)
+
+		return
+	}
+
+	if err == nil {
+		_ = f.Close()
+	}
+
+	if !fs.opts.ListDirectories {
+		fs.fail(ctx, rc, os.ErrNotExist)
+
+		return
+	}
+
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		fs.fail(ctx, rc, err)
+
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	rc.SetContentType("text/html; charset=utf-8")
+	rc.WriteString("<pre>\n")
+
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+
+		u := url.URL{Path: n}
+		fmt.Fprintf(rc, "<a href=\"%s\">%s</a>\n", html.EscapeString(u.String()), html.EscapeString(n))
+	}
+
+	rc.WriteString("</pre>\n")
+}
+
+func redirectSlash(rc *fasthttp.RequestCtx, reqPath string) {
+	target := path.Base(reqPath) + "/"
+	if q := rc.URI().QueryString(); len(q) > 0 {
+		target += "?" + string(q)
+	}
+
+	rc.Response.Header.Set("Location", target)
+	rc.SetStatusCode(fasthttp.StatusMovedPermanently)
+}
+
+var precompressedEncodings = []struct {
+	encoding, ext string
+}{
+	{encoding: "br", ext: ".br"},
+	{encoding: "gzip", ext: ".gz"},
+}
+
+func (fs *fileServer) serveFile(ctx context.Context, rc *fasthttp.RequestCtx, f http.File, fi os.FileInfo, name string) {
+	if fs.opts.Precompressed {
+		rc.Response.Header.Add("Vary", "Accept-Encoding")
+
+		for _, pe := range precompressedEncodings {
+			if !rc.Request.Header.HasAcceptEncoding(pe.encoding) {
+				continue
+			}
+
+			cf, cfi, err := fs.open(name + pe.ext)
+			if err != nil || cfi.IsDir() {
+				continue
+			}
+
+			_ = f.Close()
+			f, fi = cf, cfi
+
+			rc.Response.Header.Set("Content-Encoding", pe.encoding)
+
+			break
+		}
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		// Content type of a compressed file can not be sniffed.
+		if len(rc.Response.Header.Peek("Content-Encoding")) == 0 {
+			var buf [512]byte
+
+			n, _ := io.ReadFull(f, buf[:])
+			ctype = http.DetectContentType(buf[:n])
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil || ctype == "" {
+			ctype = "application/octet-stream"
+		}
+	}
+
+	modTime := fi.ModTime().UTC().Truncate(time.Second)
+	etag := `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(fi.Size(), 36) + `"`
+
+	rc.Response.Header.Set("ETag", etag)
+	rc.Response.Header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	rc.Response.Header.Set("Accept-Ranges", "bytes")
+	rc.SetContentType(ctype)
+
+	if notModified(rc, etag, modTime) {
+		_ = f.Close()
+
+		rc.Response.Header.Del("Content-Type")
+		rc.SetStatusCode(fasthttp.StatusNotModified)
+
+		return
+	}
+
+	size := fi.Size()
+	start, end := int64(0), size-1
+
+	if rangeHeader := rc.Request.Header.Peek("Range"); bytes.HasPrefix(rangeHeader, []byte("bytes=")) &&
+		rangeApplies(rc, etag, modTime) {
+		s, e, err := fasthttp.ParseByteRange(rangeHeader, int(size))
+
+		switch {
+		case err == nil:
+			start, end = int64(s), int64(e)
+
+			rc.Response.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+			rc.SetStatusCode(fasthttp.StatusPartialContent)
+		case strings.Contains(string(rangeHeader), ","):
+			// Multiple ranges are not supported, the whole file is served.
+		default:
+			_ = f.Close()
+
+			rc.Response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			rc.SetStatusCode(fasthttp.StatusRequestedRangeNotSatisfiable)
+
+			return
+		}
+	}
+
+	if start > 0 {
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			_ = f.Close()
+
+			HandleError(ctx, rc, err)
+
+			return
+		}
+	}
+
+	rc.SetBodyStream(&limitedFile{Reader: io.LimitReader(f, end-start+1), Closer: f}, int(end-start+1))
+}
+
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// notModified checks If-None-Match and If-Modified-Since conditions.
+func notModified(rc *fasthttp.RequestCtx, etag string, modTime time.Time) bool {
+	if inm := rc.Request.Header.Peek("If-None-Match"); len(inm) > 0 {
+		return etagMatches(string(inm), etag)
+	}
+
+	if ims, err := http.ParseTime(string(rc.Request.Header.Peek("If-Modified-Since"))); err == nil {
+		return !modTime.After(ims)
+	}
+
+	return false
+}
+
+// rangeApplies checks If-Range condition.
+func rangeApplies(rc *fasthttp.RequestCtx, etag string, modTime time.Time) bool {
+	ir := string(rc.Request.Header.Peek("If-Range"))
+
+	switch {
+	case ir == "":
+		return true
+	case strings.HasPrefix(ir, `"`):
+		return ir == etag
+	}
+
+	t, err := http.ParseTime(ir)
+
+	return err == nil && t.Equal(modTime)
+}
+
+func etagMatches(list, etag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build go1.16
+// +build go1.16
+
+package fchi
+
+import (
+	"io/fs"
+	"net/http"
+)
+
+// FileServerFS returns a handler that serves files of fsys, e.g. embed.FS.
+func FileServerFS(fsys fs.FS, opts FileServerOptions) Handler {
+	return FileServerWithOptions(http.FS(fsys), opts)
+}
@@ -0,0 +1,131 @@
+package fchi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestFileServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	modTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for name, data := range map[string]string{
+		"index.html":        "<html>index</html>",
+		"css/app.css":       "body{}",
+		"css/app.css.gz":    "gzipped",
+		"docs/readme.txt":   "0123456789",
+		"docs/sub/note.txt": "note",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewRouter()
+	r.Static("/static", http.Dir(dir))
+	r.Mount("/assets", FileServerWithOptions(http.Dir(dir), FileServerOptions{Precompressed: true, ListDirectories: true}))
+	r.Route("/{tenant}", func(r Router) {
+		r.Get("/app/*", FileServerWithOptions(http.Dir(dir), FileServerOptions{SPAFallback: true}))
+	})
+
+	request := func(path string, headers ...string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.SetRequestURI(path)
+
+		for i := 0; i < len(headers); i += 2 {
+			rc.Request.Header.Set(headers[i], headers[i+1])
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	for _, c := range []struct {
+		name     string
+		path     string
+		headers  []string
+		status   int
+		body     string
+		location string
+	}{
+		{name: "index", path: "/static/", status: 200, body: "<html>index</html>"},
+		{name: "redirect", path: "/static", status: 301, location: "static/"},
+		{name: "dir redirect", path: "/static/docs?x=1", status: 301, location: "docs/?x=1"},
+		{name: "file", path: "/static/docs/readme.txt", status: 200, body: "0123456789"},
+		{name: "not found", path: "/static/missing.txt", status: 404},
+		{name: "no listing", path: "/static/docs/", status: 404},
+		{name: "traversal", path: "/static/../fileserver_test.go", status: 404},
+		{name: "mount", path: "/assets/docs/sub/note.txt", status: 200, body: "note"},
+		{name: "listing", path: "/assets/docs/", status: 200, body: "<pre>\n<a href=\"readme.txt\">readme.txt</a>\n<a href=\"sub/\">sub/</a>\n</pre>\n"},
+		{name: "precompressed", path: "/assets/css/app.css", status: 200, body: "body{}"},
+		{name: "range", path: "/static/docs/readme.txt", headers: []string{"Range", "bytes=2-4"}, status: 206, body: "234"},
+		{name: "suffix range", path: "/static/docs/readme.txt", headers: []string{"Range", "bytes=-3"}, status: 206, body: "789"},
+		{name: "unsatisfiable range", path: "/static/docs/readme.txt", headers: []string{"Range", "bytes=20-"}, status: 416},
+		{
+			name: "stale if-range", path: "/static/docs/readme.txt", status: 200, body: "0123456789",
+			headers: []string{"Range", "bytes=2-4", "If-Range", `"other"`},
+		},
+		{
+			name: "if-modified-since", path: "/static/docs/readme.txt", status: 304,
+			headers: []string{"If-Modified-Since", modTime.Format(http.TimeFormat)},
+		},
+		{name: "tenant", path: "/acme/app/css/app.css", status: 200, body: "body{}"},
+		{name: "spa", path: "/acme/app/users/42", status: 200, body: "<html>index</html>"},
+		{name: "spa missing asset", path: "/acme/app/missing.js", status: 404},
+	} {
+		rc := request(c.path, c.headers...)
+
+		if rc.Response.StatusCode() != c.status {
+			t.Errorf("%s: unexpected status %d", c.name, rc.Response.StatusCode())
+
+			continue
+		}
+
+		if c.body != "" && string(rc.Response.Body()) != c.body {
+			t.Errorf("%s: unexpected body %q", c.name, rc.Response.Body())
+		}
+
+		if c.location != "" && string(rc.Response.Header.Peek("Location")) != c.location {
+			t.Errorf("%s: unexpected location %q", c.name, rc.Response.Header.Peek("Location"))
+		}
+	}
+
+	rc := request("/assets/css/app.css", "Accept-Encoding", "br, gzip")
+	if string(rc.Response.Body()) != "gzipped" || string(rc.Response.Header.Peek("Content-Encoding")) != "gzip" ||
+		string(rc.Response.Header.ContentType()) != "text/css; charset=utf-8" {
+		t.Errorf("precompressed file expected, %q %s", rc.Response.Body(), rc.Response.Header.String())
+	}
+
+	etag := string(rc.Response.Header.Peek("ETag"))
+	if etag == "" {
+		t.Fatal("ETag expected")
+	}
+
+	if rc = request("/assets/css/app.css", "Accept-Encoding", "gzip", "If-None-Match", etag); rc.Response.StatusCode() != 304 {
+		t.Errorf("not modified expected, status %d", rc.Response.StatusCode())
+	}
+}
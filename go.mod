@@ -3,8 +3,9 @@ module github.com/swaggest/fchi
 go 1.14
 
 require (
-	github.com/andybalholm/brotli v1.0.2 // indirect
+	github.com/andybalholm/brotli v1.0.2
 	github.com/bool64/dev v0.1.27
-	github.com/klauspost/compress v1.12.2 // indirect
+	github.com/klauspost/compress v1.12.2
 	github.com/valyala/fasthttp v1.24.0
+	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
 )
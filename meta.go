@@ -0,0 +1,93 @@
+package fchi
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// MetaHandler is a Handler with a route metadata key/value.
+//
+// When MetaHandler is registered in a Mux, the metadata is attached to the
+// route and is available to middlewares with RouteMeta.
+type MetaHandler struct {
+	Handler
+	Key   interface{}
+	Value interface{}
+}
+
+// WithMeta attaches a metadata key/value to the route of `h`.
+//
+// Metadata of a mounted handler is inherited by all routes of the mount,
+// values of more specific routes take precedence.
+//
+//   r.Post("/upload", fchi.WithMeta(uploadHandler, "timeout", time.Minute))
+func WithMeta(h Handler, key, value interface{}) Handler {
+	return &MetaHandler{Handler: h, Key: key, Value: value}
+}
+
+// metaPair is a route metadata key/value.
+type metaPair struct {
+	key, value interface{}
+}
+
+// unwrapMeta returns the innermost handler and collected metadata of MetaHandler chain.
+func unwrapMeta(h Handler) (Handler, []metaPair) {
+	var meta []metaPair
+
+	for {
+		mh, ok := h.(*MetaHandler)
+		if !ok {
+			break
+		}
+
+		// Inner values are prepended, so that outer ones take precedence on lookup from the end.
+		meta = append([]metaPair{{key: mh.Key, value: mh.Value}}, meta...)
+		h = mh.Handler
+	}
+
+	return h, meta
+}
+
+// KeepAlive prevents the routing context from being reused after the request
+// is served, for handlers that continue running in background, e.g. after
+// a timeout.
+func (x *Context) KeepAlive() {
+	x.keepAlive = true
+}
+
+// Meta returns route metadata value of the routes matched so far.
+func (x *Context) Meta(key interface{}) (interface{}, bool) {
+	for i := len(x.meta) - 1; i >= 0; i-- {
+		if x.meta[i].key == key {
+			return x.meta[i].value, true
+		}
+	}
+
+	return nil, false
+}
+
+// RouteMeta returns metadata value of the route that serves the request.
+//
+// Before the request reaches the endpoint, e.g. in a middleware added with Use,
+// the route is looked up with Match.
+func RouteMeta(rc *fasthttp.RequestCtx, key interface{}) (interface{}, bool) {
+	rctx := RouteContext(rc)
+	if rctx == nil {
+		return nil, false
+	}
+
+	if rctx.routed || rctx.Routes == nil {
+		return rctx.Meta(key)
+	}
+
+	path := string(rc.URI().PathOriginal())
+	if path == "" {
+		path = "/"
+	}
+
+	mctx := NewRouteContext()
+	if !rctx.Routes.Match(mctx, string(rc.Method()), path) {
+		return rctx.Meta(key)
+	}
+
+	return mctx.Meta(key)
+}
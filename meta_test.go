@@ -0,0 +1,74 @@
+package fchi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRouteMeta(t *testing.T) {
+	var beforeRouting, afterRouting string
+
+	mw := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			v, _ := RouteMeta(rc, "limit")
+			beforeRouting = fmt.Sprint(v)
+
+			next.ServeHTTP(ctx, rc)
+
+			v, _ = RouteContext(rc).Meta("limit")
+			afterRouting = fmt.Sprint(v)
+		})
+	}
+
+	h := HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		v, _ := RouteMeta(rc, "limit")
+		owner, _ := RouteMeta(rc, "owner")
+		rc.WriteString(fmt.Sprint(v, " ", owner))
+	})
+
+	sub := NewRouter()
+	sub.Get("/default", h)
+	sub.Get("/custom", WithMeta(h, "limit", 20))
+
+	r := NewRouter()
+	r.Use(mw)
+	r.Get("/plain", h)
+	r.Get("/one", WithMeta(WithMeta(h, "owner", "team-a"), "limit", 1))
+	r.Mount("/sub", WithMeta(WithMeta(sub, "limit", 10), "owner", "team-b"))
+
+	cases := []struct {
+		path, body, limit string
+	}{
+		{path: "/plain", body: "<nil> <nil>", limit: "<nil>"},
+		{path: "/one", body: "1 team-a", limit: "1"},
+		{path: "/sub/default", body: "10 team-b", limit: "10"},
+		{path: "/sub/custom", body: "20 team-b", limit: "20"},
+	}
+
+	for _, c := range cases {
+		if body := testHandler(r, "GET", c.path); body != c.body {
+			t.Errorf("%s: unexpected body %q", c.path, body)
+		}
+
+		if beforeRouting != c.limit || afterRouting != c.limit {
+			t.Errorf("%s: unexpected limit in middleware %q %q", c.path, beforeRouting, afterRouting)
+		}
+	}
+
+	var handlers []string
+
+	_ = Walk(r, func(method string, route string, handler Handler, middlewares ...func(Handler) Handler) error {
+		if _, ok := handler.(*MetaHandler); ok {
+			handlers = append(handlers, route)
+		}
+
+		return nil
+	})
+
+	if len(handlers) != 0 {
+		t.Fatalf("meta handlers are not expected in Walk: %v", handlers)
+	}
+}
@@ -0,0 +1,304 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// API key authentication errors.
+var (
+	ErrAPIKeyMissing      = errors.New("api key missing")
+	ErrAPIKeyInvalid      = errors.New("api key invalid")
+	ErrAPIKeyInsufficient = errors.New("api key scopes insufficient")
+)
+
+// APIKeyIdentity describes a client of an API key.
+type APIKeyIdentity struct {
+	ID     string   `json:"id"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// HasScope checks if key has a scope.
+func (i *APIKeyIdentity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// APIKeyProvider looks up identity of an API key, it returns ErrAPIKeyInvalid for unknown key.
+type APIKeyProvider interface {
+	LookupAPIKey(ctx context.Context, key string) (*APIKeyIdentity, error)
+}
+
+// APIKeyEntry is a known API key with identity.
+type APIKeyEntry struct {
+	APIKeyIdentity
+
+	// Key is a plain API key.
+	Key string `json:"key,omitempty"`
+
+	// Hash is a hash of API key, used if Key is empty.
+	//
+	// Hash with "sha256:" prefix and hex-encoded SHA-256 digest of the key is
+	// looked up directly. Other formats of CheckPasswordHash, e.g. bcrypt,
+	// require the key to be in "<ID>.<secret>" form and secret is checked
+	// against the hash.
+	Hash string `json:"hash,omitempty"`
+}
+
+// APIKeySet is an in-memory APIKeyProvider.
+type APIKeySet struct {
+	digests map[[sha256.Size]byte]*APIKeyIdentity
+	hashed  map[string]APIKeyEntry
+}
+
+// NewAPIKeySet creates an in-memory APIKeyProvider.
+func NewAPIKeySet(entries ...APIKeyEntry) (*APIKeySet, error) {
+	s := &APIKeySet{
+		digests: make(map[[sha256.Size]byte]*APIKeyIdentity, len(entries)),
+		hashed:  make(map[string]APIKeyEntry),
+	}
+
+	for _, e := range entries {
+		e := e
+
+		switch {
+		case e.Key != "":
+			s.digests[sha256.Sum256([]byte(e.Key))] = &e.APIKeyIdentity
+		case strings.HasPrefix(e.Hash, "sha256:"):
+			var d [sha256.Size]byte
+
+			if n, err := hex.Decode(d[:], []byte(strings.TrimPrefix(e.Hash, "sha256:"))); err != nil || n != len(d) {
+				return nil, fmt.Errorf("api key %q: invalid sha256 hash", e.ID)
+			}
+
+			s.digests[d] = &e.APIKeyIdentity
+		case e.Hash != "" && e.ID != "":
+			s.hashed[e.ID] = e
+		default:
+			return nil, fmt.Errorf("api key %q: key or hash with id required", e.ID)
+		}
+	}
+
+	return s, nil
+}
+
+// LookupAPIKey returns identity of API key.
+func (s *APIKeySet) LookupAPIKey(_ context.Context, key string) (*APIKeyIdentity, error) {
+	// Keys are compared by digests, so that lookup time does not depend on matching prefix.
+	if id, ok := s.digests[sha256.Sum256([]byte(key))]; ok {
+		return id, nil
+	}
+
+	if i := strings.IndexByte(key, '.'); i > 0 {
+		if e, ok := s.hashed[key[:i]]; ok {
+			if valid, err := CheckPasswordHash(e.Hash, key[i+1:]); err == nil && valid {
+				return &e.APIKeyIdentity, nil
+			}
+		}
+	}
+
+	return nil, ErrAPIKeyInvalid
+}
+
+// APIKeyFile is an APIKeyProvider that loads a JSON array of APIKeyEntry
+// from a file and reloads it when modified.
+type APIKeyFile struct {
+	mu   sync.Mutex
+	file reloadingFile
+	set  *APIKeySet
+}
+
+// NewAPIKeyFile creates an APIKeyFile, modification time of the file is
+// checked not more often than interval.
+func NewAPIKeyFile(path string, interval time.Duration) (*APIKeyFile, error) {
+	f := &APIKeyFile{}
+	f.file = reloadingFile{path: path, interval: interval, load: func(data []byte) error {
+		var entries []APIKeyEntry
+
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+
+		set, err := NewAPIKeySet(entries...)
+		if err == nil {
+			f.set = set
+		}
+
+		return err
+	}}
+
+	if err := f.file.reload(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// LookupAPIKey returns identity of API key.
+func (f *APIKeyFile) LookupAPIKey(ctx context.Context, key string) (*APIKeyIdentity, error) {
+	f.mu.Lock()
+	// Keeping previous keys on failure.
+	_ = f.file.reloadIfDue(time.Now())
+	set := f.set
+	f.mu.Unlock()
+
+	return set.LookupAPIKey(ctx, key)
+}
+
+// APIKeyOptions configures APIKey middleware.
+type APIKeyOptions struct {
+	// Provider looks up keys, required.
+	Provider APIKeyProvider
+
+	// HeaderName is "X-API-Key" by default.
+	HeaderName string
+
+	// QueryParam enables key lookup in URL query if header is empty.
+	QueryParam string
+
+	// CredentialsOptional passes requests without key, protected routes
+	// can use WithAPIKeyScopes or RequireAPIKeyScope.
+	CredentialsOptional bool
+}
+
+type (
+	ctxKeyAPIKey        struct{}
+	apiKeyScopesMetaKey struct{}
+)
+
+// APIKeyFromContext returns identity of a key authenticated by APIKey middleware.
+func APIKeyFromContext(ctx context.Context) *APIKeyIdentity {
+	id, _ := ctx.Value(ctxKeyAPIKey{}).(*APIKeyIdentity)
+
+	return id
+}
+
+// WithAPIKeyScopes requires API key scopes for the route in route metadata,
+// they are checked by APIKey middleware.
+//
+//  r.Delete("/users/{id}", middleware.WithAPIKeyScopes(deleteUser, "users:write"))
+func WithAPIKeyScopes(h fchi.Handler, scopes ...string) fchi.Handler {
+	return fchi.WithMeta(h, apiKeyScopesMetaKey{}, scopes)
+}
+
+// APIKey is a middleware that authenticates requests with API keys.
+//
+// Key is read from a header or from URL query if configured. Requests with
+// missing or unknown key are rejected with 401 Unauthorized, and requests
+// with key that lacks scopes of the route (see WithAPIKeyScopes) are rejected
+// with 403 Forbidden. Identity of the key is available with APIKeyFromContext.
+func APIKey(opts APIKeyOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.Provider == nil {
+		panic("chi/middleware: APIKey requires Provider")
+	}
+
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-API-Key"
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			key := string(rc.Request.Header.Peek(opts.HeaderName))
+			if key == "" && opts.QueryParam != "" {
+				key = string(rc.QueryArgs().Peek(opts.QueryParam))
+			}
+
+			scopes, _ := fchi.RouteMeta(rc, apiKeyScopesMetaKey{})
+
+			if key == "" {
+				if opts.CredentialsOptional && scopes == nil {
+					next.ServeHTTP(ctx, rc)
+
+					return
+				}
+
+				apiKeyFailed(ctx, rc, fasthttp.StatusUnauthorized, ErrAPIKeyMissing)
+
+				return
+			}
+
+			id, err := opts.Provider.LookupAPIKey(ctx, key)
+			if err != nil {
+				if errors.Is(err, ErrAPIKeyInvalid) {
+					apiKeyFailed(ctx, rc, fasthttp.StatusUnauthorized, err)
+				} else {
+					fchi.HandleError(ctx, rc, err)
+				}
+
+				return
+			}
+
+			if scopes, ok := scopes.([]string); ok && !hasAPIKeyScopes(id, scopes) {
+				apiKeyFailed(ctx, rc, fasthttp.StatusForbidden, ErrAPIKeyInsufficient)
+
+				return
+			}
+
+			if span := SpanFromContext(ctx); span != nil {
+				span.SetAttribute("enduser.id", id.ID)
+			}
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyAPIKey{}, id), rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// RequireAPIKeyScope is a middleware that requires authenticated API key to have all the scopes.
+func RequireAPIKeyScope(scopes ...string) func(next fchi.Handler) fchi.Handler {
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			id := APIKeyFromContext(ctx)
+
+			if id == nil {
+				apiKeyFailed(ctx, rc, fasthttp.StatusUnauthorized, ErrAPIKeyMissing)
+
+				return
+			}
+
+			if !hasAPIKeyScopes(id, scopes) {
+				apiKeyFailed(ctx, rc, fasthttp.StatusForbidden, ErrAPIKeyInsufficient)
+
+				return
+			}
+
+			next.ServeHTTP(ctx, rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+func hasAPIKeyScopes(id *APIKeyIdentity, scopes []string) bool {
+	for _, s := range scopes {
+		if !id.HasScope(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func apiKeyFailed(ctx context.Context, rc *fasthttp.RequestCtx, status int, err error) {
+	if status == fasthttp.StatusUnauthorized {
+		rc.Response.Header.Set("WWW-Authenticate", "APIKey")
+	}
+
+	fchi.HandleError(ctx, rc, &fchi.Problem{Status: status, Detail: err.Error()})
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAPIKey(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("hashed-key"))
+
+	keys, err := NewAPIKeySet(
+		APIKeyEntry{APIKeyIdentity: APIKeyIdentity{ID: "reader", Scopes: []string{"users:read"}}, Key: "plain-key"},
+		APIKeyEntry{APIKeyIdentity: APIKeyIdentity{ID: "writer", Scopes: []string{"users:read", "users:write"}}, Hash: "sha256:" + hex.EncodeToString(sum[:])},
+		APIKeyEntry{APIKeyIdentity: APIKeyIdentity{ID: "admin", Scopes: []string{"admin"}}, Hash: string(bcryptHash)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		if id := APIKeyFromContext(ctx); id != nil {
+			rc.WriteString(id.ID)
+		}
+	})
+
+	r := fchi.NewRouter()
+	r.Use(APIKey(APIKeyOptions{Provider: keys, QueryParam: "api_key", CredentialsOptional: true}))
+	r.Get("/public", h)
+	r.Get("/users", WithAPIKeyScopes(h, "users:read"))
+	r.Delete("/users", WithAPIKeyScopes(h, "users:write"))
+	r.With(RequireAPIKeyScope("admin")).Get("/admin", h)
+
+	for _, c := range []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		status int
+		body   string
+	}{
+		{name: "anonymous", path: "/public", status: 200},
+		{name: "anonymous scoped", path: "/users", status: 401},
+		{name: "anonymous with", path: "/admin", status: 401},
+		{name: "unknown", path: "/public", key: "other", status: 401},
+		{name: "plain", path: "/users", key: "plain-key", status: 200, body: "reader"},
+		{name: "query", path: "/users?api_key=plain-key", status: 200, body: "reader"},
+		{name: "sha256", method: "DELETE", path: "/users", key: "hashed-key", status: 200, body: "writer"},
+		{name: "insufficient", method: "DELETE", path: "/users", key: "plain-key", status: 403},
+		{name: "bcrypt", path: "/admin", key: "admin.s3cret", status: 200, body: "admin"},
+		{name: "bcrypt mismatch", path: "/admin", key: "admin.wrong", status: 401},
+		{name: "insufficient with", path: "/admin", key: "hashed-key", status: 403},
+	} {
+		method := c.method
+		if method == "" {
+			method = "GET"
+		}
+
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(method)
+		rc.Request.SetRequestURI(c.path)
+
+		if c.key != "" {
+			rc.Request.Header.Set("X-API-Key", c.key)
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		if rc.Response.StatusCode() != c.status || (c.status == 200 && string(rc.Response.Body()) != c.body) {
+			t.Errorf("%s: unexpected response %d %s", c.name, rc.Response.StatusCode(), rc.Response.Body())
+		}
+
+		if c.status == 401 && len(rc.Response.Header.Peek("WWW-Authenticate")) == 0 {
+			t.Errorf("%s: WWW-Authenticate expected", c.name)
+		}
+	}
+}
+
+func TestAPIKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+
+	write := func(entries []APIKeyEntry, modTime time.Time) {
+		data, _ := json.Marshal(entries)
+
+		if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write([]APIKeyEntry{{APIKeyIdentity: APIKeyIdentity{ID: "k1"}, Key: "key1"}}, time.Now().Add(-time.Hour))
+
+	f, err := NewAPIKeyFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := f.LookupAPIKey(context.Background(), "key1"); err != nil || id.ID != "k1" {
+		t.Fatalf("unexpected lookup result %v %v", id, err)
+	}
+
+	write([]APIKeyEntry{{APIKeyIdentity: APIKeyIdentity{ID: "k2"}, Key: "key2"}}, time.Now())
+
+	if _, err := f.LookupAPIKey(context.Background(), "key1"); err != ErrAPIKeyInvalid {
+		t.Fatalf("removed key expected to be invalid, %v", err)
+	}
+
+	if id, err := f.LookupAPIKey(context.Background(), "key2"); err != nil || id.ID != "k2" {
+		t.Fatalf("unexpected lookup result %v %v", id, err)
+	}
+}
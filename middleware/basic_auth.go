@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/swaggest/fchi"
 	"github.com/valyala/fasthttp"
@@ -13,21 +14,181 @@ import (
 
 // BasicAuth implements a simple middleware handler for adding basic http auth to a route.
 func BasicAuth(realm string, creds map[string]string) func(next fchi.Handler) fchi.Handler {
+	return BasicAuthFunc(realm, func(_ context.Context, user, pass string) bool {
+		credPass, credUserOk := creds[user]
+
+		return credUserOk && subtle.ConstantTimeCompare([]byte(pass), []byte(credPass)) == 1
+	})
+}
+
+// BasicAuthFunc is a basic http auth middleware that checks credentials with validate function.
+func BasicAuthFunc(realm string, validate func(ctx context.Context, user, pass string) bool) func(next fchi.Handler) fchi.Handler {
+	return BasicAuthWithOptions(BasicAuthOptions{Realm: realm, Validate: validate})
+}
+
+// BasicAuthOptions configures BasicAuthWithOptions middleware.
+type BasicAuthOptions struct {
+	// Realm is reported in WWW-Authenticate header.
+	Realm string
+
+	// Validate checks username and password, e.g. HashedCredentials.Validate
+	// or HtpasswdFile.Validate.
+	Validate func(ctx context.Context, user, pass string) bool
+
+	// MaxFailures enables brute-force lockout: after MaxFailures failed
+	// attempts for a username from a client IP, or for all usernames from
+	// a client IP, requests are rejected with 429 Too Many Requests for
+	// LockoutDuration.
+	//
+	// Failures are counted while each of them follows the previous one within
+	// LockoutDuration. Successful authentication resets failures of the
+	// username, but not failures of the client IP, so that a valid account
+	// can not be used to guess passwords of other users. Failures of the
+	// client IP are reset only after LockoutDuration without failures, users
+	// behind a shared NAT share them, so MaxFailures should be high enough
+	// for their typos.
+	MaxFailures int
+
+	// LockoutByUser also counts failures of a username from all client IPs.
+	//
+	// It protects against distributed attacks, but anyone who knows a username
+	// can lock the account out by sending wrong passwords.
+	LockoutByUser bool
+
+	// LockoutDuration is 15 minutes by default.
+	LockoutDuration time.Duration
+
+	// LockoutStore keeps failure counters, in-memory store is used by default.
+	LockoutStore RateLimitStore
+
+	now func() time.Time
+}
+
+type ctxKeyBasicAuthUser struct{}
+
+// BasicAuthUser returns username authenticated by BasicAuth middleware.
+func BasicAuthUser(ctx context.Context) string {
+	user, _ := ctx.Value(ctxKeyBasicAuthUser{}).(string)
+
+	return user
+}
+
+// BasicAuthWithOptions is a basic http auth middleware with custom credentials
+// validation and brute-force lockout.
+//
+// Authenticated username is available with BasicAuthUser and is added to the
+// "enduser.id" attribute of trace span.
+func BasicAuthWithOptions(opts BasicAuthOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.Validate == nil {
+		panic("chi/middleware: BasicAuth requires Validate function")
+	}
+
+	if opts.LockoutDuration <= 0 {
+		opts.LockoutDuration = 15 * time.Minute
+	}
+
+	if opts.LockoutStore == nil {
+		opts.LockoutStore = NewRateLimitMemoryStore()
+	}
+
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
 	return func(next fchi.Handler) fchi.Handler {
 		return fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
 			user, pass, ok := basicAuth(rc)
 			if !ok {
-				basicAuthFailed(rc, realm)
+				basicAuthFailed(rc, opts.Realm)
+				return
+			}
+
+			var keys []string
+
+			if opts.MaxFailures > 0 {
+				ip := rc.RemoteIP().String()
+				keys = []string{"user:" + user + "\x00ip:" + ip}
+
+				if opts.LockoutByUser {
+					keys = append(keys, "user:"+user)
+				}
+
+				// Client IP key is the last one, see resetFailures.
+				keys = append(keys, "ip:"+ip)
+
+				if retryAfter := opts.lockedFor(keys); retryAfter > 0 {
+					rc.Error(fasthttp.StatusMessage(fasthttp.StatusTooManyRequests), fasthttp.StatusTooManyRequests)
+					rc.Response.Header.Set("Retry-After", ceilSeconds(retryAfter))
+
+					return
+				}
+			}
+
+			if !opts.Validate(ctx, user, pass) {
+				opts.recordFailure(keys)
+				basicAuthFailed(rc, opts.Realm)
+
 				return
 			}
 
-			credPass, credUserOk := creds[user]
-			if !credUserOk || subtle.ConstantTimeCompare([]byte(pass), []byte(credPass)) != 1 {
-				basicAuthFailed(rc, realm)
+			opts.resetFailures(keys)
+
+			if span := SpanFromContext(ctx); span != nil {
+				span.SetAttribute("enduser.id", user)
+			}
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyBasicAuthUser{}, user), rc)
+		})
+	}
+}
+
+// lockedFor returns remaining lockout duration of any of the keys.
+//
+// Lockout state is kept in RateLimitState: Curr counts consecutive failures and
+// WindowStart is the time of the last failure.
+func (o BasicAuthOptions) lockedFor(keys []string) time.Duration {
+	now := o.now()
+
+	var locked time.Duration
+
+	for _, key := range keys {
+		_ = o.LockoutStore.Update(key, o.LockoutDuration, func(state *RateLimitState) {
+			if state.Curr < int64(o.MaxFailures) {
 				return
 			}
 
-			next.ServeHTTP(ctx, rc)
+			if d := state.WindowStart.Add(o.LockoutDuration).Sub(now); d > locked {
+				locked = d
+			} else if d <= 0 {
+				state.Curr = 0
+			}
+		})
+	}
+
+	return locked
+}
+
+func (o BasicAuthOptions) recordFailure(keys []string) {
+	now := o.now()
+
+	for _, key := range keys {
+		_ = o.LockoutStore.Update(key, o.LockoutDuration, func(state *RateLimitState) {
+			// Failures older than lockout duration are forgotten.
+			if now.Sub(state.WindowStart) >= o.LockoutDuration {
+				state.Curr = 0
+			}
+
+			state.Curr++
+			state.WindowStart = now
+		})
+	}
+}
+
+func (o BasicAuthOptions) resetFailures(keys []string) {
+	// Client IP keeps failures of all usernames, see BasicAuthOptions.MaxFailures.
+	for i := 0; i < len(keys)-1; i++ {
+		_ = o.LockoutStore.Update(keys[i], o.LockoutDuration, func(state *RateLimitState) {
+			state.Curr = 0
 		})
 	}
 }
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func basicAuthRequest(h fchi.Handler, user, pass string) *fasthttp.RequestCtx {
+	return basicAuthRequestFrom(h, "127.0.0.1", user, pass)
+}
+
+func basicAuthRequestFrom(h fchi.Handler, ip, user, pass string) *fasthttp.RequestCtx {
+	rc := &fasthttp.RequestCtx{}
+	rc.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP(ip)}, nil)
+	rc.Request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+pass)))
+	h.ServeHTTP(context.Background(), rc)
+
+	return rc
+}
+
+func TestBasicAuth(t *testing.T) {
+	next := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString(BasicAuthUser(ctx))
+	})
+
+	h := BasicAuth("test", map[string]string{"alice": "secret"})(next)
+
+	rc := basicAuthRequest(h, "alice", "secret")
+	if rc.Response.StatusCode() != 200 || string(rc.Response.Body()) != "alice" {
+		t.Fatalf("unexpected response %d %s", rc.Response.StatusCode(), rc.Response.Body())
+	}
+
+	rc = basicAuthRequest(h, "alice", "wrong")
+	if rc.Response.StatusCode() != 401 || string(rc.Response.Header.Peek("WWW-Authenticate")) != `Basic realm="test"` {
+		t.Fatalf("unexpected response %d %s", rc.Response.StatusCode(), rc.Response.Header.Peek("WWW-Authenticate"))
+	}
+
+	h = BasicAuthFunc("test", HashedCredentials{"bob": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}.Validate)(next)
+
+	if rc = basicAuthRequest(h, "bob", "secret"); string(rc.Response.Body()) != "bob" {
+		t.Fatalf("unexpected response %d %s", rc.Response.StatusCode(), rc.Response.Body())
+	}
+
+	if rc = basicAuthRequest(h, "alice", "secret"); rc.Response.StatusCode() != 401 {
+		t.Fatalf("unexpected status %d", rc.Response.StatusCode())
+	}
+}
+
+func TestBasicAuthWithOptions_lockout(t *testing.T) {
+	now := time.Now()
+
+	h := BasicAuthWithOptions(BasicAuthOptions{
+		Realm:           "test",
+		Validate:        HashedCredentials{"alice": "$apr1$salt123$te32nsHkuX3Vl4IZyuP3X."}.Validate,
+		MaxFailures:     3,
+		LockoutDuration: time.Minute,
+		now:             func() time.Time { return now },
+	})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {}))
+
+	for i := 0; i < 3; i++ {
+		if rc := basicAuthRequest(h, "alice", "wrong"); rc.Response.StatusCode() != 401 {
+			t.Fatalf("unexpected status %d", rc.Response.StatusCode())
+		}
+	}
+
+	rc := basicAuthRequest(h, "alice", "secret")
+	if rc.Response.StatusCode() != 429 || string(rc.Response.Header.Peek("Retry-After")) != "60" {
+		t.Fatalf("lockout expected, %d %s", rc.Response.StatusCode(), rc.Response.Header.Peek("Retry-After"))
+	}
+
+	// Username is not locked for other clients.
+	if rc := basicAuthRequestFrom(h, "10.0.0.2", "alice", "secret"); rc.Response.StatusCode() != 200 {
+		t.Fatalf("user lockout is not expected for other IP, status %d", rc.Response.StatusCode())
+	}
+
+	now = now.Add(time.Minute)
+
+	if rc := basicAuthRequest(h, "alice", "secret"); rc.Response.StatusCode() != 200 {
+		t.Fatalf("lockout expected to expire, status %d", rc.Response.StatusCode())
+	}
+
+	// Client IP is locked after failures with different usernames.
+	for _, user := range []string{"bob", "carol", "dave"} {
+		basicAuthRequest(h, user, "wrong")
+	}
+
+	if rc := basicAuthRequest(h, "alice", "secret"); rc.Response.StatusCode() != 429 {
+		t.Fatalf("IP lockout expected, status %d", rc.Response.StatusCode())
+	}
+}
+
+func TestBasicAuthWithOptions_lockoutByUser(t *testing.T) {
+	h := BasicAuthWithOptions(BasicAuthOptions{
+		Realm:         "test",
+		Validate:      HashedCredentials{"alice": "$apr1$salt123$te32nsHkuX3Vl4IZyuP3X."}.Validate,
+		MaxFailures:   3,
+		LockoutByUser: true,
+	})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {}))
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		basicAuthRequestFrom(h, ip, "alice", "wrong")
+	}
+
+	if rc := basicAuthRequestFrom(h, "10.0.0.4", "alice", "secret"); rc.Response.StatusCode() != 429 {
+		t.Fatalf("user lockout expected, status %d", rc.Response.StatusCode())
+	}
+}
+
+func TestHtpasswdFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".htpasswd")
+
+	write := func(data string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# users\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n\n", time.Now().Add(-time.Hour))
+
+	f, err := NewHtpasswdFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Validate(context.Background(), "alice", "secret") {
+		t.Fatal("alice expected to be valid")
+	}
+
+	write("bob:$apr1$salt123$te32nsHkuX3Vl4IZyuP3X.\n", time.Now())
+
+	if f.Validate(context.Background(), "alice", "secret") {
+		t.Fatal("alice expected to be removed")
+	}
+
+	if !f.Validate(context.Background(), "bob", "secret") {
+		t.Fatal("bob expected to be added")
+	}
+}
+
+func TestHashedCredentials_unknownUser(t *testing.T) {
+	creds := HashedCredentials{
+		"bob":   "$apr1$salt123$te32nsHkuX3Vl4IZyuP3X.",
+		"alice": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+	}
+
+	if creds.Validate(context.Background(), "mallory", "secret") {
+		t.Fatal("unknown user expected to be invalid with password of another user")
+	}
+
+	// Unknown usernames pay the cost of a stored hash.
+	if creds.dummyHash() != creds["alice"] {
+		t.Fatalf("unexpected dummy hash %s", creds.dummyHash())
+	}
+
+	if _, err := CheckPasswordHash(HashedCredentials{}.dummyHash(), "secret"); err != nil {
+		t.Fatal(err)
+	}
+}
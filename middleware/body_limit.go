@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// ErrRequestBodyTooLarge is returned by RequestBody reader when body exceeds
+// the limit, it is rendered with 413 Request Entity Too Large by fchi.HandleError.
+var ErrRequestBodyTooLarge error = &fchi.Problem{
+	Status: fasthttp.StatusRequestEntityTooLarge,
+	Detail: "request body too large",
+}
+
+type (
+	bodyLimitMetaKey struct{}
+	ctxKeyBodyLimit  struct{}
+)
+
+// WithBodyLimit sets request body size limit of the route in route metadata,
+// it is used by BodyLimit instead of the default limit.
+//
+//   r.Post("/uploads", middleware.WithBodyLimit(uploadHandler, 100<<20))
+func WithBodyLimit(h fchi.Handler, limit int64) fchi.Handler {
+	return fchi.WithMeta(h, bodyLimitMetaKey{}, limit)
+}
+
+// BodyLimit is a middleware that rejects requests with body larger than limit
+// with 413 Request Entity Too Large, zero or negative limit disables the check.
+//
+// Declared Content-Length is checked before the body is read. With
+// fasthttp.Server.StreamRequestBody enabled, large bodies are not buffered
+// and handlers should read them with RequestBody, which enforces the limit
+// while streaming. Server-wide MaxRequestBodySize should be not less than
+// the largest route limit.
+func BodyLimit(limit int64) func(next fchi.Handler) fchi.Handler {
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			limit := limit
+			if v, ok := fchi.RouteMeta(rc, bodyLimitMetaKey{}); ok {
+				limit = v.(int64)
+			}
+
+			if limit <= 0 {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			tooLarge := int64(rc.Request.Header.ContentLength()) > limit
+			if !tooLarge && !rc.Request.IsBodyStream() {
+				tooLarge = int64(len(rc.Request.Body())) > limit
+			}
+
+			if tooLarge {
+				// Unread body of a streamed request is not drained.
+				rc.SetConnectionClose()
+				fchi.HandleError(ctx, rc, ErrRequestBodyTooLarge)
+
+				return
+			}
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyBodyLimit{}, limit), rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// RequestBody returns a reader of request body, it reads body stream without
+// buffering if request body streaming is enabled.
+//
+// Reader fails with ErrRequestBodyTooLarge when body exceeds the limit of
+// BodyLimit middleware.
+func RequestBody(ctx context.Context, rc *fasthttp.RequestCtx) io.Reader {
+	var r io.Reader
+
+	if rc.Request.IsBodyStream() {
+		r = rc.RequestBodyStream()
+	} else {
+		r = bytes.NewReader(rc.Request.Body())
+	}
+
+	if limit, ok := ctx.Value(ctxKeyBodyLimit{}).(int64); ok {
+		r = &limitedBodyReader{r: r, n: limit}
+	}
+
+	return r
+}
+
+// limitedBodyReader is similar to http.MaxBytesReader.
+type limitedBodyReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitedBodyReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Reading one more byte to find out if body exceeds the limit.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		l.err = err
+
+		return n, err
+	}
+
+	n, l.n, l.err = int(l.n), 0, ErrRequestBodyTooLarge
+
+	return n, l.err
+}
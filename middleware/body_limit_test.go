@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestBodyLimit(t *testing.T) {
+	r := fchi.NewRouter()
+	r.Use(BodyLimit(1 << 10))
+
+	count := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		n, err := io.Copy(ioutil.Discard, RequestBody(ctx, rc))
+		if err != nil {
+			fchi.HandleError(ctx, rc, err)
+
+			return
+		}
+
+		rc.WriteString(strconv.Itoa(int(n)))
+	})
+
+	r.Post("/json", count)
+	r.Post("/uploads", WithBodyLimit(count, 3<<20))
+
+	ln := fasthttputil.NewInmemoryListener()
+	srv := &fasthttp.Server{
+		Handler:            fchi.RequestHandler(r),
+		StreamRequestBody:  true,
+		MaxRequestBodySize: 1 << 20,
+	}
+
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	defer ln.Close()
+
+	client := &fasthttp.Client{Dial: func(addr string) (net.Conn, error) { return ln.Dial() }}
+
+	post := func(path string, size int) (int, string) {
+		req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(resp)
+
+		req.Header.SetMethod("POST")
+		req.SetRequestURI("http://test" + path)
+
+		req.SetBody(make([]byte, size))
+
+		if err := client.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode(), string(resp.Body())
+	}
+
+	for _, c := range []struct {
+		name   string
+		path   string
+		size   int
+		status int
+		body   string
+	}{
+		{name: "small", path: "/json", size: 100, status: 200, body: "100"},
+		{name: "exact", path: "/json", size: 1 << 10, status: 200, body: "1024"},
+		{name: "large", path: "/json", size: 2 << 10, status: 413},
+		{name: "streamed upload", path: "/uploads", size: 2 << 20, status: 200, body: strconv.Itoa(2 << 20)},
+		{name: "large upload", path: "/uploads", size: 4 << 20, status: 413},
+	} {
+		status, body := post(c.path, c.size)
+
+		if status != c.status || (c.body != "" && body != c.body) {
+			t.Errorf("%s: unexpected response %d %q", c.name, status, body)
+		}
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	rc := &fasthttp.RequestCtx{}
+	rc.Request.SetBodyStream(bytes.NewReader(make([]byte, 100)), -1)
+
+	ctx := context.WithValue(context.Background(), ctxKeyBodyLimit{}, int64(50))
+
+	n, err := io.Copy(ioutil.Discard, RequestBody(ctx, rc))
+	if n != 50 || !errors.Is(err, ErrRequestBodyTooLarge) {
+		t.Fatalf("unexpected result %d %v", n, err)
+	}
+}
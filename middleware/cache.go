@@ -0,0 +1,616 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// ResponseCache is a size-bounded LRU storage of responses for Cache middleware.
+//
+// A single ResponseCache can be shared by Cache middlewares of different
+// routes, so that entries can be invalidated by route pattern or tag.
+type ResponseCache struct {
+	maxSize int
+
+	mu       sync.Mutex
+	size     int
+	lru      *list.List
+	entries  map[string]*list.Element
+	varies   map[string]*cacheVary
+	inflight map[string]*cacheCall
+}
+
+// cacheVary keeps request headers that responses of a resource vary by.
+type cacheVary struct {
+	headers []string
+	entries int
+}
+
+// cacheCall is an in-flight handler call that concurrent requests wait for.
+type cacheCall struct {
+	done chan struct{}
+}
+
+type cacheEntry struct {
+	key     string
+	primary string
+	route   string
+	tags    []string
+
+	header fasthttp.ResponseHeader
+	body   []byte
+	size   int
+
+	// shared is set for public responses that can be served to requests with credentials.
+	shared bool
+
+	stored     time.Time
+	expires    time.Time
+	staleUntil time.Time
+}
+
+// NewResponseCache creates response storage with total size limit, default 32 MiB.
+func NewResponseCache(maxSize int) *ResponseCache {
+	if maxSize <= 0 {
+		maxSize = 32 << 20
+	}
+
+	return &ResponseCache{
+		maxSize:  maxSize,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		varies:   make(map[string]*cacheVary),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+// Len returns number of cached responses.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Size returns total size of cached responses.
+func (c *ResponseCache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// InvalidateRoute removes responses of a route pattern, e.g. "/users/{id}",
+// and returns number of removed responses.
+func (c *ResponseCache) InvalidateRoute(pattern string) int {
+	return c.invalidate(func(e *cacheEntry) bool { return e.route == pattern })
+}
+
+// InvalidateTags removes responses with any of the tags and returns number
+// of removed responses, see WithCacheTags and AddCacheTags.
+func (c *ResponseCache) InvalidateTags(tags ...string) int {
+	return c.invalidate(func(e *cacheEntry) bool {
+		for _, t := range e.tags {
+			for _, tag := range tags {
+				if t == tag {
+					return true
+				}
+			}
+		}
+
+		return false
+	})
+}
+
+func (c *ResponseCache) invalidate(match func(e *cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+
+		if match(el.Value.(*cacheEntry)) {
+			c.remove(el)
+			n++
+		}
+
+		el = next
+	}
+
+	return n
+}
+
+// remove must be called with lock.
+func (c *ResponseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+
+	if v := c.varies[e.primary]; v != nil {
+		if v.entries--; v.entries <= 0 {
+			delete(c.varies, e.primary)
+		}
+	}
+}
+
+// key returns full key of a request with values of Vary headers.
+func (c *ResponseCache) key(primary string, rc *fasthttp.RequestCtx) string {
+	c.mu.Lock()
+	v := c.varies[primary]
+	c.mu.Unlock()
+
+	if v == nil {
+		return primary
+	}
+
+	return primary + varyKey(rc, v.headers)
+}
+
+func varyKey(rc *fasthttp.RequestCtx, headers []string) string {
+	sb := strings.Builder{}
+
+	for _, h := range headers {
+		sb.WriteString("\x00")
+		sb.Write(rc.Request.Header.Peek(h))
+	}
+
+	return sb.String()
+}
+
+func (c *ResponseCache) get(key string, now time.Time) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	e := el.Value.(*cacheEntry)
+	if !now.Before(e.staleUntil) {
+		c.remove(el)
+
+		return nil
+	}
+
+	c.lru.MoveToFront(el)
+
+	return e
+}
+
+func (c *ResponseCache) put(e *cacheEntry, vary []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e.size > c.maxSize {
+		return
+	}
+
+	v := c.varies[e.primary]
+	if v == nil {
+		v = &cacheVary{headers: vary}
+		c.varies[e.primary] = v
+	} else if strings.Join(v.headers, ",") != strings.Join(vary, ",") {
+		// Resource changed its Vary headers, previous variants are not reachable anymore.
+		for el := c.lru.Front(); el != nil; {
+			next := el.Next()
+			if el.Value.(*cacheEntry).primary == e.primary {
+				c.remove(el)
+			}
+
+			el = next
+		}
+
+		v = &cacheVary{headers: vary}
+		c.varies[e.primary] = v
+	}
+
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+	v.entries++
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// begin registers an in-flight call for the key, it returns existing call
+// and false if the key is already being served.
+func (c *ResponseCache) begin(key string) (*cacheCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if call, ok := c.inflight[key]; ok {
+		return call, false
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+
+	return call, true
+}
+
+func (c *ResponseCache) end(key string, call *cacheCall) {
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+
+	close(call.done)
+}
+
+// CacheOptions configures Cache middleware.
+type CacheOptions struct {
+	// Cache stores responses, a new cache of default size is used if nil.
+	Cache *ResponseCache
+
+	// TTL is used for responses without max-age or s-maxage, default 1 minute.
+	TTL time.Duration
+
+	// StaleWhileRevalidate is a period after expiration while stale response
+	// is served and refreshed in background, it is used for responses without
+	// stale-while-revalidate directive.
+	StaleWhileRevalidate time.Duration
+
+	// QueryArgs lists query arguments that are part of the cache key,
+	// all arguments are used if nil.
+	QueryArgs []string
+
+	// now is used in tests.
+	now func() time.Time
+}
+
+type (
+	cacheTagsMetaKey struct{}
+	ctxKeyCacheTags  struct{}
+)
+
+// cacheRevalidateUserValueKey marks background revalidation request.
+const cacheRevalidateUserValueKey = "fchiCacheRevalidate"
+
+// WithCacheTags adds tags to cached responses of the route in route metadata,
+// see ResponseCache.InvalidateTags.
+func WithCacheTags(h fchi.Handler, tags ...string) fchi.Handler {
+	return fchi.WithMeta(h, cacheTagsMetaKey{}, tags)
+}
+
+// AddCacheTags adds tags to cached response of the request from the handler,
+// e.g. with identifiers of entities in the response.
+func AddCacheTags(ctx context.Context, tags ...string) {
+	if t, ok := ctx.Value(ctxKeyCacheTags{}).(*[]string); ok {
+		*t = append(*t, tags...)
+	}
+}
+
+// Cache is a middleware that serves GET and HEAD responses from memory.
+//
+// Responses are keyed by method, route pattern, URL parameters, query arguments
+// and request headers listed in Vary response header. Cache-Control directives
+// of request (no-store, no-cache, max-age=0) and response (no-store, no-cache,
+// private, public, max-age, s-maxage, stale-while-revalidate) are honored,
+// responses with Set-Cookie are not cached.
+//
+// Requests with Authorization or Cookie header are only served from cache and
+// stored if response has public or s-maxage directive, RFC 7234 section 3.2.
+//
+// Concurrent requests of a missing response wait for a single handler call.
+// Response header X-Cache is set to HIT, STALE or MISS.
+func Cache(opts CacheOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.Cache == nil {
+		opts.Cache = NewResponseCache(0)
+	}
+
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
+	c := opts.Cache
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			if !rc.IsGet() && !rc.IsHead() {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			reqCC := parseCacheControl(rc.Request.Header.Peek("Cache-Control"))
+			if _, ok := reqCC["no-store"]; ok {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			primary, route := cacheKey(rc, opts.QueryArgs)
+			credentials := hasCredentials(rc)
+			revalidate := rc.UserValue(cacheRevalidateUserValueKey) != nil
+			_, noCache := reqCC["no-cache"]
+
+			if reqCC["max-age"] == "0" {
+				noCache = true
+			}
+
+			for !revalidate && !noCache {
+				now := opts.now()
+				key := c.key(primary, rc)
+
+				if e := c.get(key, now); e != nil && (e.shared || !credentials) {
+					if now.Before(e.expires) {
+						writeCached(rc, e, now, "HIT")
+
+						return
+					}
+
+					writeCached(rc, e, now, "STALE")
+
+					if rctx := fchi.RouteContext(rc); rctx != nil {
+						if h, ok := rctx.Routes.(fchi.Handler); ok {
+							if call, ok := c.begin(key); ok {
+								// Request context is reset and reused after the handler returns.
+								req := &fasthttp.Request{}
+								rc.Request.CopyTo(req)
+
+								go revalidateCached(c, h, req, rc.RemoteAddr(), key, call)
+							}
+						}
+					}
+
+					return
+				}
+
+				if credentials {
+					// Private response of other principal is not awaited.
+					break
+				}
+
+				call, first := c.begin(key)
+				if first {
+					defer c.end(key, call)
+
+					break
+				}
+
+				select {
+				case <-call.done:
+				case <-ctx.Done():
+					fchi.HandleError(ctx, rc, ctx.Err())
+
+					return
+				}
+
+				// Waiting for stored response, or calling handler if it was not cacheable.
+				if c.get(c.key(primary, rc), opts.now()) == nil {
+					break
+				}
+			}
+
+			var tags []string
+			if t, ok := fchi.RouteMeta(rc, cacheTagsMetaKey{}); ok {
+				tags = append(tags, t.([]string)...)
+			}
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyCacheTags{}, &tags), rc)
+
+			if e, vary := newCacheEntry(rc, opts, opts.now()); e != nil {
+				e.primary, e.route, e.tags = primary, route, tags
+				e.key = primary + varyKey(rc, vary)
+
+				c.put(e, vary)
+			}
+
+			if !revalidate {
+				rc.Response.Header.Set("X-Cache", "MISS")
+			}
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// revalidateCached refreshes stale response in background by serving a copy
+// of the request with the router.
+func revalidateCached(c *ResponseCache, h fchi.Handler, req *fasthttp.Request, remoteAddr net.Addr, key string, call *cacheCall) {
+	defer c.end(key, call)
+
+	req.Header.Del("Cache-Control")
+
+	bg := &fasthttp.RequestCtx{}
+	bg.Init(req, remoteAddr, nil)
+	bg.SetUserValue(cacheRevalidateUserValueKey, true)
+
+	h.ServeHTTP(context.Background(), bg)
+}
+
+// cacheKey returns primary key and route pattern of the request.
+func cacheKey(rc *fasthttp.RequestCtx, queryArgs []string) (key, route string) {
+	sb := strings.Builder{}
+	sb.Write(rc.Method())
+	sb.WriteString("\x00")
+
+	path := string(rc.URI().PathOriginal())
+	if path == "" {
+		path = "/"
+	}
+
+	var params fchi.RouteParams
+
+	if rctx := fchi.RouteContext(rc); rctx != nil && rctx.Routes != nil {
+		mctx := fchi.NewRouteContext()
+		if rctx.Routes.Match(mctx, string(rc.Method()), path) {
+			route, params = mctx.RoutePattern(), mctx.URLParams
+		}
+	}
+
+	if route != "" {
+		sb.WriteString(route)
+
+		for i, k := range params.Keys {
+			sb.WriteString("\x00" + k + "=" + params.Values[i])
+		}
+	} else {
+		sb.WriteString(path)
+	}
+
+	var args []string
+
+	rc.QueryArgs().VisitAll(func(k, v []byte) {
+		if queryArgs == nil {
+			args = append(args, string(k)+"="+string(v))
+
+			return
+		}
+
+		for _, a := range queryArgs {
+			if a == string(k) {
+				args = append(args, string(k)+"="+string(v))
+			}
+		}
+	})
+
+	sort.Strings(args)
+
+	sb.WriteString("?" + strings.Join(args, "&"))
+
+	return sb.String(), route
+}
+
+// hasCredentials checks if request is authenticated with Authorization or Cookie header.
+func hasCredentials(rc *fasthttp.RequestCtx) bool {
+	return len(rc.Request.Header.Peek(fasthttp.HeaderAuthorization)) > 0 ||
+		len(rc.Request.Header.Peek(fasthttp.HeaderCookie)) > 0
+}
+
+// cacheableStatuses are cacheable by default, see RFC 7231 section 6.1.
+var cacheableStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// newCacheEntry creates an entry from response if it is cacheable.
+func newCacheEntry(rc *fasthttp.RequestCtx, opts CacheOptions, now time.Time) (*cacheEntry, []string) {
+	resp := &rc.Response
+
+	if !cacheableStatuses[resp.StatusCode()] || len(resp.Header.Peek("Set-Cookie")) > 0 {
+		return nil, nil
+	}
+
+	cc := parseCacheControl(resp.Header.Peek("Cache-Control"))
+
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return nil, nil
+		}
+	}
+
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	shared := public || sMaxAge
+
+	if !shared && hasCredentials(rc) {
+		return nil, nil
+	}
+
+	vary := strings.Split(string(resp.Header.Peek("Vary")), ",")
+	for i, h := range vary {
+		vary[i] = http.CanonicalHeaderKey(strings.TrimSpace(h))
+
+		if vary[i] == "*" {
+			return nil, nil
+		}
+	}
+
+	if len(vary) == 1 && vary[0] == "" {
+		vary = nil
+	}
+
+	sort.Strings(vary)
+
+	ttl, swr := opts.TTL, opts.StaleWhileRevalidate
+
+	if s, ok := cc["s-maxage"]; ok {
+		ttl = parseSeconds(s)
+	} else if s, ok := cc["max-age"]; ok {
+		ttl = parseSeconds(s)
+	}
+
+	if s, ok := cc["stale-while-revalidate"]; ok {
+		swr = parseSeconds(s)
+	}
+
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	if resp.IsBodyStream() {
+		// Streams of unknown size are not buffered.
+		if resp.Header.ContentLength() < 0 {
+			return nil, nil
+		}
+	}
+
+	e := &cacheEntry{stored: now, expires: now.Add(ttl), staleUntil: now.Add(ttl + swr), shared: shared}
+	e.body = append([]byte(nil), resp.Body()...)
+	resp.Header.CopyTo(&e.header)
+	e.header.Del("X-Cache")
+	e.size = len(e.body) + len(e.header.Header())
+
+	return e, vary
+}
+
+func writeCached(rc *fasthttp.RequestCtx, e *cacheEntry, now time.Time, status string) {
+	e.header.CopyTo(&rc.Response.Header)
+	rc.Response.SetBody(e.body)
+	rc.Response.Header.Set("Age", strconv.Itoa(int(now.Sub(e.stored)/time.Second)))
+	rc.Response.Header.Set("X-Cache", status)
+}
+
+func parseCacheControl(v []byte) map[string]string {
+	if len(v) == 0 {
+		return nil
+	}
+
+	cc := make(map[string]string)
+
+	for _, d := range strings.Split(string(v), ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+
+		name, val := d, ""
+		if i := strings.IndexByte(d, '='); i >= 0 {
+			name, val = d[:i], strings.Trim(d[i+1:], `"`)
+		}
+
+		cc[strings.ToLower(name)] = val
+	}
+
+	return cc
+}
+
+func parseSeconds(s string) time.Duration {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return time.Duration(n) * time.Second
+}
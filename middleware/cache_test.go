@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCache(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		now   = time.Now()
+		calls int64
+	)
+
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return now
+	}
+
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now = now.Add(d)
+	}
+
+	store := NewResponseCache(0)
+
+	r := fchi.NewRouter()
+	r.Use(Cache(CacheOptions{Cache: store, TTL: time.Minute, QueryArgs: []string{"page"}, now: clock}))
+
+	r.Get("/users/{id}", WithCacheTags(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		n := atomic.AddInt64(&calls, 1)
+
+		AddCacheTags(ctx, "user:"+fchi.URLParam(rc, "id"))
+		rc.Response.Header.Set("Cache-Control", "max-age=10, stale-while-revalidate=20")
+		rc.WriteString(fchi.URLParam(rc, "id") + ":" + string(rc.QueryArgs().Peek("page")) + ":" + strconv.Itoa(int(n)))
+	}), "users"))
+
+	r.Get("/lang", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		atomic.AddInt64(&calls, 1)
+		rc.Response.Header.Set("Vary", "Accept-Language")
+		rc.Write(rc.Request.Header.Peek("Accept-Language"))
+	}))
+
+	r.Get("/private", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		atomic.AddInt64(&calls, 1)
+		rc.Response.Header.Set("Cache-Control", "private")
+	}))
+
+	request := func(path string, headers ...string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.SetRequestURI(path)
+
+		for i := 0; i < len(headers); i += 2 {
+			rc.Request.Header.Set(headers[i], headers[i+1])
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	expect := func(name string, rc *fasthttp.RequestCtx, body, status string) {
+		t.Helper()
+
+		if string(rc.Response.Body()) != body || string(rc.Response.Header.Peek("X-Cache")) != status {
+			t.Errorf("%s: unexpected response %q %s", name, rc.Response.Body(), rc.Response.Header.Peek("X-Cache"))
+		}
+	}
+
+	expect("miss", request("/users/1?page=2&utm=a"), "1:2:1", "MISS")
+	expect("hit", request("/users/1?utm=b&page=2"), "1:2:1", "HIT")
+	expect("other query", request("/users/1?page=3"), "1:3:2", "MISS")
+	expect("other param", request("/users/2?page=2"), "2:2:3", "MISS")
+	expect("request no-cache", request("/users/1?page=2", "Cache-Control", "no-cache"), "1:2:4", "MISS")
+	expect("refreshed", request("/users/1?page=2"), "1:2:4", "HIT")
+
+	expect("vary en", request("/lang", "Accept-Language", "en"), "en", "MISS")
+	expect("vary de", request("/lang", "Accept-Language", "de"), "de", "MISS")
+	expect("vary en hit", request("/lang", "Accept-Language", "en"), "en", "HIT")
+	expect("vary de hit", request("/lang", "Accept-Language", "de"), "de", "HIT")
+
+	request("/private")
+	expect("private", request("/private"), "", "MISS")
+
+	// Stale response is served while it is refreshed in background.
+	atomic.StoreInt64(&calls, 10)
+	advance(15 * time.Second)
+
+	if rc := request("/users/1?page=2"); string(rc.Response.Header.Peek("X-Cache")) != "STALE" ||
+		string(rc.Response.Body()) != "1:2:4" {
+		t.Fatalf("stale response expected, %q %s", rc.Response.Body(), rc.Response.Header.Peek("X-Cache"))
+	}
+
+	waitFor(t, func() bool {
+		return string(request("/users/1?page=2").Response.Body()) == "1:2:11"
+	})
+
+	advance(time.Minute)
+	expect("expired", request("/users/1?page=2"), "1:2:12", "MISS")
+
+	if n := store.InvalidateTags("user:2"); n != 1 {
+		t.Errorf("unexpected number of invalidated entries by tag: %d", n)
+	}
+
+	if n := store.InvalidateRoute("/users/{id}"); n != 2 {
+		t.Errorf("unexpected number of invalidated entries by route: %d", n)
+	}
+
+	expect("invalidated", request("/users/1?page=2"), "1:2:13", "MISS")
+
+	if n := store.InvalidateTags("users"); n != 1 {
+		t.Errorf("unexpected number of invalidated entries by route tag: %d", n)
+	}
+}
+
+func TestCache_coalescing(t *testing.T) {
+	var calls int64
+
+	release := make(chan struct{})
+
+	h := Cache(CacheOptions{})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		rc.WriteString("ok")
+	}))
+
+	var (
+		wg     sync.WaitGroup
+		bodies = make([]string, 5)
+	)
+
+	for i := range bodies {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			rc := &fasthttp.RequestCtx{}
+			rc.Request.SetRequestURI("/")
+			h.ServeHTTP(context.Background(), rc)
+
+			bodies[i] = string(rc.Response.Body())
+		}(i)
+	}
+
+	waitFor(t, func() bool { return atomic.LoadInt64(&calls) == 1 })
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 || strings.Join(bodies, ",") != "ok,ok,ok,ok,ok" {
+		t.Fatalf("single call expected, %d calls, %v", calls, bodies)
+	}
+}
+
+func TestCache_credentials(t *testing.T) {
+	h := Cache(CacheOptions{})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		if string(rc.Path()) == "/public" {
+			rc.Response.Header.Set("Cache-Control", "public, max-age=10")
+			rc.WriteString("public")
+
+			return
+		}
+
+		rc.Write(rc.Request.Header.Peek("Authorization"))
+		rc.Write(rc.Request.Header.Peek("Cookie"))
+	}))
+
+	request := func(path, header, value string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.SetRequestURI(path)
+		rc.Request.Header.Set(header, value)
+		h.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	for _, header := range []string{"Authorization", "Cookie"} {
+		for _, user := range []string{"alice", "bob", "alice"} {
+			rc := request("/me", header, user)
+
+			if string(rc.Response.Body()) != user || string(rc.Response.Header.Peek("X-Cache")) != "MISS" {
+				t.Errorf("%s %s: unexpected response %q %s", header, user,
+					rc.Response.Body(), rc.Response.Header.Peek("X-Cache"))
+			}
+		}
+	}
+
+	request("/public", "Authorization", "alice")
+
+	if rc := request("/public", "Authorization", "bob"); string(rc.Response.Header.Peek("X-Cache")) != "HIT" {
+		t.Errorf("public response expected from cache, %q %s", rc.Response.Body(), rc.Response.Header.Peek("X-Cache"))
+	}
+}
+
+func TestResponseCache_eviction(t *testing.T) {
+	store := NewResponseCache(1000)
+	h := Cache(CacheOptions{Cache: store})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString(strings.Repeat("x", 300))
+	}))
+
+	for i := 0; i < 10; i++ {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.SetRequestURI("/" + strconv.Itoa(i))
+		h.ServeHTTP(context.Background(), rc)
+	}
+
+	if store.Size() > 1000 || store.Len() == 0 || store.Len() >= 10 {
+		t.Fatalf("unexpected cache size %d with %d entries", store.Size(), store.Len())
+	}
+}
@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// CORSOptions configures CORS middleware.
+type CORSOptions struct {
+	// AllowedOrigins is a list of allowed origins, e.g. "https://example.com".
+	// An origin can have one wildcard, e.g. "https://*.example.com", "*" allows all origins.
+	AllowedOrigins []string
+
+	// AllowedOriginPatterns is a list of regular expressions of allowed origins.
+	AllowedOriginPatterns []*regexp.Regexp
+
+	// AllowOriginFunc is a custom origin check, origin is allowed if any of checks pass.
+	AllowOriginFunc func(rc *fasthttp.RequestCtx, origin string) bool
+
+	// AllowedMethods limits methods available for cross-origin requests,
+	// default is all methods that have a route for the requested path.
+	AllowedMethods []string
+
+	// AllowedHeaders is a list of request headers allowed in preflight,
+	// default is to allow headers requested by client.
+	AllowedHeaders []string
+
+	// ExposedHeaders is a list of response headers available to client.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies and authorization.
+	AllowCredentials bool
+
+	// MaxAge defines how long preflight response can be cached by client.
+	MaxAge time.Duration
+}
+
+// corsMethods are the candidates of Access-Control-Allow-Methods.
+var corsMethods = []string{
+	fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodPost, fasthttp.MethodPut,
+	fasthttp.MethodPatch, fasthttp.MethodDelete, fasthttp.MethodOptions,
+}
+
+// CORS is a middleware that implements Cross-Origin Resource Sharing.
+//
+// Preflight requests are answered by the middleware with 204 No Content,
+// Access-Control-Allow-Methods lists methods that have a route for the
+// requested path, so CORS should be added with Use of a router. Responses to
+// requests with Origin header have "Vary: Origin" whether origin is allowed or not.
+//
+//  r.Use(middleware.CORS(middleware.CORSOptions{
+//    AllowedOrigins:   []string{"https://*.example.com"},
+//    AllowCredentials: true,
+//    MaxAge:           time.Hour,
+//  }))
+func CORS(opts CORSOptions) func(next fchi.Handler) fchi.Handler {
+	c := cors{opts: opts}
+
+	for _, o := range opts.AllowedOrigins {
+		o = strings.ToLower(o)
+
+		switch {
+		case o == "*":
+			c.allowAll = true
+		case strings.Contains(o, "*"):
+			i := strings.Index(o, "*")
+			c.wildcards = append(c.wildcards, [2]string{o[:i], o[i+1:]})
+		default:
+			c.origins = append(c.origins, o)
+		}
+	}
+
+	if len(opts.AllowedHeaders) > 0 {
+		c.allowedHeaders = strings.Join(opts.AllowedHeaders, ", ")
+	}
+
+	if len(opts.ExposedHeaders) > 0 {
+		c.exposedHeaders = strings.Join(opts.ExposedHeaders, ", ")
+	}
+
+	if opts.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			origin := string(rc.Request.Header.Peek("Origin"))
+
+			if rc.IsOptions() && origin != "" && len(rc.Request.Header.Peek("Access-Control-Request-Method")) > 0 {
+				c.preflight(rc, origin)
+
+				return
+			}
+
+			if origin == "" {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			// Response depends on Origin even if it is not allowed, so that
+			// shared caches do not serve it to allowed origins.
+			allowed := c.allowed(rc, origin)
+
+			rc.Response.Header.Add("Vary", "Origin")
+
+			if allowed {
+				c.setHeaders(rc, origin)
+			}
+
+			next.ServeHTTP(ctx, rc)
+
+			// Headers are lost if handler used rc.Error or reset response.
+			if !varyOrigin(&rc.Response.Header) {
+				rc.Response.Header.Add("Vary", "Origin")
+			}
+
+			if allowed && len(rc.Response.Header.Peek("Access-Control-Allow-Origin")) == 0 {
+				c.setHeaders(rc, origin)
+			}
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+type cors struct {
+	opts           CORSOptions
+	allowAll       bool
+	origins        []string
+	wildcards      [][2]string
+	allowedHeaders string
+	exposedHeaders string
+	maxAge         string
+}
+
+func (c cors) allowed(rc *fasthttp.RequestCtx, origin string) bool {
+	if c.allowAll {
+		return true
+	}
+
+	o := strings.ToLower(origin)
+
+	for _, a := range c.origins {
+		if a == o {
+			return true
+		}
+	}
+
+	for _, w := range c.wildcards {
+		if len(o) > len(w[0])+len(w[1]) && strings.HasPrefix(o, w[0]) && strings.HasSuffix(o, w[1]) {
+			return true
+		}
+	}
+
+	for _, p := range c.opts.AllowedOriginPatterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+
+	return c.opts.AllowOriginFunc != nil && c.opts.AllowOriginFunc(rc, origin)
+}
+
+func (c cors) setHeaders(rc *fasthttp.RequestCtx, origin string) {
+	h := &rc.Response.Header
+
+	if c.allowAll && !c.opts.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.opts.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if c.exposedHeaders != "" {
+		h.Set("Access-Control-Expose-Headers", c.exposedHeaders)
+	}
+}
+
+// varyOrigin checks if Vary response headers list Origin.
+func varyOrigin(h *fasthttp.ResponseHeader) bool {
+	found := false
+
+	h.VisitAll(func(k, v []byte) {
+		if !strings.EqualFold(string(k), "Vary") {
+			return
+		}
+
+		for _, s := range strings.Split(string(v), ",") {
+			if strings.EqualFold(strings.TrimSpace(s), "Origin") {
+				found = true
+			}
+		}
+	})
+
+	return found
+}
+
+// preflight answers preflight request, CORS headers are omitted if request is not allowed.
+func (c cors) preflight(rc *fasthttp.RequestCtx, origin string) {
+	h := &rc.Response.Header
+
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	rc.SetStatusCode(fasthttp.StatusNoContent)
+
+	if !c.allowed(rc, origin) {
+		return
+	}
+
+	requested := string(rc.Request.Header.Peek("Access-Control-Request-Method"))
+	methods := c.routeMethods(rc, requested)
+
+	found := false
+
+	for _, m := range methods {
+		if m == requested {
+			found = true
+
+			break
+		}
+	}
+
+	if !found {
+		return
+	}
+
+	c.setHeaders(rc, origin)
+	h.Del("Access-Control-Expose-Headers")
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	if c.allowedHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", c.allowedHeaders)
+	} else if reqHeaders := rc.Request.Header.Peek("Access-Control-Request-Headers"); len(reqHeaders) > 0 {
+		h.SetBytesV("Access-Control-Allow-Headers", reqHeaders)
+	}
+
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+}
+
+// routeMethods returns allowed methods that have a route for the request path.
+func (c cors) routeMethods(rc *fasthttp.RequestCtx, requested string) []string {
+	candidates := corsMethods
+	if len(c.opts.AllowedMethods) > 0 {
+		candidates = c.opts.AllowedMethods
+	} else if !isCORSMethod(requested) {
+		candidates = append(append([]string(nil), corsMethods...), requested)
+	}
+
+	rctx := fchi.RouteContext(rc)
+	if rctx == nil || rctx.Routes == nil {
+		return candidates
+	}
+
+	path := string(rc.URI().PathOriginal())
+	methods := make([]string, 0, len(candidates))
+
+	for _, m := range candidates {
+		if rctx.Routes.Match(fchi.NewRouteContext(), m, path) {
+			methods = append(methods, m)
+		}
+	}
+
+	return methods
+}
+
+func isCORSMethod(method string) bool {
+	for _, m := range corsMethods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
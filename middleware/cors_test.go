@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCORS(t *testing.T) {
+	r := fchi.NewRouter()
+	r.Use(CORS(CORSOptions{
+		AllowedOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+		AllowOriginFunc: func(rc *fasthttp.RequestCtx, origin string) bool {
+			return origin == "https://partner.test"
+		},
+		ExposedHeaders:   []string{"X-Total"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}))
+
+	h := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		if fchi.URLParam(rc, "id") == "0" {
+			rc.Error("not found", fasthttp.StatusNotFound)
+		}
+	})
+
+	r.Get("/users", h)
+	r.Post("/users", h)
+	r.Route("/users/{id}", func(r fchi.Router) {
+		r.Get("/", h)
+		r.Delete("/", h)
+	})
+
+	request := func(method, path, origin, reqMethod string) *fasthttp.ResponseHeader {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(method)
+		rc.Request.SetRequestURI(path)
+		rc.Request.Header.Set("Origin", origin)
+
+		if reqMethod != "" {
+			rc.Request.Header.Set("Access-Control-Request-Method", reqMethod)
+			rc.Request.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return &rc.Response.Header
+	}
+
+	for _, c := range []struct {
+		method, path, origin, reqMethod string
+		allowOrigin, allowMethods       string
+		status                          int
+	}{
+		{method: "GET", path: "/users", origin: "https://example.com", allowOrigin: "https://example.com", status: 200},
+		{method: "GET", path: "/users/0", origin: "https://a.example.org", allowOrigin: "https://a.example.org", status: 404},
+		{method: "GET", path: "/users", origin: "https://example.org", status: 200},
+		{method: "GET", path: "/users/0", origin: "https://evil.test", status: 404},
+		{method: "GET", path: "/users", origin: "http://localhost:8080", allowOrigin: "http://localhost:8080", status: 200},
+		{method: "GET", path: "/users", origin: "https://partner.test", allowOrigin: "https://partner.test", status: 200},
+		{
+			method: "OPTIONS", path: "/users", origin: "https://example.com", reqMethod: "POST",
+			allowOrigin: "https://example.com", allowMethods: "GET, POST", status: 204,
+		},
+		{
+			method: "OPTIONS", path: "/users/1", origin: "https://example.com", reqMethod: "DELETE",
+			allowOrigin: "https://example.com", allowMethods: "GET, DELETE", status: 204,
+		},
+		{method: "OPTIONS", path: "/users/1", origin: "https://example.com", reqMethod: "PUT", status: 204},
+		{method: "OPTIONS", path: "/users", origin: "https://evil.test", reqMethod: "GET", status: 204},
+	} {
+		h := request(c.method, c.path, c.origin, c.reqMethod)
+
+		if h.StatusCode() != c.status || string(h.Peek("Access-Control-Allow-Origin")) != c.allowOrigin ||
+			string(h.Peek("Access-Control-Allow-Methods")) != c.allowMethods {
+			t.Errorf("%s %s %s: unexpected response:\n%s", c.method, c.path, c.origin, h.String())
+
+			continue
+		}
+
+		if !varyOrigin(h) {
+			t.Errorf("%s %s %s: Vary: Origin expected:\n%s", c.method, c.path, c.origin, h.String())
+		}
+
+		if c.allowOrigin == "" {
+			continue
+		}
+
+		if string(h.Peek("Access-Control-Allow-Credentials")) != "true" {
+			t.Errorf("%s %s: credentials expected", c.method, c.path)
+		}
+
+		if c.reqMethod == "" && string(h.Peek("Access-Control-Expose-Headers")) != "X-Total" {
+			t.Errorf("%s %s: exposed headers expected", c.method, c.path)
+		}
+
+		if c.reqMethod != "" && (string(h.Peek("Access-Control-Max-Age")) != "3600" ||
+			string(h.Peek("Access-Control-Allow-Headers")) != "Content-Type") {
+			t.Errorf("%s %s: unexpected preflight headers:\n%s", c.method, c.path, h.String())
+		}
+	}
+}
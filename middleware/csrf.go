@@ -0,0 +1,336 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// CSRF validation errors, available in context of CSRFOptions.FailureHandler with CSRFFailureReason.
+var (
+	ErrCSRFTokenMissing   = errors.New("csrf token missing")
+	ErrCSRFTokenInvalid   = errors.New("csrf token invalid")
+	ErrCSRFOriginMismatch = errors.New("csrf origin mismatch")
+)
+
+// CSRFOptions configures CSRF middleware.
+type CSRFOptions struct {
+	// Key is a secret to sign tokens with HMAC-SHA256, required.
+	Key []byte
+
+	// SessionID enables synchronizer token pattern, tokens are bound to the
+	// session ID and no CSRF cookie is used. Requests with empty session ID
+	// fail validation.
+	//
+	// By default, signed double-submit cookie pattern is used. Such tokens are
+	// not bound to a session: a token issued to any visitor is valid for any
+	// other visitor that has it in the cookie, so an attacker that can set
+	// cookies for the domain, e.g. from a subdomain, can forge requests.
+	// SessionID should be used for authenticated sessions.
+	SessionID func(ctx context.Context, rc *fasthttp.RequestCtx) string
+
+	// HeaderName is a request header with token, default "X-CSRF-Token".
+	HeaderName string
+
+	// FormField is a form field with token, default "csrf_token".
+	FormField string
+
+	// Cookie attributes of double-submit token, default name is "_csrf",
+	// path "/", SameSite is Lax and MaxAge is 12 hours.
+	CookieName     string
+	CookiePath     string
+	CookieDomain   string
+	CookieSecure   bool
+	CookieSameSite fasthttp.CookieSameSite
+	CookieMaxAge   time.Duration
+
+	// TrustedOrigins are allowed in Origin header of requests besides the
+	// origin of the request host, e.g. "https://admin.example.com".
+	TrustedOrigins []string
+
+	// FailureHandler responds to rejected requests, default is 403 Forbidden
+	// rendered with fchi.HandleError.
+	FailureHandler fchi.Handler
+}
+
+type (
+	ctxKeyCSRFToken   struct{}
+	ctxKeyCSRFFailure struct{}
+	csrfExemptMetaKey struct{}
+)
+
+// CSRFToken returns a token to embed into forms or to send in the header,
+// it is available in context of handlers protected with CSRF middleware.
+func CSRFToken(ctx context.Context) string {
+	tok, _ := ctx.Value(ctxKeyCSRFToken{}).(string)
+
+	return tok
+}
+
+// CSRFFailureReason returns the error of rejected request in FailureHandler.
+func CSRFFailureReason(ctx context.Context) error {
+	err, _ := ctx.Value(ctxKeyCSRFFailure{}).(error)
+
+	return err
+}
+
+// WithCSRFExempt disables CSRF validation of the route in route metadata,
+// e.g. for a webhook under a router with CSRF middleware.
+//
+// Alternatively, CSRF middleware can be applied to a subset of routes with Group or With.
+func WithCSRFExempt(h fchi.Handler) fchi.Handler {
+	return fchi.WithMeta(h, csrfExemptMetaKey{}, true)
+}
+
+// CSRF is a middleware that protects requests with unsafe methods from
+// cross-site request forgery.
+//
+// Unsafe requests must have a token from CSRFToken in header or form field,
+// cross-origin requests are rejected based on Sec-Fetch-Site and Origin headers.
+// Host of Origin is compared with request host regardless of scheme, so that
+// requests behind TLS-terminating proxy are accepted.
+//
+//  csrf := middleware.CSRF(middleware.CSRFOptions{Key: secret})
+//
+//  r.Group(func(r fchi.Router) {
+//    r.Use(csrf)
+//    r.Get("/settings", settingsForm)
+//    r.Post("/settings", saveSettings)
+//  })
+func CSRF(opts CSRFOptions) func(next fchi.Handler) fchi.Handler {
+	if len(opts.Key) == 0 {
+		panic("chi/middleware: CSRF expects non-empty key")
+	}
+
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-CSRF-Token"
+	}
+
+	if opts.FormField == "" {
+		opts.FormField = "csrf_token"
+	}
+
+	if opts.CookieName == "" {
+		opts.CookieName = "_csrf"
+	}
+
+	if opts.CookiePath == "" {
+		opts.CookiePath = "/"
+	}
+
+	if opts.CookieSameSite == fasthttp.CookieSameSiteDisabled {
+		opts.CookieSameSite = fasthttp.CookieSameSiteLaxMode
+	}
+
+	if opts.CookieMaxAge == 0 {
+		opts.CookieMaxAge = 12 * time.Hour
+	}
+
+	if opts.FailureHandler == nil {
+		opts.FailureHandler = fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			fchi.HandleError(ctx, rc, &fchi.Problem{
+				Status: fasthttp.StatusForbidden,
+				Detail: CSRFFailureReason(ctx).Error(),
+			})
+		})
+	}
+
+	c := csrf{opts: opts}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			if exempt, _ := fchi.RouteMeta(rc, csrfExemptMetaKey{}); exempt == true {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			sessionID := ""
+			if opts.SessionID != nil {
+				sessionID = opts.SessionID(ctx, rc)
+			}
+
+			token := c.token(rc, sessionID)
+
+			if !isSafeMethod(rc) {
+				if err := c.validate(rc, sessionID, token); err != nil {
+					ctx = context.WithValue(ctx, ctxKeyCSRFFailure{}, err)
+					opts.FailureHandler.ServeHTTP(ctx, rc)
+
+					return
+				}
+			}
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyCSRFToken{}, token), rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+type csrf struct {
+	opts CSRFOptions
+}
+
+func isSafeMethod(rc *fasthttp.RequestCtx) bool {
+	return rc.IsGet() || rc.IsHead() || rc.IsOptions() || rc.IsTrace()
+}
+
+// token returns a valid token for the request, double-submit cookie is issued if missing.
+func (c csrf) token(rc *fasthttp.RequestCtx, sessionID string) string {
+	if c.opts.SessionID != nil {
+		return c.sign(sessionID, nonce())
+	}
+
+	if tok := string(rc.Request.Header.Cookie(c.opts.CookieName)); c.verify(tok, "") {
+		return tok
+	}
+
+	tok := c.sign("", nonce())
+
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+
+	cookie.SetKey(c.opts.CookieName)
+	cookie.SetValue(tok)
+	cookie.SetPath(c.opts.CookiePath)
+	cookie.SetDomain(c.opts.CookieDomain)
+	cookie.SetHTTPOnly(true)
+	cookie.SetSecure(c.opts.CookieSecure)
+	cookie.SetSameSite(c.opts.CookieSameSite)
+	cookie.SetMaxAge(int(c.opts.CookieMaxAge.Seconds()))
+	rc.Response.Header.SetCookie(cookie)
+
+	// Making new token available for validation of this request.
+	rc.Request.Header.SetCookie(c.opts.CookieName, tok)
+
+	return tok
+}
+
+func (c csrf) validate(rc *fasthttp.RequestCtx, sessionID, cookieToken string) error {
+	if err := c.checkOrigin(rc); err != nil {
+		return err
+	}
+
+	tok := string(rc.Request.Header.Peek(c.opts.HeaderName))
+	if tok == "" {
+		tok = string(rc.PostArgs().Peek(c.opts.FormField))
+	}
+
+	if tok == "" {
+		if form, err := rc.MultipartForm(); err == nil && len(form.Value[c.opts.FormField]) > 0 {
+			tok = form.Value[c.opts.FormField][0]
+		}
+	}
+
+	if tok == "" {
+		return ErrCSRFTokenMissing
+	}
+
+	if c.opts.SessionID != nil {
+		if sessionID == "" || !c.verify(tok, sessionID) {
+			return ErrCSRFTokenInvalid
+		}
+
+		return nil
+	}
+
+	// Double-submit token is issued with the request if cookie is missing,
+	// so the submitted one can not match it.
+	if subtle.ConstantTimeCompare([]byte(tok), []byte(cookieToken)) != 1 {
+		return ErrCSRFTokenInvalid
+	}
+
+	return nil
+}
+
+// checkOrigin rejects cross-origin requests by Sec-Fetch-Site and Origin headers.
+func (c csrf) checkOrigin(rc *fasthttp.RequestCtx) error {
+	origin := string(rc.Request.Header.Peek("Origin"))
+
+	switch string(rc.Request.Header.Peek("Sec-Fetch-Site")) {
+	case "", "same-origin", "none":
+	default:
+		if !c.trusted(origin) {
+			return ErrCSRFOriginMismatch
+		}
+	}
+
+	if origin == "" {
+		return nil
+	}
+
+	// Scheme is not known behind TLS-terminating proxy.
+	if i := strings.Index(origin, "://"); i > 0 && strings.EqualFold(origin[i+3:], string(rc.Host())) {
+		return nil
+	}
+
+	if c.trusted(origin) {
+		return nil
+	}
+
+	return ErrCSRFOriginMismatch
+}
+
+func (c csrf) trusted(origin string) bool {
+	for _, o := range c.opts.TrustedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sign makes a token of nonce and its signature bound to the session.
+func (c csrf) sign(sessionID string, n []byte) string {
+	return base64.RawURLEncoding.EncodeToString(n) + "." +
+		base64.RawURLEncoding.EncodeToString(c.mac(sessionID, n))
+}
+
+func (c csrf) verify(tok, sessionID string) bool {
+	i := strings.IndexByte(tok, '.')
+	if i < 0 {
+		return false
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(tok[:i])
+	if err != nil {
+		return false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(tok[i+1:])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(sig, c.mac(sessionID, n))
+}
+
+func (c csrf) mac(sessionID string, n []byte) []byte {
+	m := hmac.New(sha256.New, c.opts.Key)
+	_, _ = m.Write([]byte(sessionID))
+	_, _ = m.Write([]byte{0})
+	_, _ = m.Write(n)
+
+	return m.Sum(nil)
+}
+
+func nonce() []byte {
+	n := make([]byte, 16)
+
+	if _, err := rand.Read(n); err != nil {
+		panic("chi/middleware: failed to read random bytes: " + err.Error())
+	}
+
+	return n
+}
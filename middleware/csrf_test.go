@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCSRF(t *testing.T) {
+	key := []byte("secret")
+
+	var failure error
+
+	h := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString(CSRFToken(ctx))
+	})
+
+	r := fchi.NewRouter()
+	r.Use(CSRF(CSRFOptions{
+		Key:            key,
+		TrustedOrigins: []string{"https://admin.example.com"},
+		FailureHandler: fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			failure = CSRFFailureReason(ctx)
+			rc.SetStatusCode(fasthttp.StatusForbidden)
+		}),
+	}))
+	r.Get("/form", h)
+	r.Post("/form", h)
+	r.Post("/webhook", WithCSRFExempt(h))
+
+	request := func(method, path string, prepare func(req *fasthttp.Request)) *fasthttp.RequestCtx {
+		failure = nil
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(method)
+		rc.Request.SetRequestURI(path)
+		rc.Request.SetHost("example.com")
+
+		if prepare != nil {
+			prepare(&rc.Request)
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	rc := request("GET", "/form", nil)
+	token := string(rc.Response.Body())
+	cookie := string(rc.Response.Header.PeekCookie("_csrf"))
+
+	if token == "" || cookie == "" {
+		t.Fatalf("token and cookie expected, %q %q", token, cookie)
+	}
+
+	// Cookie is reused.
+	if rc := request("GET", "/form", func(req *fasthttp.Request) {
+		req.Header.SetCookie("_csrf", token)
+	}); string(rc.Response.Body()) != token || len(rc.Response.Header.PeekCookie("_csrf")) != 0 {
+		t.Fatal("existing token expected")
+	}
+
+	forged := CSRF(CSRFOptions{Key: []byte("other")})
+
+	var forgedToken string
+
+	forged(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		forgedToken = CSRFToken(ctx)
+	})).ServeHTTP(context.Background(), &fasthttp.RequestCtx{})
+
+	for _, c := range []struct {
+		name    string
+		path    string
+		prepare func(req *fasthttp.Request)
+		err     error
+	}{
+		{name: "missing token", path: "/form", err: ErrCSRFTokenMissing, prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+		}},
+		{name: "header token", path: "/form", prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.Set("X-CSRF-Token", token)
+		}},
+		{name: "form token", path: "/form", prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.SetContentType("application/x-www-form-urlencoded")
+			req.SetBodyString("csrf_token=" + token)
+		}},
+		{name: "no cookie", path: "/form", err: ErrCSRFTokenInvalid, prepare: func(req *fasthttp.Request) {
+			req.Header.Set("X-CSRF-Token", token)
+		}},
+		{name: "forged cookie", path: "/form", err: ErrCSRFTokenInvalid, prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", forgedToken)
+			req.Header.Set("X-CSRF-Token", forgedToken)
+		}},
+		{name: "cross site", path: "/form", err: ErrCSRFOriginMismatch, prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.Set("X-CSRF-Token", token)
+			req.Header.Set("Sec-Fetch-Site", "cross-site")
+		}},
+		{name: "foreign origin", path: "/form", err: ErrCSRFOriginMismatch, prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.Set("X-CSRF-Token", token)
+			req.Header.Set("Origin", "https://evil.test")
+		}},
+		{name: "trusted origin", path: "/form", prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.Set("X-CSRF-Token", token)
+			req.Header.Set("Origin", "https://admin.example.com")
+			req.Header.Set("Sec-Fetch-Site", "same-site")
+		}},
+		{name: "same origin", path: "/form", prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.Set("X-CSRF-Token", token)
+			req.Header.Set("Origin", "http://example.com")
+		}},
+		{name: "same origin behind TLS proxy", path: "/form", prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.Set("X-CSRF-Token", token)
+			req.Header.Set("Origin", "https://example.com")
+		}},
+		{name: "null origin", path: "/form", err: ErrCSRFOriginMismatch, prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("_csrf", token)
+			req.Header.Set("X-CSRF-Token", token)
+			req.Header.Set("Origin", "null")
+		}},
+		{name: "exempt route", path: "/webhook", prepare: func(req *fasthttp.Request) {
+			req.Header.Set("Origin", "https://evil.test")
+		}},
+	} {
+		rc := request("POST", c.path, c.prepare)
+
+		if !errors.Is(failure, c.err) || (c.err == nil) != (rc.Response.StatusCode() == 200) {
+			t.Errorf("%s: unexpected result %d, %v", c.name, rc.Response.StatusCode(), failure)
+		}
+	}
+}
+
+func TestCSRF_synchronizerToken(t *testing.T) {
+	r := fchi.NewRouter()
+	r.Use(CSRF(CSRFOptions{
+		Key: []byte("secret"),
+		SessionID: func(ctx context.Context, rc *fasthttp.RequestCtx) string {
+			return string(rc.Request.Header.Cookie("session"))
+		},
+	}))
+	r.Get("/form", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString(CSRFToken(ctx))
+	}))
+	r.Post("/form", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {}))
+
+	request := func(method, session, token string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(method)
+		rc.Request.SetRequestURI("/form")
+		rc.Request.Header.SetCookie("session", session)
+		rc.Request.Header.Set("X-CSRF-Token", token)
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	rc := request("GET", "s1", "")
+	token := string(rc.Response.Body())
+
+	if len(rc.Response.Header.PeekCookie("_csrf")) != 0 {
+		t.Fatal("cookie is not expected with session tokens")
+	}
+
+	if rc := request("POST", "s1", token); rc.Response.StatusCode() != 200 {
+		t.Fatalf("session token expected to be valid, %d", rc.Response.StatusCode())
+	}
+
+	if rc := request("POST", "s2", token); rc.Response.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("token of another session expected to be invalid, %d", rc.Response.StatusCode())
+	}
+}
@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// Decompress errors.
+var (
+	ErrUnsupportedContentEncoding error = &fchi.Problem{
+		Status: fasthttp.StatusUnsupportedMediaType,
+		Detail: "unsupported request content encoding",
+	}
+	ErrMalformedRequestBody error = &fchi.Problem{
+		Status: fasthttp.StatusBadRequest,
+		Detail: "malformed compressed request body",
+	}
+)
+
+// decompressEncodings lists supported request content encodings.
+const decompressEncodings = "gzip, deflate, br, zstd"
+
+// DecompressOptions configures Decompress middleware.
+type DecompressOptions struct {
+	// MaxSize is a limit of decompressed body size, default 10 MiB.
+	MaxSize int64
+}
+
+// Decompress is a middleware that decodes request body with gzip, deflate,
+// br or zstd Content-Encoding, so that handler receives plain body without
+// Content-Encoding header.
+//
+// Decompressed body larger than MaxSize is rejected with
+// 413 Request Entity Too Large and unsupported encoding is rejected with
+// 415 Unsupported Media Type.
+//
+// Size of compressed body can be limited with BodyLimit in front of
+// Decompress, RequestBody reader of decompressed body is limited by MaxSize.
+func Decompress(opts DecompressOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 10 << 20
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			encodings, ok := requestEncodings(rc)
+			if !ok {
+				fchi.HandleError(ctx, rc, ErrUnsupportedContentEncoding)
+				rc.Response.Header.Set("Accept-Encoding", decompressEncodings)
+
+				return
+			}
+
+			if len(encodings) == 0 {
+				rc.Request.Header.Del("Content-Encoding")
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			body, err := decompressBody(RequestBody(ctx, rc), encodings, opts.MaxSize)
+			if err != nil {
+				if errors.Is(err, ErrRequestBodyTooLarge) {
+					// Unread body of a streamed request is not drained.
+					rc.SetConnectionClose()
+				}
+
+				fchi.HandleError(ctx, rc, err)
+
+				return
+			}
+
+			rc.Request.SetBody(body)
+			rc.Request.Header.Del("Content-Encoding")
+			rc.Request.Header.SetContentLength(len(body))
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyBodyLimit{}, opts.MaxSize), rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// requestEncodings returns Content-Encoding values in order of application,
+// ok is false if any of them is not supported.
+func requestEncodings(rc *fasthttp.RequestCtx) (encodings []string, ok bool) {
+	v := string(rc.Request.Header.Peek("Content-Encoding"))
+
+	for _, e := range strings.Split(v, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+
+		switch e {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate", "br", "zstd":
+			encodings = append(encodings, e)
+		default:
+			return nil, false
+		}
+	}
+
+	return encodings, true
+}
+
+// decompressBody decodes r with encodings applied in order, it fails with
+// ErrRequestBodyTooLarge if result exceeds maxSize.
+func decompressBody(r io.Reader, encodings []string, maxSize int64) ([]byte, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		d, err := newDecoder(encodings[i], r, maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		defer d.Close()
+
+		r = d
+	}
+
+	var buf bytes.Buffer
+
+	// Reading one more byte to find out if body exceeds the limit.
+	n, err := buf.ReadFrom(io.LimitReader(r, maxSize+1))
+
+	switch {
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		return nil, err
+	case errors.Is(err, zstd.ErrDecoderSizeExceeded), errors.Is(err, zstd.ErrWindowSizeExceeded):
+		// Decoding requires more memory than the limit.
+		return nil, ErrRequestBodyTooLarge
+	case err != nil:
+		return nil, ErrMalformedRequestBody
+	case n > maxSize:
+		return nil, ErrRequestBodyTooLarge
+	}
+
+	return buf.Bytes(), nil
+}
+
+func newDecoder(encoding string, r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	var (
+		d   io.ReadCloser
+		err error
+	)
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		d, err = gzip.NewReader(r)
+	case "deflate":
+		d, err = newDeflateReader(r)
+	case "br":
+		d = ioutil.NopCloser(brotli.NewReader(r))
+	case "zstd":
+		var zd *zstd.Decoder
+
+		zd, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+		if err == nil {
+			d = zstdReadCloser{zd}
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrRequestBodyTooLarge) {
+			return nil, err
+		}
+
+		return nil, ErrMalformedRequestBody
+	}
+
+	return d, nil
+}
+
+// newDeflateReader reads zlib format of deflate encoding, and raw deflate
+// data that some clients send instead.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	hdr, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	if hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+
+	return nil
+}
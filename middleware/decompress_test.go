@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func compressTestBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	h := Decompress(DecompressOptions{MaxSize: 1 << 10})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		body, err := ioutil.ReadAll(RequestBody(ctx, rc))
+		if err != nil {
+			fchi.HandleError(ctx, rc, err)
+
+			return
+		}
+
+		rc.WriteString(string(rc.Request.Header.Peek("Content-Encoding")) + "|" + strconv.Itoa(len(body)) + "|" + string(body))
+	}))
+
+	plain := []byte("hello, compressed world")
+	bomb := bytes.Repeat([]byte{'a'}, 2<<10)
+
+	for _, c := range []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+		resp     string
+	}{
+		{name: "plain", body: plain, status: 200, resp: "|23|hello, compressed world"},
+		{name: "identity", encoding: "identity", body: plain, status: 200, resp: "|23|hello, compressed world"},
+		{name: "gzip", encoding: "gzip", body: compressTestBody(t, "gzip", plain), status: 200, resp: "|23|hello, compressed world"},
+		{name: "deflate", encoding: "deflate", body: compressTestBody(t, "deflate", plain), status: 200, resp: "|23|hello, compressed world"},
+		{name: "raw deflate", encoding: "deflate", body: compressTestBody(t, "raw-deflate", plain), status: 200, resp: "|23|hello, compressed world"},
+		{name: "br", encoding: "br", body: compressTestBody(t, "br", plain), status: 200, resp: "|23|hello, compressed world"},
+		{name: "zstd", encoding: "zstd", body: compressTestBody(t, "zstd", plain), status: 200, resp: "|23|hello, compressed world"},
+		{
+			name: "gzip, br", encoding: "gzip, br",
+			body:   compressTestBody(t, "br", compressTestBody(t, "gzip", plain)),
+			status: 200, resp: "|23|hello, compressed world",
+		},
+		{name: "gzip bomb", encoding: "gzip", body: compressTestBody(t, "gzip", bomb), status: 413},
+		{name: "zstd bomb", encoding: "zstd", body: compressTestBody(t, "zstd", bomb), status: 413},
+		{name: "malformed", encoding: "gzip", body: plain, status: 400},
+		{name: "unsupported", encoding: "compress", body: plain, status: 415},
+	} {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod("POST")
+		rc.Request.SetRequestURI("/")
+		rc.Request.SetBody(c.body)
+
+		if c.encoding != "" {
+			rc.Request.Header.Set("Content-Encoding", c.encoding)
+		}
+
+		h.ServeHTTP(context.Background(), rc)
+
+		if rc.Response.StatusCode() != c.status {
+			t.Errorf("%s: unexpected status %d: %s", c.name, rc.Response.StatusCode(), rc.Response.Body())
+
+			continue
+		}
+
+		if c.resp != "" && string(rc.Response.Body()) != c.resp {
+			t.Errorf("%s: unexpected response %q", c.name, rc.Response.Body())
+		}
+
+		if c.status == 415 && string(rc.Response.Header.Peek("Accept-Encoding")) != decompressEncodings {
+			t.Errorf("%s: missing Accept-Encoding", c.name)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// ETagOptions configures ETag middleware.
+type ETagOptions struct {
+	// Weak makes computed ETags weak, e.g. for responses that are semantically
+	// equivalent but may differ byte by byte.
+	Weak bool
+
+	// Version returns current ETag and/or modification time of the requested
+	// resource before calling the handler, so that unsafe methods are rejected
+	// with 412 Precondition Failed and GET requests of not modified resources
+	// are answered with 304 Not Modified without calling the handler.
+	//
+	// Empty ETag and zero time mean unknown version.
+	Version func(ctx context.Context, rc *fasthttp.RequestCtx) (etag string, lastModified time.Time, err error)
+
+	// MaxStreamSize limits size of a streamed response body that is read
+	// into memory to compute ETag, default 1 MiB. Streamed bodies of
+	// unknown or larger size are passed through without ETag.
+	MaxStreamSize int
+}
+
+// ETag is a middleware that adds ETag to successful GET and HEAD responses
+// and evaluates conditional request headers.
+//
+// ETag is taken from the response header if the handler has set it (e.g. with
+// a resource version), otherwise it is computed from the final response body.
+// Last-Modified response header is used for If-Modified-Since and
+// If-Unmodified-Since checks.
+func ETag(opts ETagOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.MaxStreamSize == 0 {
+		opts.MaxStreamSize = 1 << 20
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			var (
+				version      string
+				lastModified time.Time
+			)
+
+			if opts.Version != nil {
+				var err error
+
+				if version, lastModified, err = opts.Version(ctx, rc); err != nil {
+					fchi.HandleError(ctx, rc, err)
+
+					return
+				}
+
+				if status := CheckPreconditions(rc, version, lastModified); status != 0 {
+					writePreconditionStatus(ctx, rc, status, version, lastModified)
+
+					return
+				}
+			}
+
+			next.ServeHTTP(ctx, rc)
+
+			if (!rc.IsGet() && !rc.IsHead()) || rc.Response.StatusCode() != fasthttp.StatusOK {
+				return
+			}
+
+			etag := string(rc.Response.Header.Peek("ETag"))
+
+			if etag == "" && version != "" && !rc.Response.IsBodyStream() {
+				etag = version
+			}
+
+			if etag == "" {
+				if etag = bodyETag(rc, opts); etag == "" {
+					return
+				}
+			}
+
+			rc.Response.Header.Set("ETag", etag)
+
+			if lm, err := http.ParseTime(string(rc.Response.Header.Peek("Last-Modified"))); err == nil {
+				lastModified = lm
+			}
+
+			if status := CheckPreconditions(rc, etag, lastModified); status != 0 {
+				writePreconditionStatus(ctx, rc, status, etag, lastModified)
+			}
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// bodyETag computes ETag of response body, streamed body is read into memory
+// if its size is known and does not exceed limit.
+func bodyETag(rc *fasthttp.RequestCtx, opts ETagOptions) string {
+	if rc.Response.IsBodyStream() {
+		if size := rc.Response.Header.ContentLength(); size < 0 || size > opts.MaxStreamSize {
+			return ""
+		}
+	}
+
+	// Body of HEAD response is normally not written, so ETag only exists if
+	// handler has written it anyway.
+	body := rc.Response.Body()
+	if rc.IsHead() && len(body) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+
+	if opts.Weak {
+		etag = "W/" + etag
+	}
+
+	return etag
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since request headers against current ETag and modification
+// time of the resource, empty ETag and zero time mean unknown.
+//
+// It returns 0 if request should be processed, 304 Not Modified for GET and
+// HEAD requests of a cached resource, or 412 Precondition Failed.
+func CheckPreconditions(rc *fasthttp.RequestCtx, etag string, lastModified time.Time) int {
+	h := &rc.Request.Header
+	safe := rc.IsGet() || rc.IsHead()
+	lastModified = lastModified.Truncate(time.Second)
+
+	if im := string(h.Peek("If-Match")); im != "" {
+		if !etagListMatches(im, etag, false) {
+			return fasthttp.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(string(h.Peek("If-Unmodified-Since"))); err == nil && !lastModified.IsZero() {
+		if lastModified.After(t) {
+			return fasthttp.StatusPreconditionFailed
+		}
+	}
+
+	if inm := string(h.Peek("If-None-Match")); inm != "" {
+		if etagListMatches(inm, etag, true) {
+			if safe {
+				return fasthttp.StatusNotModified
+			}
+
+			return fasthttp.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(string(h.Peek("If-Modified-Since"))); err == nil && safe && !lastModified.IsZero() {
+		if !lastModified.After(t) {
+			return fasthttp.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// etagListMatches checks if ETag is in a comma-separated list or list is "*",
+// weak comparison ignores "W/" prefix.
+func etagListMatches(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+
+	if etag == "" || (!weak && strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+
+		if weak {
+			t, etag = strings.TrimPrefix(t, "W/"), strings.TrimPrefix(etag, "W/")
+		}
+
+		if t == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writePreconditionStatus(ctx context.Context, rc *fasthttp.RequestCtx, status int, etag string, lastModified time.Time) {
+	if status == fasthttp.StatusPreconditionFailed {
+		fchi.HandleError(ctx, rc, &fchi.Problem{Status: status, Detail: fasthttp.StatusMessage(status)})
+
+		return
+	}
+
+	// Not modified response keeps validators and caching headers, but has no body.
+	rc.Response.ResetBody()
+	rc.Response.Header.Del("Content-Type")
+	rc.Response.Header.Del("Content-Encoding")
+	rc.SetStatusCode(status)
+
+	if etag != "" {
+		rc.Response.Header.Set("ETag", etag)
+	}
+
+	if !lastModified.IsZero() && len(rc.Response.Header.Peek("Last-Modified")) == 0 {
+		rc.Response.Header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
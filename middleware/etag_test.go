@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestETag(t *testing.T) {
+	modTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := "hello"
+	updated := 0
+
+	r := fchi.NewRouter()
+	r.Use(ETag(ETagOptions{}))
+	buffered := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.Response.Header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+		rc.WriteString(body)
+	})
+
+	r.Get("/buffered", buffered)
+	r.Head("/buffered", buffered)
+	r.Get("/streamed", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.SetBodyStream(bytes.NewReader([]byte(body)), len(body))
+	}))
+	r.Get("/chunked", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.SetBodyStream(bytes.NewReader([]byte(body)), -1)
+	}))
+	r.Get("/versioned", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.Response.Header.Set("ETag", `W/"v1"`)
+		rc.WriteString(body)
+	}))
+
+	request := func(method, path string, headers ...string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(method)
+		rc.Request.SetRequestURI(path)
+
+		for i := 0; i < len(headers); i += 2 {
+			rc.Request.Header.Set(headers[i], headers[i+1])
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	rc := request("GET", "/buffered")
+	etag := string(rc.Response.Header.Peek("ETag"))
+
+	if rc.Response.StatusCode() != 200 || len(etag) < 3 || etag[0] != '"' {
+		t.Fatalf("strong ETag expected, %d %q", rc.Response.StatusCode(), etag)
+	}
+
+	rc = request("GET", "/streamed")
+	if string(rc.Response.Header.Peek("ETag")) != etag || string(rc.Response.Body()) != body {
+		t.Fatalf("same ETag expected for streamed body, %q %q", rc.Response.Header.Peek("ETag"), rc.Response.Body())
+	}
+
+	rc = request("GET", "/chunked")
+	if len(rc.Response.Header.Peek("ETag")) != 0 {
+		t.Fatal("no ETag expected for stream of unknown size")
+	}
+
+	if !rc.Response.IsBodyStream() || string(rc.Response.Body()) != body {
+		t.Fatalf("stream expected to be intact, %q", rc.Response.Body())
+	}
+
+	for _, c := range []struct {
+		name    string
+		method  string
+		path    string
+		headers []string
+		status  int
+	}{
+		{name: "if-none-match", path: "/buffered", headers: []string{"If-None-Match", `"x", ` + etag}, status: 304},
+		{name: "if-none-match head", method: "HEAD", path: "/buffered", headers: []string{"If-None-Match", etag}, status: 304},
+		{name: "if-none-match weak", path: "/buffered", headers: []string{"If-None-Match", "W/" + etag}, status: 304},
+		{name: "if-none-match changed", path: "/buffered", headers: []string{"If-None-Match", `"x"`}, status: 200},
+		{name: "if-none-match star", path: "/streamed", headers: []string{"If-None-Match", "*"}, status: 304},
+		{name: "if-modified-since", path: "/buffered", headers: []string{"If-Modified-Since", modTime.Format(http.TimeFormat)}, status: 304},
+		{
+			name: "if-modified-since changed", path: "/buffered", status: 200,
+			headers: []string{"If-Modified-Since", modTime.Add(-time.Second).Format(http.TimeFormat)},
+		},
+		{
+			name: "if-none-match precedence", path: "/buffered", status: 200,
+			headers: []string{"If-None-Match", `"x"`, "If-Modified-Since", modTime.Format(http.TimeFormat)},
+		},
+		{name: "if-match", path: "/buffered", headers: []string{"If-Match", etag}, status: 200},
+		{name: "if-match failed", path: "/buffered", headers: []string{"If-Match", `"x"`}, status: 412},
+		{
+			name: "if-unmodified-since failed", path: "/buffered", status: 412,
+			headers: []string{"If-Unmodified-Since", modTime.Add(-time.Second).Format(http.TimeFormat)},
+		},
+		{name: "versioned", path: "/versioned", headers: []string{"If-None-Match", `"v1"`}, status: 304},
+		{name: "weak if-match", path: "/versioned", headers: []string{"If-Match", `W/"v1"`}, status: 412},
+	} {
+		method := c.method
+		if method == "" {
+			method = "GET"
+		}
+
+		rc := request(method, c.path, c.headers...)
+
+		if rc.Response.StatusCode() != c.status {
+			t.Errorf("%s: unexpected status %d", c.name, rc.Response.StatusCode())
+		}
+
+		if c.status == 304 && (len(rc.Response.Body()) != 0 || len(rc.Response.Header.Peek("ETag")) == 0) {
+			t.Errorf("%s: empty body with ETag expected, %q", c.name, rc.Response.Body())
+		}
+	}
+
+	// Version is checked before handler.
+	version := `"v1"`
+	v := fchi.NewRouter()
+	v.Use(ETag(ETagOptions{Version: func(ctx context.Context, rc *fasthttp.RequestCtx) (string, time.Time, error) {
+		return version, time.Time{}, nil
+	}}))
+	v.Get("/doc", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString(body)
+	}))
+	v.Put("/doc", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		updated++
+		version = `"v2"`
+	}))
+
+	r = v
+
+	if rc := request("GET", "/doc"); string(rc.Response.Header.Peek("ETag")) != `"v1"` {
+		t.Errorf("version ETag expected, %q", rc.Response.Header.Peek("ETag"))
+	}
+
+	if rc := request("PUT", "/doc", "If-Match", `"v1"`); rc.Response.StatusCode() != 200 || updated != 1 {
+		t.Fatalf("update expected, status %d", rc.Response.StatusCode())
+	}
+
+	if rc := request("PUT", "/doc", "If-Match", `"v1"`); rc.Response.StatusCode() != 412 || updated != 1 {
+		t.Fatalf("lost update expected to be prevented, status %d", rc.Response.StatusCode())
+	}
+
+	if rc := request("PUT", "/doc", "If-None-Match", "*"); rc.Response.StatusCode() != 412 {
+		t.Fatalf("existing resource expected to fail If-None-Match, status %d", rc.Response.StatusCode())
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// reloadingFile loads a file again when it is modified.
+type reloadingFile struct {
+	path     string
+	interval time.Duration
+	load     func(data []byte) error
+
+	loaded    bool
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// reloadIfDue checks modification of the file not more often than interval,
+// it must be called with the lock of the owner.
+func (f *reloadingFile) reloadIfDue(now time.Time) error {
+	if now.Sub(f.lastCheck) < f.interval {
+		return nil
+	}
+
+	return f.reload(now)
+}
+
+// reload loads the file if it was modified, previously loaded data is kept on failure.
+func (f *reloadingFile) reload(now time.Time) error {
+	f.lastCheck = now
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	if f.loaded && fi.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	if err := f.load(data); err != nil {
+		return err
+	}
+
+	f.loaded = true
+	f.modTime = fi.ModTime()
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// HashedCredentials is a credential store with password hashes by username,
+// see CheckPasswordHash for supported hash formats.
+type HashedCredentials map[string]string
+
+// dummyPasswordHash is checked for unknown usernames if there are no stored hashes.
+const dummyPasswordHash = "$2a$10$iWN6G12xHQbZJysibOSi.enytQ6p4tMiXFFCbFRf9a2Bt5KGIIpF2"
+
+// Validate checks username and password, it can be used as BasicAuthOptions.Validate.
+//
+// Password of unknown username is checked against a stored hash of another
+// user, so that response time does not reveal which usernames exist.
+func (c HashedCredentials) Validate(_ context.Context, user, pass string) bool {
+	hashed, ok := c[user]
+	if !ok {
+		_, _ = CheckPasswordHash(c.dummyHash(), pass)
+
+		return false
+	}
+
+	valid, err := CheckPasswordHash(hashed, pass)
+
+	return err == nil && valid
+}
+
+// dummyHash returns hash of the first username in lexical order to have the
+// same cost as stored hashes.
+func (c HashedCredentials) dummyHash() string {
+	first, hashed := "", dummyPasswordHash
+
+	for user, h := range c {
+		if first == "" || user < first {
+			first, hashed = user, h
+		}
+	}
+
+	return hashed
+}
+
+// ParseHtpasswd parses credentials of Apache htpasswd file.
+func ParseHtpasswd(data []byte) (HashedCredentials, error) {
+	creds := make(HashedCredentials)
+	s := bufio.NewScanner(bytes.NewReader(data))
+
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if i := bytes.IndexByte(line, ':'); i > 0 {
+			creds[string(line[:i])] = string(line[i+1:])
+		}
+	}
+
+	return creds, s.Err()
+}
+
+// HtpasswdFile is a credential store that loads Apache htpasswd file and
+// reloads it when modified.
+type HtpasswdFile struct {
+	mu    sync.Mutex
+	file  reloadingFile
+	creds HashedCredentials
+}
+
+// NewHtpasswdFile creates a HtpasswdFile, modification time of the file is
+// checked not more often than interval.
+func NewHtpasswdFile(path string, interval time.Duration) (*HtpasswdFile, error) {
+	f := &HtpasswdFile{}
+	f.file = reloadingFile{path: path, interval: interval, load: func(data []byte) error {
+		creds, err := ParseHtpasswd(data)
+		if err == nil {
+			f.creds = creds
+		}
+
+		return err
+	}}
+
+	if err := f.file.reload(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Validate checks username and password, it can be used as BasicAuthOptions.Validate.
+func (f *HtpasswdFile) Validate(ctx context.Context, user, pass string) bool {
+	f.mu.Lock()
+	// Keeping previous credentials on failure.
+	_ = f.file.reloadIfDue(time.Now())
+	creds := f.creds
+	f.mu.Unlock()
+
+	return creds.Validate(ctx, user, pass)
+}
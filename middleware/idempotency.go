@@ -0,0 +1,300 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// Idempotency errors.
+var (
+	ErrIdempotencyKeyMissing  = errors.New("idempotency key missing")
+	ErrIdempotencyKeyInFlight = errors.New("request with the same idempotency key is in progress")
+	ErrIdempotencyKeyReused   = errors.New("idempotency key was used for a different request")
+)
+
+// IdempotencyRecord is a stored request outcome of an idempotency key.
+type IdempotencyRecord struct {
+	// Fingerprint identifies request method, path and body.
+	Fingerprint string
+
+	// Completed is false while the first request is in progress.
+	Completed bool
+
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore keeps records of idempotency keys.
+type IdempotencyStore interface {
+	// Begin returns existing record of key, or reserves key with an
+	// in-progress record and returns nil.
+	Begin(key string, rec IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error)
+
+	// Complete replaces in-progress record with the response.
+	Complete(key string, rec IdempotencyRecord, ttl time.Duration) error
+
+	// Abort removes in-progress record, so that request can be retried.
+	Abort(key string) error
+}
+
+// IdempotencyMemoryStore is an in-memory IdempotencyStore.
+type IdempotencyMemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]idempotencyMemoryRecord
+	lastSweep time.Time
+
+	now func() time.Time
+}
+
+type idempotencyMemoryRecord struct {
+	IdempotencyRecord
+	expires time.Time
+}
+
+// NewIdempotencyMemoryStore creates an in-memory IdempotencyStore, expired
+// records are evicted during updates.
+func NewIdempotencyMemoryStore() *IdempotencyMemoryStore {
+	return &IdempotencyMemoryStore{
+		records: make(map[string]idempotencyMemoryRecord),
+		now:     time.Now,
+	}
+}
+
+// Begin returns existing record of key, or reserves key with an in-progress record.
+func (s *IdempotencyMemoryStore) Begin(key string, rec IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+
+		for k, r := range s.records {
+			if !now.Before(r.expires) {
+				delete(s.records, k)
+			}
+		}
+	}
+
+	if r, ok := s.records[key]; ok && now.Before(r.expires) {
+		existing := r.IdempotencyRecord
+
+		return &existing, nil
+	}
+
+	s.records[key] = idempotencyMemoryRecord{IdempotencyRecord: rec, expires: now.Add(ttl)}
+
+	return nil, nil
+}
+
+// Complete replaces in-progress record with the response.
+func (s *IdempotencyMemoryStore) Complete(key string, rec IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = idempotencyMemoryRecord{IdempotencyRecord: rec, expires: s.now().Add(ttl)}
+
+	return nil
+}
+
+// Abort removes in-progress record.
+func (s *IdempotencyMemoryStore) Abort(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+// IdempotencyOptions configures Idempotency middleware.
+type IdempotencyOptions struct {
+	// Store keeps responses, in-memory store is used by default.
+	Store IdempotencyStore
+
+	// HeaderName is "Idempotency-Key" by default.
+	HeaderName string
+
+	// TTL is retention period of responses, default 24 hours.
+	TTL time.Duration
+
+	// Required rejects requests without key with 400 Bad Request.
+	Required bool
+
+	// Principal identifies client that owns keys, by default it is a user
+	// authenticated by BasicAuth, subject (or issuer and ID) of JWT or API
+	// key identity, or client IP for anonymous requests.
+	Principal func(ctx context.Context, rc *fasthttp.RequestCtx) string
+}
+
+// Idempotency is a middleware that makes retries of unsafe requests with the
+// same Idempotency-Key header safe, it is intended for specific routes.
+//
+//  r.With(middleware.Idempotency(middleware.IdempotencyOptions{})).Post("/payments", createPayment)
+//
+// The first response of a key is stored and replayed for retries with
+// Idempotent-Replayed header. Concurrent duplicate is rejected with
+// 409 Conflict, and reuse of a key for a different request is rejected with
+// 422 Unprocessable Entity. Server errors (5xx) are not stored.
+func Idempotency(opts IdempotencyOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.Store == nil {
+		opts.Store = NewIdempotencyMemoryStore()
+	}
+
+	if opts.HeaderName == "" {
+		opts.HeaderName = "Idempotency-Key"
+	}
+
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+
+	if opts.Principal == nil {
+		opts.Principal = authenticatedPrincipal
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			if rc.IsGet() || rc.IsHead() || rc.IsOptions() {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			idemKey := string(rc.Request.Header.Peek(opts.HeaderName))
+			if idemKey == "" {
+				if opts.Required {
+					fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusBadRequest, Detail: ErrIdempotencyKeyMissing.Error()})
+
+					return
+				}
+
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			key := opts.Principal(ctx, rc) + "\x00" + idemKey
+			fingerprint := requestFingerprint(rc)
+
+			existing, err := opts.Store.Begin(key, IdempotencyRecord{Fingerprint: fingerprint}, opts.TTL)
+			if err != nil {
+				fchi.HandleError(ctx, rc, err)
+
+				return
+			}
+
+			if existing != nil {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusUnprocessableEntity, Detail: ErrIdempotencyKeyReused.Error()})
+				case !existing.Completed:
+					fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusConflict, Detail: ErrIdempotencyKeyInFlight.Error()})
+				default:
+					replayIdempotent(rc, existing)
+				}
+
+				return
+			}
+
+			completed := false
+
+			defer func() {
+				if !completed {
+					_ = opts.Store.Abort(key)
+				}
+			}()
+
+			next.ServeHTTP(ctx, rc)
+
+			if rc.Response.StatusCode() >= fasthttp.StatusInternalServerError {
+				return
+			}
+
+			rec := IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				Status:      rc.Response.StatusCode(),
+				Header:      http.Header{},
+				Body:        append([]byte(nil), rc.Response.Body()...),
+			}
+
+			rc.Response.Header.VisitAll(func(k, v []byte) {
+				switch string(k) {
+				case fasthttp.HeaderContentLength, fasthttp.HeaderDate, fasthttp.HeaderServer, fasthttp.HeaderConnection:
+				default:
+					rec.Header.Add(string(k), string(v))
+				}
+			})
+
+			if err := opts.Store.Complete(key, rec, opts.TTL); err == nil {
+				completed = true
+			}
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// authenticatedPrincipal returns identity of authentication middlewares or client IP.
+func authenticatedPrincipal(ctx context.Context, rc *fasthttp.RequestCtx) string {
+	if u := BasicAuthUser(ctx); u != "" {
+		return "basic:" + u
+	}
+
+	if c := JWTClaimsFromContext(ctx); c != nil {
+		if sub := c.Subject(); sub != "" {
+			return "jwt:" + sub
+		}
+
+		// Token without subject is identified by its issuer and ID, tokens
+		// without both do not identify a client and fall through.
+		iss, _ := c["iss"].(string)
+		if jti, _ := c["jti"].(string); jti != "" {
+			return "jti:" + iss + "\x00" + jti
+		}
+	}
+
+	if id := APIKeyFromContext(ctx); id != nil {
+		return "key:" + id.ID
+	}
+
+	// Anonymous clients do not share keys.
+	return "ip:" + rc.RemoteIP().String()
+}
+
+func requestFingerprint(rc *fasthttp.RequestCtx) string {
+	h := sha256.New()
+	h.Write(rc.Method())
+	h.Write([]byte{0})
+	h.Write(rc.URI().PathOriginal())
+	h.Write([]byte{0})
+	h.Write(rc.URI().QueryString())
+	h.Write([]byte{0})
+	h.Write(rc.Request.Body())
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayIdempotent(rc *fasthttp.RequestCtx, rec *IdempotencyRecord) {
+	rc.SetStatusCode(rec.Status)
+
+	for k, vv := range rec.Header {
+		for _, v := range vv {
+			rc.Response.Header.Add(k, v)
+		}
+	}
+
+	rc.Response.Header.Set("Idempotent-Replayed", "true")
+	rc.Response.SetBody(rec.Body)
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestIdempotency(t *testing.T) {
+	var charges int64
+
+	started, release := make(chan struct{}), make(chan struct{})
+
+	r := fchi.NewRouter()
+	r.Use(BasicAuth("test", map[string]string{"alice": "a", "bob": "b"}))
+	r.With(Idempotency(IdempotencyOptions{Required: true})).Post("/payments", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		if string(rc.Request.Body()) == "slow" {
+			close(started)
+			<-release
+		}
+
+		if string(rc.Request.Body()) == "fail" {
+			rc.SetStatusCode(fasthttp.StatusServiceUnavailable)
+
+			return
+		}
+
+		n := atomic.AddInt64(&charges, 1)
+
+		rc.Response.Header.Set("Location", "/payments/"+strconv.Itoa(int(n)))
+		rc.SetStatusCode(fasthttp.StatusCreated)
+		rc.WriteString("charged " + strconv.Itoa(int(n)))
+	}))
+
+	request := func(user, key, body string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod("POST")
+		rc.Request.SetRequestURI("/payments")
+		rc.Request.Header.Set("Authorization", "Basic "+map[string]string{"alice": "YWxpY2U6YQ==", "bob": "Ym9iOmI="}[user])
+		rc.Request.SetBodyString(body)
+
+		if key != "" {
+			rc.Request.Header.Set("Idempotency-Key", key)
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	expect := func(name string, rc *fasthttp.RequestCtx, status int, body string) {
+		t.Helper()
+
+		if rc.Response.StatusCode() != status || (body != "" && string(rc.Response.Body()) != body) {
+			t.Errorf("%s: unexpected response %d %q", name, rc.Response.StatusCode(), rc.Response.Body())
+		}
+	}
+
+	expect("missing key", request("alice", "", "100"), 400, "")
+	expect("first", request("alice", "k1", "100"), 201, "charged 1")
+
+	rc := request("alice", "k1", "100")
+	expect("retry", rc, 201, "charged 1")
+
+	if string(rc.Response.Header.Peek("Idempotent-Replayed")) != "true" || string(rc.Response.Header.Peek("Location")) != "/payments/1" {
+		t.Errorf("replayed headers expected, %s", rc.Response.Header.String())
+	}
+
+	expect("reused key", request("alice", "k1", "200"), 422, "")
+	expect("other principal", request("bob", "k1", "100"), 201, "charged 2")
+
+	expect("server error", request("alice", "k2", "fail"), 503, "")
+	expect("server error retry", request("alice", "k2", "fail"), 503, "")
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		expect("slow", request("alice", "k3", "slow"), 201, "charged 3")
+	}()
+
+	<-started
+	expect("in flight", request("alice", "k3", "slow"), 409, "")
+
+	close(release)
+	<-done
+
+	expect("slow retry", request("alice", "k3", "slow"), 201, "charged 3")
+
+	if charges != 3 {
+		t.Errorf("unexpected number of charges: %d", charges)
+	}
+}
+
+func TestIdempotency_anonymous(t *testing.T) {
+	var charges int64
+
+	h := Idempotency(IdempotencyOptions{})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString("charged " + strconv.Itoa(int(atomic.AddInt64(&charges, 1))))
+	}))
+
+	request := func(ip, uri string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP(ip)}, nil)
+		rc.Request.Header.SetMethod("POST")
+		rc.Request.SetRequestURI(uri)
+		rc.Request.Header.Set("Idempotency-Key", "k1")
+		h.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	for _, c := range []struct {
+		name, ip, uri string
+		status        int
+		body          string
+	}{
+		{name: "first", ip: "10.0.0.1", uri: "/pay?amount=1", status: 200, body: "charged 1"},
+		{name: "retry", ip: "10.0.0.1", uri: "/pay?amount=1", status: 200, body: "charged 1"},
+		{name: "other client", ip: "10.0.0.2", uri: "/pay?amount=1", status: 200, body: "charged 2"},
+		{name: "other query", ip: "10.0.0.1", uri: "/pay?amount=1000", status: 422},
+	} {
+		rc := request(c.ip, c.uri)
+
+		if rc.Response.StatusCode() != c.status || (c.body != "" && string(rc.Response.Body()) != c.body) {
+			t.Errorf("%s: unexpected response %d %q", c.name, rc.Response.StatusCode(), rc.Response.Body())
+		}
+	}
+}
+
+func TestIdempotency_jwtWithoutSubject(t *testing.T) {
+	var charges int64
+
+	h := Idempotency(IdempotencyOptions{})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString("charged " + strconv.Itoa(int(atomic.AddInt64(&charges, 1))))
+	}))
+
+	request := func(ip string, claims JWTClaims) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP(ip)}, nil)
+		rc.Request.Header.SetMethod("POST")
+		rc.Request.SetRequestURI("/pay")
+		rc.Request.Header.Set("Idempotency-Key", "k1")
+		h.ServeHTTP(context.WithValue(context.Background(), ctxKeyJWTClaims{}, claims), rc)
+
+		return rc
+	}
+
+	for _, c := range []struct {
+		name   string
+		ip     string
+		claims JWTClaims
+		body   string
+	}{
+		{name: "first", ip: "10.0.0.1", claims: JWTClaims{"iss": "a", "jti": "t1"}, body: "charged 1"},
+		{name: "retry", ip: "10.0.0.1", claims: JWTClaims{"iss": "a", "jti": "t1"}, body: "charged 1"},
+		{name: "other token", ip: "10.0.0.1", claims: JWTClaims{"iss": "a", "jti": "t2"}, body: "charged 2"},
+		{name: "no id", ip: "10.0.0.2", claims: JWTClaims{"iss": "a"}, body: "charged 3"},
+		{name: "no id other client", ip: "10.0.0.3", claims: JWTClaims{"iss": "a"}, body: "charged 4"},
+	} {
+		rc := request(c.ip, c.claims)
+
+		if rc.Response.StatusCode() != 200 || string(rc.Response.Body()) != c.body {
+			t.Errorf("%s: unexpected response %d %q", c.name, rc.Response.StatusCode(), rc.Response.Body())
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// ErrIPForbidden is reported when IPFilter rejects a request.
+var ErrIPForbidden = errors.New("ip address forbidden")
+
+// IPList is a set of IPv4 and IPv6 networks with prefix trie lookup.
+type IPList struct {
+	root ipTrieNode
+	size int
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	terminal bool
+}
+
+// ParseIPList creates IPList from CIDR networks or single addresses,
+// e.g. "10.0.0.0/8", "2001:db8::/32" or "192.168.1.10".
+func ParseIPList(networks ...string) (*IPList, error) {
+	l := &IPList{}
+
+	for _, n := range networks {
+		if err := l.Add(n); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// Add adds a CIDR network or a single address to the list.
+func (l *IPList) Add(network string) error {
+	network = strings.TrimSpace(network)
+
+	var (
+		ip   net.IP
+		bits int
+	)
+
+	if strings.IndexByte(network, '/') >= 0 {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return err
+		}
+
+		ones, size := ipNet.Mask.Size()
+		ip, bits = ipNet.IP.To16(), ones+128-size
+	} else {
+		if ip = net.ParseIP(network); ip == nil {
+			return fmt.Errorf("invalid IP address %q", network)
+		}
+
+		ip, bits = ip.To16(), 128
+	}
+
+	n := &l.root
+
+	for i := 0; i < bits && !n.terminal; i++ {
+		b := ip[i/8] >> (7 - uint(i%8)) & 1
+		if n.children[b] == nil {
+			n.children[b] = &ipTrieNode{}
+		}
+
+		n = n.children[b]
+	}
+
+	// Nested networks are covered by the wider one.
+	n.terminal, n.children = true, [2]*ipTrieNode{}
+	l.size++
+
+	return nil
+}
+
+// Len returns number of added networks.
+func (l *IPList) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	return l.size
+}
+
+// Contains checks if IP address belongs to any of networks.
+func (l *IPList) Contains(ip net.IP) bool {
+	if l == nil {
+		return false
+	}
+
+	if ip = ip.To16(); ip == nil {
+		return false
+	}
+
+	n := &l.root
+
+	for i := 0; i < 128; i++ {
+		if n.terminal {
+			return true
+		}
+
+		if n = n.children[ip[i/8]>>(7-uint(i%8))&1]; n == nil {
+			return false
+		}
+	}
+
+	return n.terminal
+}
+
+// IPRulesFile loads allow and deny lists from a file and reloads it when modified.
+//
+// Each line of the file is "allow <network>" or "deny <network>", empty
+// lines and lines starting with '#' are ignored.
+type IPRulesFile struct {
+	mu    sync.Mutex
+	file  reloadingFile
+	allow *IPList
+	deny  *IPList
+}
+
+// NewIPRulesFile creates an IPRulesFile, modification time of the file is
+// checked not more often than interval.
+func NewIPRulesFile(path string, interval time.Duration) (*IPRulesFile, error) {
+	f := &IPRulesFile{}
+	f.file = reloadingFile{path: path, interval: interval, load: func(data []byte) error {
+		allow, deny, err := parseIPRules(data)
+		if err == nil {
+			f.allow, f.deny = allow, deny
+		}
+
+		return err
+	}}
+
+	if err := f.file.reload(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *IPRulesFile) lists() (allow, deny *IPList) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Keeping previous lists on failure.
+	_ = f.file.reloadIfDue(time.Now())
+
+	return f.allow, f.deny
+}
+
+func parseIPRules(data []byte) (allow, deny *IPList, err error) {
+	allow, deny = &IPList{}, &IPList{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("line %d: rule expected as <allow|deny> <network>", line)
+		}
+
+		switch fields[0] {
+		case "allow":
+			err = allow.Add(fields[1])
+		case "deny":
+			err = deny.Add(fields[1])
+		default:
+			err = fmt.Errorf("unknown action %q", fields[0])
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return allow, deny, s.Err()
+}
+
+// IPFilterOptions configures IPFilter middleware.
+type IPFilterOptions struct {
+	// Allow lists networks that are allowed, if not empty other addresses are rejected.
+	Allow []string
+
+	// Deny lists networks that are rejected, it takes precedence over Allow.
+	Deny []string
+
+	// File provides additional allow and deny lists that can be changed without restart.
+	File *IPRulesFile
+
+	// TrustedProxies lists networks of reverse proxies, client address is taken
+	// from X-Forwarded-For header if request comes from a trusted proxy.
+	TrustedProxies []string
+}
+
+// IPFilter is a middleware that rejects requests by client IP address with
+// 403 Forbidden, it panics on invalid networks.
+//
+// It can be applied to a group of routes, e.g. to limit access to profiler.
+//
+//  r.Group(func(r fchi.Router) {
+//    r.Use(middleware.IPFilter(middleware.IPFilterOptions{Allow: []string{"10.0.0.0/8"}}))
+//    r.Mount("/debug", middleware.Profiler())
+//  })
+func IPFilter(opts IPFilterOptions) func(next fchi.Handler) fchi.Handler {
+	allow, err := ParseIPList(opts.Allow...)
+	if err != nil {
+		panic("chi/middleware: IPFilter allow list: " + err.Error())
+	}
+
+	deny, err := ParseIPList(opts.Deny...)
+	if err != nil {
+		panic("chi/middleware: IPFilter deny list: " + err.Error())
+	}
+
+	proxies, err := ParseIPList(opts.TrustedProxies...)
+	if err != nil {
+		panic("chi/middleware: IPFilter trusted proxies: " + err.Error())
+	}
+
+	allowed := func(ip net.IP) bool {
+		fileAllow, fileDeny := (*IPList)(nil), (*IPList)(nil)
+		if opts.File != nil {
+			fileAllow, fileDeny = opts.File.lists()
+		}
+
+		if ip == nil || deny.Contains(ip) || fileDeny.Contains(ip) {
+			return false
+		}
+
+		if allow.Len() == 0 && fileAllow.Len() == 0 {
+			return true
+		}
+
+		return allow.Contains(ip) || fileAllow.Contains(ip)
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			if !allowed(clientIP(rc, proxies)) {
+				fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusForbidden, Detail: ErrIPForbidden.Error()})
+
+				return
+			}
+
+			next.ServeHTTP(ctx, rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// clientIP returns remote address, or the last address in X-Forwarded-For
+// that is not a trusted proxy if request comes from a trusted proxy.
+func clientIP(rc *fasthttp.RequestCtx, proxies *IPList) net.IP {
+	ip := rc.RemoteIP()
+
+	if !proxies.Contains(ip) {
+		return ip
+	}
+
+	var xff []string
+
+	rc.Request.Header.VisitAll(func(key, value []byte) {
+		if strings.EqualFold(string(key), fasthttp.HeaderXForwardedFor) {
+			xff = append(xff, strings.Split(string(value), ",")...)
+		}
+	})
+
+	for i := len(xff) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(xff[i]))
+		if ip == nil || !proxies.Contains(ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestIPList(t *testing.T) {
+	l, err := ParseIPList("10.0.0.0/8", "192.168.1.10", "2001:db8::/32", "10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"10.2.3.4":         true,
+		"11.0.0.1":         false,
+		"192.168.1.10":     true,
+		"192.168.1.11":     false,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"::ffff:10.0.0.1":  true,
+		"::a00:1":          false,
+		"fe80::1":          false,
+		"10.1.255.255":     true,
+		"255.255.255.255":  false,
+		"0.0.0.0":          false,
+		"2001:db8:ffff::1": true,
+	} {
+		if l.Contains(net.ParseIP(ip)) != expected {
+			t.Errorf("%s: expected %v", ip, expected)
+		}
+	}
+
+	if _, err := ParseIPList("10.0.0.0/33"); err == nil {
+		t.Error("error expected for invalid network")
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules")
+
+	write := func(data string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# office\nallow 203.0.113.0/24\n", time.Now().Add(-time.Hour))
+
+	rules, err := NewIPRulesFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := fchi.NewRouter()
+	r.Get("/", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {}))
+	r.Group(func(r fchi.Router) {
+		r.Use(IPFilter(IPFilterOptions{
+			Allow:          []string{"10.0.0.0/8", "2001:db8::/32"},
+			Deny:           []string{"10.0.0.13"},
+			File:           rules,
+			TrustedProxies: []string{"127.0.0.1", "172.16.0.0/12"},
+		}))
+		r.Get("/internal", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {}))
+	})
+
+	request := func(path, remote, xff string) int {
+		rc := &fasthttp.RequestCtx{}
+		rc.SetRemoteAddr(&net.TCPAddr{IP: net.ParseIP(remote)})
+		rc.Request.SetRequestURI(path)
+
+		if xff != "" {
+			rc.Request.Header.Set("X-Forwarded-For", xff)
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc.Response.StatusCode()
+	}
+
+	for _, c := range []struct {
+		name   string
+		path   string
+		remote string
+		xff    string
+		status int
+	}{
+		{name: "public", path: "/", remote: "198.51.100.1", status: 200},
+		{name: "allowed", path: "/internal", remote: "10.1.2.3", status: 200},
+		{name: "allowed v6", path: "/internal", remote: "2001:db8::7", status: 200},
+		{name: "denied", path: "/internal", remote: "10.0.0.13", status: 403},
+		{name: "not allowed", path: "/internal", remote: "198.51.100.1", status: 403},
+		{name: "file", path: "/internal", remote: "203.0.113.5", status: 200},
+		{name: "untrusted xff", path: "/internal", remote: "198.51.100.1", xff: "10.1.2.3", status: 403},
+		{name: "trusted xff", path: "/internal", remote: "127.0.0.1", xff: "10.1.2.3, 172.16.0.5", status: 200},
+		{name: "spoofed xff", path: "/internal", remote: "127.0.0.1", xff: "10.1.2.3, 198.51.100.1", status: 403},
+	} {
+		if status := request(c.path, c.remote, c.xff); status != c.status {
+			t.Errorf("%s: unexpected status %d", c.name, status)
+		}
+	}
+
+	write("deny 203.0.113.0/24\n", time.Now())
+
+	if status := request("/internal", "203.0.113.5", ""); status != 403 {
+		t.Errorf("reloaded rules expected, status %d", status)
+	}
+
+	if status := request("/internal", "10.1.2.3", ""); status != 200 {
+		t.Errorf("unexpected status %d", status)
+	}
+}
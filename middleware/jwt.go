@@ -0,0 +1,436 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// JWT validation errors.
+var (
+	ErrJWTMissing      = errors.New("jwt missing")
+	ErrJWTMalformed    = errors.New("jwt malformed")
+	ErrJWTAlgorithm    = errors.New("jwt algorithm not allowed")
+	ErrJWTSignature    = errors.New("jwt signature invalid")
+	ErrJWTExpired      = errors.New("jwt expired")
+	ErrJWTNotValidYet  = errors.New("jwt not valid yet")
+	ErrJWTIssuer       = errors.New("jwt issuer invalid")
+	ErrJWTAudience     = errors.New("jwt audience invalid")
+	ErrJWTInsufficient = errors.New("jwt claims insufficient")
+)
+
+// JWTAlgorithms are supported signing algorithms.
+var JWTAlgorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+
+// JWTOptions configures JWT middleware.
+type JWTOptions struct {
+	// Keys provides verification keys, required.
+	Keys JWTKeySource
+
+	// Algorithms limits allowed signing algorithms, default JWTAlgorithms.
+	Algorithms []string
+
+	// Issuer and Audience are checked against iss and aud claims if not empty.
+	Issuer   string
+	Audience string
+
+	// ClockSkew is tolerated in exp and nbf checks.
+	ClockSkew time.Duration
+
+	// CookieName and QueryParam enable token lookup in cookie and URL query
+	// if Authorization header has no Bearer token.
+	CookieName string
+	QueryParam string
+
+	// CredentialsOptional passes requests without token, protected routes
+	// can use RequireClaims or RequireScope.
+	CredentialsOptional bool
+
+	// now is used in tests.
+	now func() time.Time
+}
+
+// JWTClaims are claims of a verified token.
+type JWTClaims map[string]interface{}
+
+// Subject returns sub claim.
+func (c JWTClaims) Subject() string {
+	s, _ := c["sub"].(string)
+
+	return s
+}
+
+// Scopes returns scopes from space-delimited scope claim or scp array claim.
+func (c JWTClaims) Scopes() []string {
+	if s, ok := c["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+
+	return claimStrings(c["scp"])
+}
+
+// HasScope checks if token has a scope.
+func (c JWTClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// claimStrings converts string or array claim to strings.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				res = append(res, s)
+			}
+		}
+
+		return res
+	}
+
+	return nil
+}
+
+type ctxKeyJWTClaims struct{}
+
+// JWTClaimsFromContext returns claims of a token verified by JWT middleware.
+func JWTClaimsFromContext(ctx context.Context) JWTClaims {
+	c, _ := ctx.Value(ctxKeyJWTClaims{}).(JWTClaims)
+
+	return c
+}
+
+// JWT is a middleware that authenticates requests with bearer JSON Web Tokens.
+//
+// Token is read from "Authorization: Bearer" header, or from a cookie or
+// URL query if configured. Requests with missing or invalid token are
+// responded with 401 Unauthorized, claims of a valid token are available
+// in context with JWTClaimsFromContext.
+func JWT(opts JWTOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.Keys == nil {
+		panic("chi/middleware: JWT expects key source")
+	}
+
+	if opts.Algorithms == nil {
+		opts.Algorithms = JWTAlgorithms
+	}
+
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			token := bearerToken(rc, opts)
+			if token == "" {
+				if opts.CredentialsOptional {
+					next.ServeHTTP(ctx, rc)
+
+					return
+				}
+
+				jwtFailed(ctx, rc, fasthttp.StatusUnauthorized, ErrJWTMissing)
+
+				return
+			}
+
+			claims, err := verifyJWT(token, opts)
+			if err != nil {
+				jwtFailed(ctx, rc, fasthttp.StatusUnauthorized, err)
+
+				return
+			}
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyJWTClaims{}, claims), rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// RequireClaims is a middleware that requires claims of verified token to
+// have the values, values of array claims (e.g. "groups") need to contain
+// the value, ie.
+//
+//  r.With(middleware.RequireClaims(map[string]interface{}{"groups": "admin"})).Delete("/users/{id}", h)
+func RequireClaims(values map[string]interface{}) func(next fchi.Handler) fchi.Handler {
+	return requireClaims(func(claims JWTClaims) bool {
+		for name, v := range values {
+			if !claimHas(claims[name], v) {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// RequireScope is a middleware that requires verified token to have all the scopes.
+func RequireScope(scopes ...string) func(next fchi.Handler) fchi.Handler {
+	return requireClaims(func(claims JWTClaims) bool {
+		for _, s := range scopes {
+			if !claims.HasScope(s) {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+func requireClaims(check func(claims JWTClaims) bool) func(next fchi.Handler) fchi.Handler {
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			claims := JWTClaimsFromContext(ctx)
+
+			if claims == nil {
+				jwtFailed(ctx, rc, fasthttp.StatusUnauthorized, ErrJWTMissing)
+
+				return
+			}
+
+			if !check(claims) {
+				jwtFailed(ctx, rc, fasthttp.StatusForbidden, ErrJWTInsufficient)
+
+				return
+			}
+
+			next.ServeHTTP(ctx, rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+func claimHas(claim, value interface{}) bool {
+	if arr, ok := claim.([]interface{}); ok {
+		for _, c := range arr {
+			if fmt.Sprint(c) == fmt.Sprint(value) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return claim != nil && fmt.Sprint(claim) == fmt.Sprint(value)
+}
+
+func jwtFailed(ctx context.Context, rc *fasthttp.RequestCtx, status int, err error) {
+	challenge := `Bearer error="invalid_token"`
+
+	switch {
+	case err == ErrJWTMissing:
+		challenge = `Bearer`
+	case status == fasthttp.StatusForbidden:
+		challenge = `Bearer error="insufficient_scope"`
+	}
+
+	rc.Response.Header.Set("WWW-Authenticate", challenge)
+	fchi.HandleError(ctx, rc, &fchi.Problem{Status: status, Detail: err.Error()})
+}
+
+func bearerToken(rc *fasthttp.RequestCtx, opts JWTOptions) string {
+	const prefix = "Bearer "
+
+	if auth := rc.Request.Header.Peek("Authorization"); len(auth) > len(prefix) &&
+		strings.EqualFold(string(auth[:len(prefix)]), prefix) {
+		return string(bytes.TrimSpace(auth[len(prefix):]))
+	}
+
+	if opts.CookieName != "" {
+		if c := rc.Request.Header.Cookie(opts.CookieName); len(c) > 0 {
+			return string(c)
+		}
+	}
+
+	if opts.QueryParam != "" {
+		return string(rc.QueryArgs().Peek(opts.QueryParam))
+	}
+
+	return ""
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func verifyJWT(token string, opts JWTOptions) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	var (
+		h      jwtHeader
+		claims JWTClaims
+	)
+
+	if err := decodeJWTPart(parts[0], &h); err != nil {
+		return nil, err
+	}
+
+	if !jwtAlgorithmAllowed(h.Alg, opts.Algorithms) {
+		return nil, ErrJWTAlgorithm
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	key, err := opts.Keys.JWTKey(h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyJWTSignature(h.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := opts.now()
+
+	exp, hasExp, err := jwtNumericDate(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+
+	// Token must be rejected on or after expiration time, RFC 7519 section 4.1.4.
+	if hasExp && !now.Before(exp.Add(opts.ClockSkew)) {
+		return nil, ErrJWTExpired
+	}
+
+	nbf, hasNbf, err := jwtNumericDate(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+
+	if hasNbf && now.Add(opts.ClockSkew).Before(nbf) {
+		return nil, ErrJWTNotValidYet
+	}
+
+	if opts.Issuer != "" && claims["iss"] != opts.Issuer {
+		return nil, ErrJWTIssuer
+	}
+
+	if opts.Audience != "" {
+		found := false
+
+		for _, aud := range claimStrings(claims["aud"]) {
+			if aud == opts.Audience {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return nil, ErrJWTAudience
+		}
+	}
+
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrJWTMalformed
+	}
+
+	return nil
+}
+
+// jwtNumericDate returns time of a NumericDate claim, token with non-numeric value is malformed.
+func jwtNumericDate(claims JWTClaims, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, ErrJWTMalformed
+	}
+
+	return time.Unix(int64(n), 0), true, nil
+}
+
+func jwtAlgorithmAllowed(alg string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == alg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyJWTSignature checks signature, key type must correspond to algorithm.
+func verifyJWTSignature(alg string, key interface{}, signingInput string, sig []byte) error {
+	valid := false
+
+	switch alg {
+	case "HS256":
+		if k, ok := key.([]byte); ok {
+			m := hmac.New(sha256.New, k)
+			_, _ = m.Write([]byte(signingInput))
+			valid = hmac.Equal(sig, m.Sum(nil))
+		}
+	case "RS256":
+		if k, ok := key.(*rsa.PublicKey); ok {
+			sum := sha256.Sum256([]byte(signingInput))
+			valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+		}
+	case "ES256":
+		if k, ok := key.(*ecdsa.PublicKey); ok && len(sig) == 64 && k.Curve.Params().BitSize == 256 {
+			sum := sha256.Sum256([]byte(signingInput))
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			valid = ecdsa.Verify(k, sum[:], r, s)
+		}
+	case "EdDSA":
+		if k, ok := key.(ed25519.PublicKey); ok {
+			valid = ed25519.Verify(k, []byte(signingInput), sig)
+		}
+	}
+
+	if !valid {
+		return ErrJWTSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// ErrJWTKeyNotFound is returned by JWTKeySource for unknown key.
+var ErrJWTKeyNotFound = errors.New("jwt key not found")
+
+// JWTKeySource provides keys to verify tokens.
+//
+// Key is []byte for HS256, *rsa.PublicKey for RS256, *ecdsa.PublicKey for
+// ES256 and ed25519.PublicKey for EdDSA.
+type JWTKeySource interface {
+	JWTKey(kid, alg string) (interface{}, error)
+}
+
+// StaticJWTKeys is a JWTKeySource with keys by kid, key with empty kid is
+// used for tokens without kid.
+type StaticJWTKeys map[string]interface{}
+
+// JWTKey returns key by kid.
+func (s StaticJWTKeys) JWTKey(kid, _ string) (interface{}, error) {
+	if k, ok := s[kid]; ok {
+		return k, nil
+	}
+
+	return nil, ErrJWTKeyNotFound
+}
+
+// JWKSFile is a JWTKeySource that loads keys from a local JSON Web Key Set
+// file and reloads it when modified, so that keys can be rotated without
+// restart.
+type JWKSFile struct {
+	mu   sync.Mutex
+	file reloadingFile
+	keys StaticJWTKeys
+}
+
+// NewJWKSFile creates a JWKSFile, modification time of the file is checked
+// not more often than interval, or once a second when a token has unknown kid.
+func NewJWKSFile(path string, interval time.Duration) (*JWKSFile, error) {
+	f := &JWKSFile{}
+	f.file = reloadingFile{path: path, interval: interval, load: func(data []byte) error {
+		keys, err := ParseJWKS(data)
+		if err == nil {
+			f.keys = keys
+		}
+
+		return err
+	}}
+
+	if err := f.file.reload(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// JWTKey returns key by kid.
+func (f *JWKSFile) JWTKey(kid, alg string) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+
+	// Keeping previous keys on failure.
+	_ = f.file.reloadIfDue(now)
+
+	k, err := f.keys.JWTKey(kid, alg)
+
+	// Unknown kid may come with a rotated key, but it may also come with garbage tokens.
+	if err == ErrJWTKeyNotFound && now.Sub(f.file.lastCheck) >= time.Second {
+		_ = f.file.reload(now)
+
+		return f.keys.JWTKey(kid, alg)
+	}
+
+	return k, err
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses public keys of JSON Web Key Set, it supports RSA, EC P-256,
+// OKP Ed25519 and oct key types.
+func ParseJWKS(data []byte) (StaticJWTKeys, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(StaticJWTKeys, len(set.Keys))
+
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
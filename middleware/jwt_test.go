@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(input))
+
+	var (
+		sig []byte
+		err error
+	)
+
+	switch k := key.(type) {
+	case []byte:
+		m := hmac.New(sha256.New, k)
+		m.Write([]byte(input))
+		sig = m.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		r, s, e := ecdsa.Sign(rand.Reader, k, sum[:])
+		rb, sb := r.Bytes(), s.Bytes()
+		sig, err = make([]byte, 64), e
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWT(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hsKey := []byte("secret")
+
+	r := fchi.NewRouter()
+	r.Use(JWT(JWTOptions{
+		Keys: StaticJWTKeys{
+			"hs": hsKey, "rs": &rsaKey.PublicKey, "es": &ecKey.PublicKey, "ed": edPub,
+		},
+		Issuer:              "https://issuer.test",
+		Audience:            "api",
+		ClockSkew:           time.Minute,
+		CookieName:          "token",
+		QueryParam:          "access_token",
+		CredentialsOptional: true,
+		now:                 func() time.Time { return now },
+	}))
+
+	h := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString(JWTClaimsFromContext(ctx).Subject())
+	})
+
+	r.Get("/public", h)
+	r.With(RequireScope("users:read")).Get("/users", h)
+	r.With(RequireClaims(map[string]interface{}{"groups": "admin"})).Delete("/users", h)
+
+	valid := map[string]interface{}{
+		"sub": "u1", "iss": "https://issuer.test", "aud": []string{"api", "web"},
+		"exp": now.Add(time.Hour).Unix(), "nbf": now.Unix(),
+		"scope": "users:read users:write", "groups": []string{"staff", "admin"},
+	}
+
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := make(map[string]interface{}, len(valid))
+		for n, v := range valid {
+			c[n] = v
+		}
+
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+
+		return c
+	}
+
+	request := func(method, path string, prepare func(req *fasthttp.Request)) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(method)
+		rc.Request.SetRequestURI(path)
+
+		if prepare != nil {
+			prepare(&rc.Request)
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	bearer := func(token string) func(req *fasthttp.Request) {
+		return func(req *fasthttp.Request) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	for _, c := range []struct {
+		name    string
+		method  string
+		path    string
+		prepare func(req *fasthttp.Request)
+		status  int
+		body    string
+	}{
+		{name: "anonymous", path: "/public", status: 200},
+		{name: "anonymous protected", path: "/users", status: 401},
+		{name: "HS256", path: "/users", status: 200, body: "u1", prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, valid))},
+		{name: "RS256", path: "/users", status: 200, body: "u1", prepare: bearer(signTestJWT(t, "RS256", "rs", rsaKey, valid))},
+		{name: "ES256", path: "/users", status: 200, body: "u1", prepare: bearer(signTestJWT(t, "ES256", "es", ecKey, valid))},
+		{name: "EdDSA", path: "/users", status: 200, body: "u1", prepare: bearer(signTestJWT(t, "EdDSA", "ed", edKey, valid))},
+		{name: "cookie", path: "/users", status: 200, body: "u1", prepare: func(req *fasthttp.Request) {
+			req.Header.SetCookie("token", signTestJWT(t, "ES256", "es", ecKey, valid))
+		}},
+		{name: "query", path: "/users?access_token=" + signTestJWT(t, "EdDSA", "ed", edKey, valid), status: 200, body: "u1"},
+		{name: "claims", method: "DELETE", path: "/users", status: 200, body: "u1", prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, valid))},
+		{
+			name: "missing claim", method: "DELETE", path: "/users", status: 403,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("groups", []string{"staff"}))),
+		},
+		{
+			name: "missing scope", path: "/users", status: 403,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("scope", "users:write"))),
+		},
+		{
+			name: "key confusion", path: "/users", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "rs", hsKey, valid)),
+		},
+		{name: "unknown key", path: "/public", status: 401, prepare: bearer(signTestJWT(t, "HS256", "other", hsKey, valid))},
+		{name: "alg none", path: "/public", status: 401, prepare: bearer(signTestJWT(t, "none", "hs", hsKey, valid))},
+		{
+			name: "expired", path: "/public", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("exp", now.Add(-2*time.Minute).Unix()))),
+		},
+		{
+			name: "expired within skew", path: "/public", status: 200, body: "u1",
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("exp", now.Add(-30*time.Second).Unix()))),
+		},
+		{
+			name: "expired at skew boundary", path: "/public", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("exp", now.Add(-time.Minute).Unix()))),
+		},
+		{
+			name: "non-numeric exp", path: "/public", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("exp", "never"))),
+		},
+		{
+			name: "non-numeric nbf", path: "/public", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("nbf", "soon"))),
+		},
+		{
+			name: "not valid yet", path: "/public", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("nbf", now.Add(2*time.Minute).Unix()))),
+		},
+		{
+			name: "issuer", path: "/public", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("iss", "https://evil.test"))),
+		},
+		{
+			name: "audience", path: "/public", status: 401,
+			prepare: bearer(signTestJWT(t, "HS256", "hs", hsKey, with("aud", "web"))),
+		},
+	} {
+		method := c.method
+		if method == "" {
+			method = "GET"
+		}
+
+		rc := request(method, c.path, c.prepare)
+
+		if rc.Response.StatusCode() != c.status || (c.status == 200 && string(rc.Response.Body()) != c.body) {
+			t.Errorf("%s: unexpected response %d %s", c.name, rc.Response.StatusCode(), rc.Response.Body())
+		}
+
+		if c.status == 401 && len(rc.Response.Header.Peek("WWW-Authenticate")) == 0 {
+			t.Errorf("%s: WWW-Authenticate expected", c.name)
+		}
+	}
+}
+
+func TestJWKSFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jwks.json")
+
+	writeKey := func(kid string, pub ed25519.PublicKey, modTime time.Time) {
+		data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": base64.RawURLEncoding.EncodeToString(pub),
+		}}})
+
+		if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pub1, key1, _ := ed25519.GenerateKey(rand.Reader)
+	pub2, key2, _ := ed25519.GenerateKey(rand.Reader)
+
+	writeKey("k1", pub1, time.Now().Add(-time.Hour))
+
+	keys, err := NewJWKSFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := JWT(JWTOptions{Keys: keys})(fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {}))
+	request := func(token string) int {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(context.Background(), rc)
+
+		return rc.Response.StatusCode()
+	}
+
+	claims := map[string]interface{}{"sub": "u1"}
+
+	if status := request(signTestJWT(t, "EdDSA", "k1", key1, claims)); status != 200 {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	writeKey("k2", pub2, time.Now())
+
+	if status := request(signTestJWT(t, "EdDSA", "k2", key2, claims)); status != 200 {
+		t.Fatalf("rotated key expected, status %d", status)
+	}
+
+	if status := request(signTestJWT(t, "EdDSA", "k1", key1, claims)); status != 401 {
+		t.Fatalf("removed key expected to be rejected, status %d", status)
+	}
+}
@@ -0,0 +1,393 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// MetricsRecorder receives observations of requests served by Metrics
+// middleware, implement it to use your own metrics registry.
+type MetricsRecorder interface {
+	// RequestStarted is called before request is processed.
+	RequestStarted()
+
+	// RequestFinished is called after request is processed, including panics.
+	RequestFinished(obs RequestObservation)
+}
+
+// RequestObservation describes a processed request.
+type RequestObservation struct {
+	// Method is a request method, non-standard methods are reported as "OTHER"
+	// to keep the number of series bounded.
+	Method string
+
+	// Route is a route pattern, e.g. "/users/{id}", it is empty for
+	// requests that did not match any route.
+	Route string
+
+	Status       int
+	Duration     time.Duration
+	RequestSize  int
+	ResponseSize int
+}
+
+// StatusClass returns status class label, e.g. "2xx".
+func (o RequestObservation) StatusClass() string {
+	if o.Status < 100 || o.Status > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(o.Status/100) + "xx"
+}
+
+// Metrics is a middleware that records requests with MetricsRecorder.
+//
+// Route pattern is used as a label to keep the number of series low, so
+// the middleware has to be added with Use of a router, ie.
+//
+//  reg := middleware.NewMetricsRegistry(middleware.MetricsRegistryOpts{})
+//
+//  r := fchi.NewRouter()
+//  r.Use(middleware.Metrics(reg))
+//  r.Mount("/metrics", middleware.MetricsHandler(reg))
+func Metrics(rec MetricsRecorder) func(next fchi.Handler) fchi.Handler {
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			start := time.Now()
+
+			rec.RequestStarted()
+
+			defer func() {
+				obs := RequestObservation{
+					Method:       metricsMethod(rc.Method()),
+					Status:       rc.Response.StatusCode(),
+					Duration:     time.Since(start),
+					RequestSize:  requestSize(rc),
+					ResponseSize: responseSize(rc),
+				}
+
+				if rctx := fchi.RouteContext(rc); rctx != nil {
+					obs.Route = rctx.RoutePattern()
+				}
+
+				rec.RequestFinished(obs)
+			}()
+
+			next.ServeHTTP(ctx, rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// metricsMethod returns standard method or "OTHER".
+func metricsMethod(method []byte) string {
+	switch m := string(method); m {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodPost, fasthttp.MethodPut, fasthttp.MethodPatch,
+		fasthttp.MethodDelete, fasthttp.MethodConnect, fasthttp.MethodOptions, fasthttp.MethodTrace:
+		return m
+	}
+
+	return "OTHER"
+}
+
+func requestSize(rc *fasthttp.RequestCtx) int {
+	if l := rc.Request.Header.ContentLength(); l > 0 {
+		return l
+	}
+
+	return len(rc.Request.Body())
+}
+
+func responseSize(rc *fasthttp.RequestCtx) int {
+	if rc.Response.IsBodyStream() {
+		if l := rc.Response.Header.ContentLength(); l > 0 {
+			return l
+		}
+
+		return 0
+	}
+
+	return len(rc.Response.Body())
+}
+
+// Default buckets of MetricsRegistry histograms.
+var (
+	DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	DefaultSizeBuckets     = []float64{100, 1000, 10000, 100000, 1e6, 1e7}
+)
+
+// MetricsRegistryOpts configures MetricsRegistry.
+type MetricsRegistryOpts struct {
+	// Namespace is a prefix of metric names, default "http".
+	Namespace string
+
+	// DurationBuckets are upper bounds of latency histogram in seconds,
+	// default DefaultDurationBuckets. Buckets are sorted, they must be
+	// finite and unique.
+	DurationBuckets []float64
+
+	// SizeBuckets are upper bounds of request and response size histograms
+	// in bytes, default DefaultSizeBuckets. Buckets are sorted, they must be
+	// finite and unique.
+	SizeBuckets []float64
+}
+
+// MetricsRegistry is a dependency-free MetricsRecorder that exposes metrics
+// in Prometheus text format.
+type MetricsRegistry struct {
+	opts MetricsRegistryOpts
+
+	mu       sync.Mutex
+	inFlight int64
+	series   map[metricsLabels]*metricsSeries
+}
+
+var _ MetricsRecorder = &MetricsRegistry{}
+
+// NewMetricsRegistry creates a MetricsRegistry, it panics on invalid buckets.
+func NewMetricsRegistry(opts MetricsRegistryOpts) *MetricsRegistry {
+	if opts.Namespace == "" {
+		opts.Namespace = "http"
+	}
+
+	if opts.DurationBuckets == nil {
+		opts.DurationBuckets = DefaultDurationBuckets
+	}
+
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = DefaultSizeBuckets
+	}
+
+	opts.DurationBuckets = histogramBuckets(opts.DurationBuckets)
+	opts.SizeBuckets = histogramBuckets(opts.SizeBuckets)
+
+	return &MetricsRegistry{
+		opts:   opts,
+		series: make(map[metricsLabels]*metricsSeries),
+	}
+}
+
+// histogramBuckets returns sorted copy of buckets.
+func histogramBuckets(buckets []float64) []float64 {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+
+	for i, v := range b {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			panic(fmt.Sprintf("chi/middleware: invalid histogram bucket %v", v))
+		}
+
+		if i > 0 && b[i-1] == v {
+			panic(fmt.Sprintf("chi/middleware: duplicate histogram bucket %v", v))
+		}
+	}
+
+	return b
+}
+
+type metricsLabels struct {
+	method, route, status string
+}
+
+type metricsSeries struct {
+	duration     histogram
+	requestSize  histogram
+	responseSize histogram
+}
+
+type histogram struct {
+	counts []uint64 // Non-cumulative counts per bucket.
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+
+	if i := sort.SearchFloat64s(buckets, v); i < len(buckets) {
+		h.counts[i]++
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// RequestStarted increments in-flight gauge.
+func (m *MetricsRegistry) RequestStarted() {
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+}
+
+// RequestFinished decrements in-flight gauge and records observation.
+func (m *MetricsRegistry) RequestFinished(obs RequestObservation) {
+	l := metricsLabels{method: obs.Method, route: obs.Route, status: obs.StatusClass()}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+
+	s := m.series[l]
+	if s == nil {
+		s = &metricsSeries{}
+		m.series[l] = s
+	}
+
+	s.duration.observe(m.opts.DurationBuckets, obs.Duration.Seconds())
+	s.requestSize.observe(m.opts.SizeBuckets, float64(obs.RequestSize))
+	s.responseSize.observe(m.opts.SizeBuckets, float64(obs.ResponseSize))
+}
+
+// WriteTo writes metrics in Prometheus text exposition format.
+func (m *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+
+	labels := make([]metricsLabels, 0, len(m.series))
+	for l := range m.series {
+		labels = append(labels, l)
+	}
+
+	series := make([]metricsSeries, len(labels))
+
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+
+		if a.method != b.method {
+			return a.method < b.method
+		}
+
+		return a.status < b.status
+	})
+
+	for i, l := range labels {
+		s := m.series[l]
+		series[i] = metricsSeries{
+			duration:     s.duration.clone(),
+			requestSize:  s.requestSize.clone(),
+			responseSize: s.responseSize.clone(),
+		}
+	}
+
+	inFlight := m.inFlight
+
+	m.mu.Unlock()
+
+	b := strings.Builder{}
+	ns := m.opts.Namespace
+
+	writeMetricHeader(&b, ns+"_requests_in_flight", "gauge", "Number of requests being processed.")
+	b.WriteString(ns + "_requests_in_flight " + strconv.FormatInt(inFlight, 10) + "\n")
+
+	writeMetricHeader(&b, ns+"_requests_total", "counter", "Total number of processed requests.")
+
+	for i, l := range labels {
+		b.WriteString(ns + "_requests_total" + l.String("") + " " + strconv.FormatUint(series[i].duration.count, 10) + "\n")
+	}
+
+	histograms := []struct {
+		name, help string
+		buckets    []float64
+		get        func(s *metricsSeries) *histogram
+	}{
+		{
+			name: "_request_duration_seconds", help: "Latency of processed requests.", buckets: m.opts.DurationBuckets,
+			get: func(s *metricsSeries) *histogram { return &s.duration },
+		},
+		{
+			name: "_request_size_bytes", help: "Size of request bodies.", buckets: m.opts.SizeBuckets,
+			get: func(s *metricsSeries) *histogram { return &s.requestSize },
+		},
+		{
+			name: "_response_size_bytes", help: "Size of response bodies.", buckets: m.opts.SizeBuckets,
+			get: func(s *metricsSeries) *histogram { return &s.responseSize },
+		},
+	}
+
+	for _, h := range histograms {
+		name := ns + h.name
+		writeMetricHeader(&b, name, "histogram", h.help)
+
+		for i, l := range labels {
+			writeHistogram(&b, name, l, h.buckets, h.get(&series[i]))
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+
+	return int64(n), err
+}
+
+func (h histogram) clone() histogram {
+	h.counts = append([]uint64(nil), h.counts...)
+
+	return h
+}
+
+// String formats labels with an optional le label.
+func (l metricsLabels) String(le string) string {
+	s := `{method="` + escapeLabel(l.method) + `",route="` + escapeLabel(l.route) + `",status="` + l.status + `"`
+	if le != "" {
+		s += `,le="` + le + `"`
+	}
+
+	return s + "}"
+}
+
+func writeMetricHeader(b *strings.Builder, name, typ, help string) {
+	b.WriteString("# HELP " + name + " " + help + "\n")
+	b.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func writeHistogram(b *strings.Builder, name string, l metricsLabels, buckets []float64, h *histogram) {
+	var cumulative uint64
+
+	for i, le := range buckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+
+		b.WriteString(name + "_bucket" + l.String(formatFloat(le)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+
+	b.WriteString(name + "_bucket" + l.String("+Inf") + " " + strconv.FormatUint(h.count, 10) + "\n")
+	b.WriteString(name + "_sum" + l.String("") + " " + formatFloat(h.sum) + "\n")
+	b.WriteString(name + "_count" + l.String("") + " " + strconv.FormatUint(h.count, 10) + "\n")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// MetricsHandler is an exposition handler of MetricsRegistry in Prometheus
+// text format, it can be mounted like Profiler, ie.
+//
+//  r.Mount("/metrics", middleware.MetricsHandler(reg))
+func MetricsHandler(reg *MetricsRegistry) fchi.Handler {
+	return NoCache(fchi.HandlerFunc(func(_ context.Context, rc *fasthttp.RequestCtx) {
+		rc.Response.Header.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+		_, _ = reg.WriteTo(rc)
+	}))
+}
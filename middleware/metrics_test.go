@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := NewMetricsRegistry(MetricsRegistryOpts{
+		Namespace:   "api",
+		SizeBuckets: []float64{100, 10},
+	})
+
+	r := fchi.NewRouter()
+	r.Use(Metrics(reg))
+	r.Mount("/metrics", MetricsHandler(reg))
+	r.Post("/users/{id}", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		if fchi.URLParam(rc, "id") == "0" {
+			rc.Error("not found", fasthttp.StatusNotFound)
+
+			return
+		}
+
+		rc.WriteString(strings.Repeat("a", 20))
+	}))
+
+	for _, id := range []string{"1", "2", "0"} {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod("POST")
+		rc.Request.SetRequestURI("/users/" + id)
+		rc.Request.SetBodyString("12345")
+		r.ServeHTTP(context.Background(), rc)
+	}
+
+	for _, method := range []string{"FOO1", "FOO2"} {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(method)
+		rc.Request.SetRequestURI("/unknown")
+		r.ServeHTTP(context.Background(), rc)
+	}
+
+	rc := &fasthttp.RequestCtx{}
+	rc.Request.SetRequestURI("/metrics")
+	r.ServeHTTP(context.Background(), rc)
+
+	body := string(rc.Response.Body())
+
+	for _, line := range []string{
+		"# TYPE api_requests_in_flight gauge\napi_requests_in_flight 1\n",
+		`api_requests_total{method="POST",route="/users/{id}",status="2xx"} 2`,
+		`api_requests_total{method="POST",route="/users/{id}",status="4xx"} 1`,
+		"# TYPE api_request_duration_seconds histogram\n",
+		`api_request_duration_seconds_count{method="POST",route="/users/{id}",status="2xx"} 2`,
+		`api_request_size_bytes_bucket{method="POST",route="/users/{id}",status="2xx",le="10"} 2`,
+		`api_request_size_bytes_sum{method="POST",route="/users/{id}",status="2xx"} 10`,
+		`api_response_size_bytes_bucket{method="POST",route="/users/{id}",status="2xx",le="10"} 0`,
+		`api_response_size_bytes_bucket{method="POST",route="/users/{id}",status="2xx",le="100"} 2`,
+		`api_response_size_bytes_bucket{method="POST",route="/users/{id}",status="2xx",le="+Inf"} 2`,
+		`api_requests_total{method="OTHER",route="",status="4xx"} 2`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestNewMetricsRegistry_invalidBuckets(t *testing.T) {
+	for _, buckets := range [][]float64{{1, math.NaN()}, {1, math.Inf(1)}, {1, 2, 1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("panic expected for buckets %v", buckets)
+				}
+			}()
+
+			NewMetricsRegistry(MetricsRegistryOpts{DurationBuckets: buckets})
+		}()
+	}
+}
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// MirrorDiff describes differences of primary and shadow responses of a request.
+type MirrorDiff struct {
+	Method string
+	URI    string
+
+	PrimaryStatus int
+	ShadowStatus  int
+
+	// Headers lists compared headers with different values.
+	Headers []string
+
+	// BodyDiffers is set if response bodies are different.
+	BodyDiffers bool
+
+	// Err is set if shadow request timed out or panicked.
+	Err error
+}
+
+// MirrorOptions configures Mirror middleware.
+type MirrorOptions struct {
+	// Shadow serves copies of requests, e.g. proxy.Handler of a new service.
+	Shadow fchi.Handler
+
+	// Percent of requests to mirror, default 100.
+	Percent float64
+
+	// Concurrency limits shadow requests in progress, requests beyond the
+	// limit are not mirrored, default 10.
+	Concurrency int
+
+	// Timeout is a deadline of shadow request context, default 5 seconds.
+	Timeout time.Duration
+
+	// MaxBodySize limits body of mirrored requests, default 1 MiB.
+	MaxBodySize int
+
+	// OnDiff is called with differences of shadow response from primary
+	// response, responses are not compared if it is not set.
+	OnDiff func(diff MirrorDiff)
+
+	// CompareHeaders lists response headers to compare, default Content-Type.
+	CompareHeaders []string
+
+	random func() float64
+}
+
+// Mirror is a middleware that copies a sample of requests to a shadow handler
+// in background, shadow response is discarded and does not affect the client.
+//
+//   r.Use(middleware.Mirror(middleware.MirrorOptions{
+//   	Shadow:  proxy.Handler(proxy.Options{Upstreams: []string{"http://rewrite:8080"}}),
+//   	Percent: 5,
+//   	OnDiff:  func(d middleware.MirrorDiff) { log.Printf("shadow diff: %+v", d) },
+//   }))
+//
+// Upgrade requests and requests with body larger than MaxBodySize are not
+// mirrored. Shadow request context is not derived from the request context.
+// Bodies are not compared for streamed primary responses.
+func Mirror(opts MirrorOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.Shadow == nil {
+		panic("chi/middleware: Mirror requires a shadow handler")
+	}
+
+	if opts.Percent <= 0 {
+		opts.Percent = 100
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 10
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+
+	if opts.CompareHeaders == nil {
+		opts.CompareHeaders = []string{fasthttp.HeaderContentType}
+	}
+
+	if opts.random == nil {
+		opts.random = rand.Float64
+	}
+
+	slots := make(chan struct{}, opts.Concurrency)
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			if !mirrored(rc, opts) {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			select {
+			case slots <- struct{}{}:
+			default:
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			// Body stream of known size is buffered to be copied.
+			_ = rc.Request.Body()
+
+			req := &fasthttp.Request{}
+			rc.Request.CopyTo(req)
+
+			remoteAddr := rc.RemoteAddr()
+
+			var primary chan *fasthttp.Response
+
+			if opts.OnDiff != nil {
+				primary = make(chan *fasthttp.Response, 1)
+
+				defer func() {
+					primary <- primaryResponse(rc)
+				}()
+			}
+
+			go func() {
+				defer func() { <-slots }()
+
+				serveShadow(opts, req, remoteAddr, primary)
+			}()
+
+			next.ServeHTTP(ctx, rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// mirrored checks if request is sampled and can be copied.
+func mirrored(rc *fasthttp.RequestCtx, opts MirrorOptions) bool {
+	if opts.Percent < 100 && opts.random()*100 >= opts.Percent {
+		return false
+	}
+
+	if rc.Request.Header.ConnectionUpgrade() {
+		return false
+	}
+
+	if rc.Request.IsBodyStream() {
+		cl := rc.Request.Header.ContentLength()
+
+		return cl >= 0 && cl <= opts.MaxBodySize
+	}
+
+	return len(rc.Request.Body()) <= opts.MaxBodySize
+}
+
+// primaryResponse returns a copy of response for comparison.
+func primaryResponse(rc *fasthttp.RequestCtx) *fasthttp.Response {
+	resp := &fasthttp.Response{}
+
+	if rc.Response.IsBodyStream() {
+		// Streamed body is not available for comparison.
+		rc.Response.Header.CopyTo(&resp.Header)
+		resp.SkipBody = true
+	} else {
+		rc.Response.CopyTo(resp)
+	}
+
+	return resp
+}
+
+func serveShadow(opts MirrorOptions, req *fasthttp.Request, remoteAddr net.Addr, primary chan *fasthttp.Response) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	shadow := &fasthttp.RequestCtx{}
+	shadow.Init(req, remoteAddr, nil)
+
+	// Response body stream is closed on reset.
+	defer shadow.Response.Reset()
+
+	var err error
+
+	func() {
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				err = fmt.Errorf("shadow handler panicked: %v", rvr)
+			}
+		}()
+
+		opts.Shadow.ServeHTTP(ctx, shadow)
+	}()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	if primary == nil {
+		return
+	}
+
+	p := <-primary
+
+	diff := MirrorDiff{
+		Method:        string(req.Header.Method()),
+		URI:           string(req.RequestURI()),
+		PrimaryStatus: p.StatusCode(),
+		ShadowStatus:  shadow.Response.StatusCode(),
+		Err:           err,
+	}
+
+	for _, h := range opts.CompareHeaders {
+		if !bytes.Equal(p.Header.Peek(h), shadow.Response.Header.Peek(h)) {
+			diff.Headers = append(diff.Headers, h)
+		}
+	}
+
+	if !p.SkipBody && err == nil {
+		diff.BodyDiffers = !bytes.Equal(p.Body(), shadow.Response.Body())
+	}
+
+	if diff.Err != nil || diff.PrimaryStatus != diff.ShadowStatus || len(diff.Headers) > 0 || diff.BodyDiffers {
+		opts.OnDiff(diff)
+	}
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestMirror(t *testing.T) {
+	shadowed := make(chan string, 10)
+	diffs := make(chan MirrorDiff, 10)
+	release := make(chan struct{})
+
+	shadow := fchi.NewRouter()
+	shadow.Post("/same", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		shadowed <- string(rc.Request.Header.Peek("X-Test")) + " " + string(rc.Request.Body())
+
+		rc.SetContentType("text/plain")
+		rc.Write(rc.Request.Body())
+	}))
+	shadow.Post("/diff", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		shadowed <- "diff"
+
+		rc.SetContentType("application/json")
+		rc.SetStatusCode(fasthttp.StatusCreated)
+		rc.WriteString("{}")
+	}))
+	shadow.Post("/slow", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		<-ctx.Done()
+	}))
+	shadow.Post("/panic", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		panic("failed")
+	}))
+	shadow.Post("/blocked", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		shadowed <- "blocked"
+
+		<-release
+	}))
+
+	primary := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.SetContentType("text/plain")
+		rc.Write(rc.Request.Body())
+
+		// Client request is not affected by shadow.
+		rc.Request.Header.Set("X-Test", "changed")
+	})
+
+	r := fchi.NewRouter()
+	r.Use(Mirror(MirrorOptions{
+		Shadow:      shadow,
+		Concurrency: 2,
+		Timeout:     20 * time.Millisecond,
+		OnDiff:      func(d MirrorDiff) { diffs <- d },
+	}))
+	r.Post("/*", primary)
+
+	serve := func(h fchi.Handler, path string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(fasthttp.MethodPost)
+		rc.Request.SetRequestURI(path)
+		rc.Request.Header.Set("X-Test", "original")
+		rc.Request.SetBodyString("payload")
+
+		h.ServeHTTP(context.Background(), rc)
+
+		if string(rc.Response.Body()) != "payload" {
+			t.Fatalf("unexpected primary response: %s", rc.Response.Body())
+		}
+
+		return rc
+	}
+
+	receive := func() MirrorDiff {
+		select {
+		case d := <-diffs:
+			return d
+		case <-time.After(time.Second):
+			t.Fatal("diff expected")
+		}
+
+		return MirrorDiff{}
+	}
+
+	serve(r, "/same")
+
+	if s := <-shadowed; s != "original payload" {
+		t.Fatalf("unexpected shadow request: %s", s)
+	}
+
+	serve(r, "/diff")
+
+	if d := receive(); d.URI != "/diff" || d.PrimaryStatus != 200 || d.ShadowStatus != 201 ||
+		len(d.Headers) != 1 || d.Headers[0] != "Content-Type" || !d.BodyDiffers || d.Err != nil {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+
+	<-shadowed
+
+	serve(r, "/slow")
+
+	if d := receive(); d.URI != "/slow" || !errors.Is(d.Err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+
+	serve(r, "/panic")
+
+	if d := receive(); d.URI != "/panic" || d.Err == nil || d.Err.Error() != "shadow handler panicked: failed" {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+
+	// Requests beyond concurrency limit are not mirrored.
+	serve(r, "/blocked")
+	serve(r, "/blocked")
+	<-shadowed
+	<-shadowed
+	serve(r, "/blocked")
+	close(release)
+
+	receive()
+	receive()
+
+	select {
+	case s := <-shadowed:
+		t.Fatalf("unexpected shadow request: %s", s)
+	case d := <-diffs:
+		t.Fatalf("unexpected diff: %+v", d)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Requests out of sample are not mirrored.
+	sampled := Mirror(MirrorOptions{
+		Shadow:  shadow,
+		Percent: 30,
+		random:  func() float64 { return 0.5 },
+	})(primary)
+
+	serve(sampled, "/same")
+
+	select {
+	case s := <-shadowed:
+		t.Fatalf("unexpected shadow request: %s", s)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
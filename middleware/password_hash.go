@@ -0,0 +1,343 @@
+package middleware
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnsupportedHash is returned by CheckPasswordHash for unknown hash format.
+var ErrUnsupportedHash = errors.New("unsupported password hash")
+
+// CheckPasswordHash compares password with a hash in one of the formats:
+// bcrypt ($2a$, $2b$, $2y$), argon2 ($argon2id$, $argon2i$), SHA-crypt ($5$, $6$),
+// Apache MD5 ($apr1$) or SHA1 ({SHA}).
+func CheckPasswordHash(hashed, password string) (bool, error) {
+	var computed string
+
+	switch {
+	case strings.HasPrefix(hashed, "$2a$"), strings.HasPrefix(hashed, "$2b$"), strings.HasPrefix(hashed, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+
+		return err == nil, err
+	case strings.HasPrefix(hashed, "$argon2"):
+		return checkArgon2(hashed, password)
+	case strings.HasPrefix(hashed, "$5$"):
+		computed = shaCrypt(sha256.New, "$5$", hashed, password)
+	case strings.HasPrefix(hashed, "$6$"):
+		computed = shaCrypt(sha512.New, "$6$", hashed, password)
+	case strings.HasPrefix(hashed, "$apr1$"):
+		computed = apr1Crypt(hashed, password)
+	case strings.HasPrefix(hashed, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		return false, ErrUnsupportedHash
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hashed)) == 1, nil
+}
+
+// checkArgon2 checks PHC string, e.g. "$argon2id$v=19$m=65536,t=3,p=4$salt$hash".
+func checkArgon2(hashed, password string) (bool, error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[2] != "v=19" {
+		return false, ErrUnsupportedHash
+	}
+
+	var (
+		memory, time uint32
+		threads      uint8
+	)
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrUnsupportedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrUnsupportedHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrUnsupportedHash
+	}
+
+	var computed []byte
+
+	switch parts[1] {
+	case "argon2id":
+		computed = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	case "argon2i":
+		computed = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	default:
+		return false, ErrUnsupportedHash
+	}
+
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptEncode encodes bytes by groups of 3 indexes of digest in crypt base64.
+func cryptEncode(sb *strings.Builder, digest []byte, groups [][3]int, tail []int) {
+	encode := func(w uint, n int) {
+		for i := 0; i < n; i++ {
+			sb.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+
+	for _, g := range groups {
+		encode(uint(digest[g[0]])<<16|uint(digest[g[1]])<<8|uint(digest[g[2]]), 4)
+	}
+
+	w := uint(0)
+	for _, i := range tail {
+		w = w<<8 | uint(digest[i])
+	}
+
+	encode(w, len(tail)+1)
+}
+
+var (
+	sha256CryptGroups = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptGroups = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+		{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+		{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+	}
+)
+
+// shaCrypt computes SHA-crypt hash with salt and rounds of the hashed value.
+func shaCrypt(newHash func() hash.Hash, prefix, hashed, password string) string {
+	const defaultRounds = 5000
+
+	params := strings.TrimPrefix(hashed, prefix)
+	rounds, customRounds := defaultRounds, false
+
+	if strings.HasPrefix(params, "rounds=") {
+		i := strings.IndexByte(params, '$')
+		if i < 0 {
+			return ""
+		}
+
+		r, err := strconv.Atoi(params[len("rounds="):i])
+		if err != nil {
+			return ""
+		}
+
+		rounds, customRounds, params = r, true, params[i+1:]
+
+		if rounds < 1000 {
+			rounds = 1000
+		} else if rounds > 999999999 {
+			rounds = 999999999
+		}
+	}
+
+	salt := params
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	p, s := []byte(password), []byte(salt)
+
+	h := newHash()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(p)
+	h.Write(s)
+
+	for n := len(p); n > 0; n -= len(b) {
+		if n > len(b) {
+			h.Write(b)
+		} else {
+			h.Write(b[:n])
+		}
+	}
+
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+
+	a := h.Sum(nil)
+
+	h.Reset()
+
+	for i := 0; i < len(p); i++ {
+		h.Write(p)
+	}
+
+	pBytes := repeatDigest(h.Sum(nil), len(p))
+
+	h.Reset()
+
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+
+	sBytes := repeatDigest(h.Sum(nil), len(s))
+
+	c := a
+
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+
+		if i&1 == 1 {
+			h.Write(pBytes)
+		} else {
+			h.Write(c)
+		}
+
+		if i%3 != 0 {
+			h.Write(sBytes)
+		}
+
+		if i%7 != 0 {
+			h.Write(pBytes)
+		}
+
+		if i&1 == 1 {
+			h.Write(c)
+		} else {
+			h.Write(pBytes)
+		}
+
+		c = h.Sum(nil)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(prefix)
+
+	if customRounds {
+		sb.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+
+	sb.WriteString(salt + "$")
+
+	if len(c) == sha256.Size {
+		cryptEncode(&sb, c, sha256CryptGroups, []int{31, 30})
+	} else {
+		cryptEncode(&sb, c, sha512CryptGroups, []int{63})
+	}
+
+	return sb.String()
+}
+
+func repeatDigest(d []byte, n int) []byte {
+	res := make([]byte, 0, n)
+
+	for len(res) < n {
+		res = append(res, d...)
+	}
+
+	return res[:n]
+}
+
+// apr1Crypt computes Apache MD5 hash with salt of the hashed value.
+func apr1Crypt(hashed, password string) string {
+	const prefix = "$apr1$"
+
+	salt := strings.TrimPrefix(hashed, prefix)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	p, s := []byte(password), []byte(salt)
+
+	h := md5.New()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	final := h.Sum(nil)
+
+	h.Reset()
+	h.Write(p)
+	h.Write([]byte(prefix))
+	h.Write(s)
+
+	for n := len(p); n > 0; n -= 16 {
+		if n > 16 {
+			h.Write(final)
+		} else {
+			h.Write(final[:n])
+		}
+	}
+
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(p[:1])
+		}
+	}
+
+	final = h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h.Reset()
+
+		if i&1 == 1 {
+			h.Write(p)
+		} else {
+			h.Write(final)
+		}
+
+		if i%3 != 0 {
+			h.Write(s)
+		}
+
+		if i%7 != 0 {
+			h.Write(p)
+		}
+
+		if i&1 == 1 {
+			h.Write(final)
+		} else {
+			h.Write(p)
+		}
+
+		final = h.Sum(nil)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(prefix + salt + "$")
+	cryptEncode(&sb, final, [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}}, []int{11})
+
+	return sb.String()
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPasswordHash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt := []byte("somesalt")
+	argon2Hash := "$argon2id$v=19$m=1024,t=1,p=1$" + base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("secret"), salt, 1, 1024, 1, 32))
+
+	for _, c := range []struct {
+		hash     string
+		password string
+	}{
+		{hash: string(bcryptHash), password: "secret"},
+		{hash: argon2Hash, password: "secret"},
+		{hash: "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", password: "Hello world!"},
+		{
+			hash:     "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+			password: "Hello world!",
+		},
+		{hash: "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", password: "Hello world!"},
+		{hash: "$apr1$salt123$te32nsHkuX3Vl4IZyuP3X.", password: "secret"},
+		{hash: "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", password: "secret"},
+	} {
+		if ok, err := CheckPasswordHash(c.hash, c.password); err != nil || !ok {
+			t.Errorf("%s: password expected to match: %v", c.hash, err)
+		}
+
+		if ok, err := CheckPasswordHash(c.hash, c.password+"!"); err != nil || ok {
+			t.Errorf("%s: password expected to mismatch: %v", c.hash, err)
+		}
+	}
+
+	if _, err := CheckPasswordHash("plaintext", "plaintext"); err != ErrUnsupportedHash {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
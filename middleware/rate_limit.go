@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+const errRateLimited = "Rate limit exceeded."
+
+// RateLimitAlgorithm defines how requests are counted by RateLimit.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit requests and refills
+	// Limit tokens evenly during Window.
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow allows Limit requests during any Window, approximated by
+	// weighted counters of current and previous fixed windows.
+	SlidingWindow
+)
+
+// RateLimitOpts represents a set of rate limiting options.
+type RateLimitOpts struct {
+	// Limit is a number of requests allowed per Window for a key.
+	Limit  int
+	Window time.Duration
+
+	Algorithm RateLimitAlgorithm
+
+	// KeyFn identifies the client, default KeyByIP.
+	// Requests with empty key are not limited.
+	KeyFn func(ctx context.Context, rc *fasthttp.RequestCtx) string
+
+	// Store keeps counters, default is a new RateLimitMemoryStore.
+	// Requests are not limited if store fails.
+	Store RateLimitStore
+
+	// RetryAfterFn overrides the value of Retry-After header of limited
+	// requests, see ThrottleOpts. Default is the time until the next request
+	// is allowed.
+	RetryAfterFn func(ctxDone bool) time.Duration
+
+	// now is used in tests.
+	now func() time.Time
+}
+
+// RateLimit is a middleware that limits rate of requests per client key.
+//
+// Responses have RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, limited requests are responded with 429 Too Many Requests and
+// Retry-After header.
+//
+// Unlike Throttle, that caps the number of concurrent requests globally,
+// RateLimit counts requests of every client separately.
+func RateLimit(opts RateLimitOpts) func(next fchi.Handler) fchi.Handler {
+	if opts.Limit < 1 {
+		panic("chi/middleware: RateLimit expects limit > 0")
+	}
+
+	if opts.Window <= 0 {
+		panic("chi/middleware: RateLimit expects window > 0")
+	}
+
+	if opts.KeyFn == nil {
+		opts.KeyFn = KeyByIP
+	}
+
+	if opts.Store == nil {
+		opts.Store = NewRateLimitMemoryStore()
+	}
+
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
+	limit := strconv.Itoa(opts.Limit)
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			key := opts.KeyFn(ctx, rc)
+			if key == "" {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			var (
+				d   rateLimitDecision
+				now = opts.now()
+				ttl = opts.Window
+			)
+
+			if opts.Algorithm == SlidingWindow {
+				ttl = 2 * opts.Window
+			}
+
+			err := opts.Store.Update(key, ttl, func(state *RateLimitState) {
+				if opts.Algorithm == SlidingWindow {
+					d = slidingWindow(state, now, opts.Limit, opts.Window)
+				} else {
+					d = tokenBucket(state, now, opts.Limit, opts.Window)
+				}
+			})
+			if err != nil {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			h := &rc.Response.Header
+
+			if !d.allowed {
+				retryAfter := d.retryAfter
+				if opts.RetryAfterFn != nil {
+					retryAfter = opts.RetryAfterFn(false)
+				}
+
+				// Error resets response headers, so it goes first.
+				rc.Error(errRateLimited, fasthttp.StatusTooManyRequests)
+				h.Set("Retry-After", ceilSeconds(retryAfter))
+			}
+
+			h.Set("RateLimit-Limit", limit)
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+			h.Set("RateLimit-Reset", ceilSeconds(d.reset))
+
+			if d.allowed {
+				next.ServeHTTP(ctx, rc)
+			}
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// KeyByIP identifies client by remote IP address.
+func KeyByIP(_ context.Context, rc *fasthttp.RequestCtx) string {
+	return rc.RemoteIP().String()
+}
+
+// KeyByHeader identifies client by a request header value, e.g. "X-API-Key".
+func KeyByHeader(name string) func(ctx context.Context, rc *fasthttp.RequestCtx) string {
+	return func(_ context.Context, rc *fasthttp.RequestCtx) string {
+		return string(rc.Request.Header.Peek(name))
+	}
+}
+
+// KeyByRoutePattern groups requests by method and route pattern,
+// e.g. "GET /users/{id}", to limit rate per route.
+func KeyByRoutePattern(_ context.Context, rc *fasthttp.RequestCtx) string {
+	rctx := fchi.RouteContext(rc)
+	if rctx == nil || rctx.Routes == nil {
+		return ""
+	}
+
+	method := string(rc.Method())
+	path := string(rc.URI().PathOriginal())
+
+	mctx := fchi.NewRouteContext()
+	if !rctx.Routes.Match(mctx, method, path) {
+		return ""
+	}
+
+	return method + " " + mctx.RoutePattern()
+}
+
+// ComposeKeys joins keys of multiple key functions, e.g. to limit rate of
+// every client per route. Empty key is returned if any of the keys is empty.
+func ComposeKeys(fns ...func(ctx context.Context, rc *fasthttp.RequestCtx) string) func(ctx context.Context, rc *fasthttp.RequestCtx) string {
+	return func(ctx context.Context, rc *fasthttp.RequestCtx) string {
+		keys := make([]string, 0, len(fns))
+
+		for _, fn := range fns {
+			k := fn(ctx, rc)
+			if k == "" {
+				return ""
+			}
+
+			keys = append(keys, k)
+		}
+
+		return strings.Join(keys, "|")
+	}
+}
+
+type rateLimitDecision struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func tokenBucket(st *RateLimitState, now time.Time, limit int, window time.Duration) rateLimitDecision {
+	capacity := float64(limit)
+	perSecond := capacity / window.Seconds()
+
+	if st.Last.IsZero() {
+		st.Tokens = capacity
+		st.Last = now
+	}
+
+	st.Tokens = math.Min(capacity, st.Tokens+now.Sub(st.Last).Seconds()*perSecond)
+	st.Last = now
+
+	d := rateLimitDecision{}
+
+	if st.Tokens >= 1 {
+		st.Tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = secondsDuration((1 - st.Tokens) / perSecond)
+	}
+
+	d.remaining = int(st.Tokens)
+	d.reset = secondsDuration((capacity - st.Tokens) / perSecond)
+
+	return d
+}
+
+func slidingWindow(st *RateLimitState, now time.Time, limit int, window time.Duration) rateLimitDecision {
+	start := now.Truncate(window)
+
+	if !st.WindowStart.Equal(start) {
+		if start.Sub(st.WindowStart) == window {
+			st.Prev = st.Curr
+		} else {
+			st.Prev = 0
+		}
+
+		st.Curr = 0
+		st.WindowStart = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(window)
+	estimated := float64(st.Prev)*weight + float64(st.Curr)
+	l := float64(limit)
+
+	d := rateLimitDecision{reset: window - elapsed}
+
+	if estimated+1 <= l {
+		st.Curr++
+		estimated++
+		d.allowed = true
+	} else {
+		d.retryAfter = slidingRetryAfter(st, elapsed, l, window)
+	}
+
+	if estimated < l {
+		d.remaining = int(l - estimated)
+	}
+
+	return d
+}
+
+// slidingRetryAfter finds the time when estimated count of sliding window
+// allows one more request.
+func slidingRetryAfter(st *RateLimitState, elapsed time.Duration, limit float64, window time.Duration) time.Duration {
+	w := float64(window)
+	curr := float64(st.Curr)
+
+	// Previous window decays enough within current window.
+	if st.Prev > 0 && curr+1 <= limit {
+		t := w*(1-(limit-curr-1)/float64(st.Prev)) - float64(elapsed)
+		if t < 0 {
+			t = 0
+		}
+
+		return time.Duration(t)
+	}
+
+	// Current window becomes previous and has to decay in the next window.
+	t := w - float64(elapsed)
+	if curr > 0 {
+		t += w * (1 - (limit-1)/curr)
+	}
+
+	return time.Duration(t)
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds formats duration as a number of seconds rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
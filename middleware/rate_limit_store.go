@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitState is a per-key state of rate limiting algorithms.
+type RateLimitState struct {
+	// Tokens and Last are used by token bucket.
+	Tokens float64
+	Last   time.Time
+
+	// WindowStart, Prev and Curr are used by sliding window.
+	WindowStart time.Time
+	Prev        int64
+	Curr        int64
+}
+
+// RateLimitStore keeps rate limiting state by key.
+//
+// Implementation must apply fn atomically for a key, for example a Redis-like
+// store can load serialized state, apply fn and save it in an optimistic
+// transaction, retrying on conflicts.
+type RateLimitStore interface {
+	// Update applies fn to the state of key and keeps the result for at least ttl.
+	// New keys start with zero state.
+	Update(key string, ttl time.Duration, fn func(state *RateLimitState)) error
+}
+
+// NewRateLimitMemoryStore creates an in-memory sharded store, expired keys
+// are evicted during updates.
+func NewRateLimitMemoryStore() *RateLimitMemoryStore {
+	return &RateLimitMemoryStore{}
+}
+
+const rateLimitShards = 64
+
+// RateLimitMemoryStore is an in-memory RateLimitStore.
+type RateLimitMemoryStore struct {
+	shards    [rateLimitShards]rateLimitShard
+	lastSweep int64 // Unix nanoseconds, accessed atomically.
+
+	// now is used in tests.
+	now func() time.Time
+}
+
+type rateLimitShard struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimitEntry
+}
+
+type rateLimitEntry struct {
+	state   RateLimitState
+	expires time.Time
+}
+
+// Update applies fn to the state of key.
+func (s *RateLimitMemoryStore) Update(key string, ttl time.Duration, fn func(state *RateLimitState)) error {
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+
+	last := atomic.LoadInt64(&s.lastSweep)
+	if last == 0 {
+		atomic.CompareAndSwapInt64(&s.lastSweep, 0, now.UnixNano())
+	} else if now.Sub(time.Unix(0, last)) > ttl && atomic.CompareAndSwapInt64(&s.lastSweep, last, now.UnixNano()) {
+		s.sweep(now)
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	sh := &s.shards[h.Sum32()%rateLimitShards]
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.entries == nil {
+		sh.entries = make(map[string]*rateLimitEntry)
+	}
+
+	e := sh.entries[key]
+	if e == nil || now.After(e.expires) {
+		e = &rateLimitEntry{}
+		sh.entries[key] = e
+	}
+
+	fn(&e.state)
+	e.expires = now.Add(ttl)
+
+	return nil
+}
+
+// Len returns number of stored keys, including expired ones that are not evicted yet.
+func (s *RateLimitMemoryStore) Len() int {
+	n := 0
+
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += len(sh.entries)
+		sh.mu.Unlock()
+	}
+
+	return n
+}
+
+// sweep evicts expired entries of all shards.
+func (s *RateLimitMemoryStore) sweep(now time.Time) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+
+		for k, e := range sh.entries {
+			if now.After(e.expires) {
+				delete(sh.entries, k)
+			}
+		}
+
+		sh.mu.Unlock()
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRateLimit(t *testing.T) {
+	var now time.Time
+
+	clock := func() time.Time { return now }
+
+	for alg, retryAfter := range map[RateLimitAlgorithm]int{
+		TokenBucket:   5,  // One token is refilled in 5 seconds.
+		SlidingWindow: 15, // Full current window has to decay by half in the next window.
+	} {
+		now = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		store := NewRateLimitMemoryStore()
+		store.now = clock
+
+		r := fchi.NewRouter()
+		r.Use(RateLimit(RateLimitOpts{
+			Limit:     2,
+			Window:    10 * time.Second,
+			Algorithm: alg,
+			KeyFn:     ComposeKeys(KeyByHeader("X-API-Key"), KeyByRoutePattern),
+			Store:     store,
+			now:       clock,
+		}))
+		r.Get("/users/{id}", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			rc.WriteString("ok")
+		}))
+
+		request := func(key, path string) *fasthttp.RequestCtx {
+			rc := &fasthttp.RequestCtx{}
+			rc.Request.Header.SetMethod("GET")
+			rc.Request.SetRequestURI(path)
+			rc.Request.Header.Set("X-API-Key", key)
+			r.ServeHTTP(context.Background(), rc)
+
+			return rc
+		}
+
+		for i, path := range []string{"/users/1", "/users/2"} {
+			rc := request("a", path)
+			if rc.Response.StatusCode() != 200 || string(rc.Response.Header.Peek("RateLimit-Remaining")) != []string{"1", "0"}[i] {
+				t.Fatalf("%d: unexpected response %d, remaining %s", alg, rc.Response.StatusCode(),
+					rc.Response.Header.Peek("RateLimit-Remaining"))
+			}
+		}
+
+		rc := request("a", "/users/3")
+		if rc.Response.StatusCode() != fasthttp.StatusTooManyRequests || string(rc.Response.Header.Peek("Retry-After")) != strconv.Itoa(retryAfter) {
+			t.Fatalf("%d: unexpected response %d, retry after %s", alg, rc.Response.StatusCode(),
+				rc.Response.Header.Peek("Retry-After"))
+		}
+
+		if rc := request("b", "/users/1"); rc.Response.StatusCode() != 200 {
+			t.Fatalf("%d: another key should not be limited", alg)
+		}
+
+		if rc := request("", "/users/1"); rc.Response.StatusCode() != 200 || len(rc.Response.Header.Peek("RateLimit-Limit")) != 0 {
+			t.Fatalf("%d: empty key should not be limited", alg)
+		}
+
+		now = now.Add(time.Duration(retryAfter) * time.Second)
+
+		if rc := request("a", "/users/1"); rc.Response.StatusCode() != 200 {
+			t.Fatalf("%d: request expected to be allowed after retry delay", alg)
+		}
+
+		now = now.Add(time.Minute)
+
+		if rc := request("c", "/users/1"); rc.Response.StatusCode() != 200 || store.Len() != 1 {
+			t.Fatalf("%d: expired keys expected to be evicted, %d keys", alg, store.Len())
+		}
+	}
+}
@@ -4,12 +4,16 @@ package middleware
 // https://github.com/zenazn/goji/tree/master/web/middleware
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"runtime"
 	"runtime/debug"
 	"strings"
 
@@ -21,23 +25,246 @@ import (
 // backtrace), and returns a HTTP 500 (Internal Server Error) status if
 // possible. Recoverer prints a request ID if one is provided.
 //
+// The panic is passed to fchi.HandleError as PanicError, so it is rendered
+// by the ErrorRenderer of the router and is visible to outer middlewares
+// with fchi.RequestError.
+//
 // Alternatively, look at https://github.com/pressly/lg middleware pkgs.
 func Recoverer(next fchi.Handler) fchi.Handler {
-	fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+	return RecovererWithOptions(RecovererOptions{})(next)
+}
+
+// RecovererOptions configures RecovererWithOptions.
+type RecovererOptions struct {
+	// Output receives panic reports, default os.Stderr.
+	Output io.Writer
+
+	// JSONStack enables machine-readable JSON reports with stack frames
+	// instead of ANSI colored text.
+	JSONStack bool
+
+	// OnPanic is called for every recovered panic after the report is written.
+	OnPanic func(ctx context.Context, rc *fasthttp.RequestCtx, p Panic)
+
+	// Renderer writes the error response, default is the ErrorRenderer of
+	// the router, see fchi.HandleError.
+	Renderer fchi.ErrorRenderer
+
+	// RePanic decides if the panic should be raised again after it is
+	// reported, for example to let process crash on unrecoverable states.
+	RePanic func(p Panic) bool
+}
+
+// Panic describes a recovered panic.
+type Panic struct {
+	Value     interface{}
+	RequestID string
+	Stack     []byte
+	Frames    []StackFrame
+
+	// Streaming is true if panic happened in a body stream writer after
+	// response headers were sent, no error response is rendered in this case.
+	Streaming bool
+}
+
+// StackFrame is a single frame of panic stack trace.
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+type ctxKeyRecoverer struct{}
+
+// RecovererWithOptions is a middleware that recovers from panics with custom reporting and rendering.
+//
+// Panics in body stream writers happen after the handler has returned, use
+// SetBodyStreamWriter instead of rc.SetBodyStreamWriter to have them reported.
+func RecovererWithOptions(opts RecovererOptions) func(next fchi.Handler) fchi.Handler {
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			defer func() {
+				if rvr := recover(); rvr != nil && rvr != http.ErrAbortHandler {
+					p := opts.report(ctx, rc, rvr, false)
+					err := PanicError{Value: rvr}
+
+					if opts.Renderer != nil {
+						fchi.SetRequestError(rc, err)
+						opts.Renderer.RenderError(ctx, rc, err)
+					} else {
+						fchi.HandleError(ctx, rc, err)
+					}
+
+					if opts.RePanic != nil && opts.RePanic(p) {
+						panic(rvr)
+					}
+				}
+			}()
+
+			next.ServeHTTP(context.WithValue(ctx, ctxKeyRecoverer{}, &opts), rc)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// SetBodyStreamWriter sets response body stream writer that is protected by
+// Recoverer of the request.
+//
+// A panic in the stream writer is reported and the response is aborted, so
+// that client receives an incomplete body instead of a truncated one that
+// looks complete.
+func SetBodyStreamWriter(ctx context.Context, rc *fasthttp.RequestCtx, sw fasthttp.StreamWriter) {
+	opts, _ := ctx.Value(ctxKeyRecoverer{}).(*RecovererOptions)
+	if opts == nil {
+		rc.SetBodyStreamWriter(sw)
+
+		return
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		bw := bufio.NewWriter(pw)
+
 		defer func() {
-			if rvr := recover(); rvr != nil && rvr != http.ErrAbortHandler {
-				PrintPrettyStack(rvr)
+			if rvr := recover(); rvr != nil {
+				p := opts.report(ctx, rc, rvr, true)
+				_ = pw.CloseWithError(PanicError{Value: rvr})
+
+				if opts.RePanic != nil && opts.RePanic(p) {
+					panic(rvr)
+				}
 
-				rc.Response.SetStatusCode(fasthttp.StatusInternalServerError)
+				return
 			}
+
+			_ = pw.CloseWithError(bw.Flush())
 		}()
 
-		next.ServeHTTP(ctx, rc)
+		sw(bw)
+	}()
+
+	rc.SetBodyStream(pr, -1)
+}
+
+// report writes panic report and calls OnPanic hook.
+func (opts *RecovererOptions) report(ctx context.Context, rc *fasthttp.RequestCtx, rvr interface{}, streaming bool) Panic {
+	p := Panic{
+		Value:     rvr,
+		RequestID: GetReqID(ctx),
+		Stack:     debug.Stack(),
+		Frames:    panicFrames(),
+		Streaming: streaming,
 	}
 
-	return fchi.HandlerFunc(fn)
+	if opts.JSONStack {
+		_, _ = opts.Output.Write(jsonReport(rc, p))
+	} else {
+		s := prettyStack{}
+
+		out, err := s.parse(p.Stack, rvr)
+		if err != nil {
+			// print stdlib output as a fallback
+			out = p.Stack
+		}
+
+		if p.RequestID != "" {
+			_, _ = fmt.Fprintf(opts.Output, "\n request_id: %s", p.RequestID)
+		}
+
+		_, _ = opts.Output.Write(out)
+	}
+
+	if opts.OnPanic != nil {
+		opts.OnPanic(ctx, rc, p)
+	}
+
+	return p
+}
+
+// jsonReport makes a single line JSON panic report.
+func jsonReport(rc *fasthttp.RequestCtx, p Panic) []byte {
+	report := struct {
+		Panic     string       `json:"panic"`
+		RequestID string       `json:"request_id,omitempty"`
+		Method    string       `json:"method"`
+		URI       string       `json:"uri"`
+		Streaming bool         `json:"streaming,omitempty"`
+		Stack     []StackFrame `json:"stack"`
+	}{
+		Panic:     fmt.Sprintf("%v", p.Value),
+		RequestID: p.RequestID,
+		Method:    string(rc.Method()),
+		URI:       string(rc.RequestURI()),
+		Streaming: p.Streaming,
+		Stack:     p.Frames,
+	}
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		out, _ = json.Marshal(map[string]string{"panic": report.Panic, "error": err.Error()})
+	}
+
+	return append(out, '\n')
+}
+
+// panicFrames collects stack frames of the panicking goroutine starting
+// from the function that called panic.
+func panicFrames() []StackFrame {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	frames := runtime.CallersFrames(pcs)
+
+	var (
+		res      []StackFrame
+		panicked bool
+	)
+
+	for {
+		f, more := frames.Next()
+
+		if panicked {
+			res = append(res, StackFrame{Function: f.Function, File: f.File, Line: f.Line})
+		} else if f.Function == "runtime.gopanic" {
+			panicked = true
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return res
+}
+
+// PanicError is an error made of a value recovered from panic.
+type PanicError struct {
+	Value interface{}
+}
+
+// Error returns panic message.
+func (e PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// StatusCode returns HTTP status of the error.
+func (e PanicError) StatusCode() int {
+	return fasthttp.StatusInternalServerError
+}
+
+// Unwrap returns panic value if it is an error.
+func (e PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+
+	return err
 }
 
+// PrintPrettyStack prints colored stack trace of a panic to os.Stderr.
 func PrintPrettyStack(rvr interface{}) {
 	debugStack := debug.Stack()
 	s := prettyStack{}
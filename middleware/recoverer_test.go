@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRecovererWithOptions(t *testing.T) {
+	var (
+		out    bytes.Buffer
+		mu     sync.Mutex
+		panics []Panic
+	)
+
+	r := fchi.NewRouter()
+	r.Use(RequestID)
+	r.Use(RecovererWithOptions(RecovererOptions{
+		Output:    &out,
+		JSONStack: true,
+		OnPanic: func(ctx context.Context, rc *fasthttp.RequestCtx, p Panic) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			panics = append(panics, p)
+		},
+	}))
+
+	r.Get("/panic", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		panic("oops")
+	}))
+
+	r.Get("/stream", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		SetBodyStreamWriter(ctx, rc, func(w *bufio.Writer) {
+			_, _ = w.WriteString("partial")
+			_ = w.Flush()
+
+			panic("stream oops")
+		})
+	}))
+
+	rc := &fasthttp.RequestCtx{}
+	rc.Request.Header.SetMethod("GET")
+	rc.Request.SetRequestURI("/panic")
+	rc.Request.Header.Set("Accept", "application/json")
+	rc.Request.Header.Set(RequestIDHeader, "req-1")
+
+	r.ServeHTTP(context.Background(), rc)
+
+	if rc.Response.StatusCode() != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d", rc.Response.StatusCode())
+	}
+
+	if body := string(rc.Response.Body()); body != `{"title":"Internal Server Error","status":500}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	var report struct {
+		Panic     string       `json:"panic"`
+		RequestID string       `json:"request_id"`
+		URI       string       `json:"uri"`
+		Stack     []StackFrame `json:"stack"`
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err, out.String())
+	}
+
+	if report.Panic != "oops" || report.RequestID != "req-1" || report.URI != "/panic" {
+		t.Fatalf("unexpected report: %s", out.String())
+	}
+
+	if len(report.Stack) == 0 || !strings.Contains(report.Stack[0].Function, "TestRecovererWithOptions") {
+		t.Fatalf("unexpected stack: %+v", report.Stack)
+	}
+
+	ts := fchi.NewTestServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		t.Fatalf("error expected for aborted stream, body: %s", body)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(panics) != 2 || panics[0].RequestID != "req-1" || !panics[1].Streaming || panics[1].Value != "stream oops" {
+		t.Fatalf("unexpected panics: %+v", panics)
+	}
+}
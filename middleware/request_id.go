@@ -26,6 +26,11 @@ const RequestIDKey ctxKeyRequestID = 0
 // Exported so that it can be changed by developers
 var RequestIDHeader = "X-Request-Id"
 
+// RequestIDFromTrace makes RequestID use the trace ID of the span started by
+// Trace middleware instead of generating a new ID, Trace has to be used before
+// RequestID.
+var RequestIDFromTrace = false
+
 var prefix string
 var reqid uint64
 
@@ -69,6 +74,11 @@ func init() {
 func RequestID(next fchi.Handler) fchi.Handler {
 	fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
 		requestID := string(rc.Request.Header.Peek(RequestIDHeader))
+		if requestID == "" && RequestIDFromTrace {
+			if span := SpanFromContext(ctx); span != nil {
+				requestID = span.SpanContext().TraceID.String()
+			}
+		}
 		if requestID == "" {
 			myid := atomic.AddUint64(&reqid, 1)
 			requestID = fmt.Sprintf("%s-%06d", prefix, myid)
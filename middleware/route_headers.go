@@ -33,20 +33,22 @@ import (
 // r := chi.NewRouter()
 //
 // r.Use(middleware.RouteHeaders().
-//   Route("Origin", "https://app.skyweaver.net", cors.Handler(cors.Options{
-// 	   AllowedOrigins:   []string{"https://api.skyweaver.net"},
-// 	   AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+//   Route("Origin", "https://app.skyweaver.net", middleware.CORS(middleware.CORSOptions{
+// 	   AllowedOrigins:   []string{"https://app.skyweaver.net"},
 // 	   AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
 // 	   AllowCredentials: true, // <----------<<< allow credentials
 //   })).
-//   Route("Origin", "*", cors.Handler(cors.Options{
+//   Route("Origin", "*", middleware.CORS(middleware.CORSOptions{
 // 	   AllowedOrigins:   []string{"*"},
-// 	   AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 // 	   AllowedHeaders:   []string{"Accept", "Content-Type"},
 // 	   AllowCredentials: false, // <----------<<< do not allow credentials
 //   })).
 //   Handler)
 //
+// Note that a single CORS middleware already varies response by Origin and
+// answers preflight requests with methods routed for the path, so header
+// routing is only needed for different options per origin.
+//
 func RouteHeaders() HeaderRouter {
 	return HeaderRouter{}
 }
@@ -13,6 +13,7 @@ const (
 	errCapacityExceeded = "Server capacity exceeded."
 	errTimedOut         = "Timed out while waiting for a pending request to complete."
 	errContextCanceled  = "Context was canceled."
+	errLoadShed         = "Request was shed due to overload."
 )
 
 var (
@@ -25,6 +26,37 @@ type ThrottleOpts struct {
 	Limit          int
 	BacklogLimit   int
 	BacklogTimeout time.Duration
+
+	// Adaptive enables resizing of the limit at runtime (AIMD): the limit
+	// starts at Limit, grows by one after a Limit of requests that complete
+	// within TargetLatency and is multiplied by Backoff on every slower request.
+	Adaptive bool
+
+	// MinLimit and MaxLimit bound adaptive limit, default 1 and 10*Limit.
+	MinLimit int
+	MaxLimit int
+
+	// TargetLatency is a latency of request processing that adaptive limit
+	// aims for, it is required in Adaptive mode.
+	TargetLatency time.Duration
+
+	// Backoff is a multiplier of adaptive limit on slow requests, default 0.9.
+	Backoff float64
+
+	// TargetQueueTime enables CoDel-style load shedding, requests that spent
+	// more time in backlog are dropped instead of being processed.
+	TargetQueueTime time.Duration
+
+	// Classifier prioritizes requests, see PriorityByRoute.
+	// Requests with higher priority leave backlog first, PriorityCritical
+	// requests (e.g. health checks) bypass throttling and are never shed.
+	Classifier func(ctx context.Context, rc *fasthttp.RequestCtx) ThrottlePriority
+
+	// Stats receives live gauges and counters, optional.
+	Stats *ThrottleStats
+
+	// now is used in tests.
+	now func() time.Time
 }
 
 // Throttle is a middleware that limits number of currently processed requests
@@ -43,6 +75,9 @@ func ThrottleBacklog(limit int, backlogLimit int, backlogTimeout time.Duration)
 }
 
 // ThrottleWithOpts is a middleware that limits number of currently processed requests using passed ThrottleOpts.
+//
+// Backlog is ordered by priority of requests if ThrottleOpts.Classifier is set,
+// limit is adjusted to observed latency if ThrottleOpts.Adaptive is enabled.
 func ThrottleWithOpts(opts ThrottleOpts) func(fchi.Handler) fchi.Handler {
 	if opts.Limit < 1 {
 		panic("chi/middleware: Throttle expects limit > 0")
@@ -52,6 +87,10 @@ func ThrottleWithOpts(opts ThrottleOpts) func(fchi.Handler) fchi.Handler {
 		panic("chi/middleware: Throttle expects backlogLimit to be positive")
 	}
 
+	if opts.Adaptive || opts.TargetQueueTime > 0 || opts.Classifier != nil || opts.Stats != nil {
+		return newAdaptiveThrottler(opts).middleware
+	}
+
 	t := throttler{
 		tokens:         make(chan token, opts.Limit),
 		backlogTokens:  make(chan token, opts.Limit+opts.BacklogLimit),
@@ -72,8 +111,7 @@ func ThrottleWithOpts(opts ThrottleOpts) func(fchi.Handler) fchi.Handler {
 			select {
 
 			case <-ctx.Done():
-				t.setRetryAfterHeaderIfNeeded(rc, true)
-				rc.Error(errContextCanceled, fasthttp.StatusTooManyRequests)
+				throttleReject(rc, t.retryAfterFn, errContextCanceled, true)
 				return
 
 			case btok := <-t.backlogTokens:
@@ -85,13 +123,11 @@ func ThrottleWithOpts(opts ThrottleOpts) func(fchi.Handler) fchi.Handler {
 
 				select {
 				case <-timer.C:
-					t.setRetryAfterHeaderIfNeeded(rc, false)
-					rc.Error(errTimedOut, fasthttp.StatusTooManyRequests)
+					throttleReject(rc, t.retryAfterFn, errTimedOut, false)
 					return
 				case <-ctx.Done():
 					timer.Stop()
-					t.setRetryAfterHeaderIfNeeded(rc, true)
-					rc.Error(errContextCanceled, fasthttp.StatusTooManyRequests)
+					throttleReject(rc, t.retryAfterFn, errContextCanceled, true)
 					return
 				case tok := <-t.tokens:
 					defer func() {
@@ -103,8 +139,7 @@ func ThrottleWithOpts(opts ThrottleOpts) func(fchi.Handler) fchi.Handler {
 				return
 
 			default:
-				t.setRetryAfterHeaderIfNeeded(rc, false)
-				rc.Error(errCapacityExceeded, fasthttp.StatusTooManyRequests)
+				throttleReject(rc, t.retryAfterFn, errCapacityExceeded, false)
 				return
 			}
 		}
@@ -124,10 +159,13 @@ type throttler struct {
 	backlogTimeout time.Duration
 }
 
-// setRetryAfterHeaderIfNeeded sets Retry-After HTTP header if corresponding retryAfterFn option of throttler is initialized.
-func (t throttler) setRetryAfterHeaderIfNeeded(rc *fasthttp.RequestCtx, ctxDone bool) {
-	if t.retryAfterFn == nil {
+// throttleReject responds with 429 Too Many Requests and sets Retry-After HTTP header if retryAfterFn is initialized.
+func throttleReject(rc *fasthttp.RequestCtx, retryAfterFn func(ctxDone bool) time.Duration, msg string, ctxDone bool) {
+	// Error resets response headers, so it goes first.
+	rc.Error(msg, fasthttp.StatusTooManyRequests)
+
+	if retryAfterFn == nil {
 		return
 	}
-	rc.Response.Header.Set("Retry-After", strconv.Itoa(int(t.retryAfterFn(ctxDone).Seconds())))
+	rc.Response.Header.Set("Retry-After", strconv.Itoa(int(retryAfterFn(ctxDone).Seconds())))
 }
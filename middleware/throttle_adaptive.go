@@ -0,0 +1,338 @@
+package middleware
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// ThrottlePriority defines the order of requests in Throttle backlog.
+type ThrottlePriority int
+
+// Request priorities, zero value is PriorityNormal.
+const (
+	PriorityLow ThrottlePriority = iota - 1
+	PriorityNormal
+	PriorityHigh
+
+	// PriorityCritical requests bypass throttling, they are not counted as in-flight.
+	PriorityCritical
+)
+
+type throttlePriorityMetaKey struct{}
+
+// WithThrottlePriority sets a priority of the route in route metadata, it is
+// used by PriorityByRoute classifier.
+//
+//   r.Get("/health", middleware.WithThrottlePriority(healthHandler, middleware.PriorityCritical))
+func WithThrottlePriority(h fchi.Handler, p ThrottlePriority) fchi.Handler {
+	return fchi.WithMeta(h, throttlePriorityMetaKey{}, p)
+}
+
+// PriorityByRoute is a ThrottleOpts.Classifier that reads priority from
+// route metadata, routes without priority have PriorityNormal.
+func PriorityByRoute(_ context.Context, rc *fasthttp.RequestCtx) ThrottlePriority {
+	if p, ok := fchi.RouteMeta(rc, throttlePriorityMetaKey{}); ok {
+		return p.(ThrottlePriority)
+	}
+
+	return PriorityNormal
+}
+
+// ThrottleStats exposes live state of ThrottleWithOpts.
+type ThrottleStats struct {
+	inFlight int64
+	backlog  int64
+	shed     int64
+	limit    int64
+}
+
+// InFlight returns number of requests being processed.
+func (s *ThrottleStats) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// Backlog returns number of requests waiting in backlog.
+func (s *ThrottleStats) Backlog() int64 {
+	return atomic.LoadInt64(&s.backlog)
+}
+
+// Shed returns total number of requests that were dropped with 429 Too Many Requests.
+func (s *ThrottleStats) Shed() int64 {
+	return atomic.LoadInt64(&s.shed)
+}
+
+// Limit returns current limit of in-flight requests.
+func (s *ThrottleStats) Limit() int64 {
+	return atomic.LoadInt64(&s.limit)
+}
+
+// adaptiveThrottler limits number of currently processed requests with a
+// resizable limit and a priority backlog.
+type adaptiveThrottler struct {
+	opts  ThrottleOpts
+	stats *ThrottleStats
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	seq      uint64
+	backlog  throttleBacklog
+}
+
+func newAdaptiveThrottler(opts ThrottleOpts) *adaptiveThrottler {
+	if opts.Adaptive {
+		if opts.TargetLatency <= 0 {
+			panic("chi/middleware: adaptive Throttle expects targetLatency > 0")
+		}
+
+		if opts.MinLimit < 1 {
+			opts.MinLimit = 1
+		}
+
+		if opts.MaxLimit == 0 {
+			opts.MaxLimit = 10 * opts.Limit
+		}
+
+		if opts.MinLimit > opts.Limit || opts.MaxLimit < opts.Limit {
+			panic("chi/middleware: adaptive Throttle expects minLimit <= limit <= maxLimit")
+		}
+
+		if opts.Backoff <= 0 || opts.Backoff >= 1 {
+			opts.Backoff = 0.9
+		}
+	}
+
+	if opts.Stats == nil {
+		opts.Stats = &ThrottleStats{}
+	}
+
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
+	t := &adaptiveThrottler{
+		opts:  opts,
+		stats: opts.Stats,
+		limit: float64(opts.Limit),
+	}
+
+	t.syncStats()
+
+	return t
+}
+
+func (t *adaptiveThrottler) middleware(next fchi.Handler) fchi.Handler {
+	fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		p := PriorityNormal
+		if t.opts.Classifier != nil {
+			p = t.opts.Classifier(ctx, rc)
+		}
+
+		if p >= PriorityCritical {
+			next.ServeHTTP(ctx, rc)
+
+			return
+		}
+
+		if msg, ctxDone := t.acquire(ctx, p); msg != "" {
+			atomic.AddInt64(&t.stats.shed, 1)
+			throttleReject(rc, t.opts.RetryAfterFn, msg, ctxDone)
+
+			return
+		}
+
+		start := t.opts.now()
+
+		defer func() {
+			t.release(t.opts.now().Sub(start))
+		}()
+
+		next.ServeHTTP(ctx, rc)
+	}
+
+	return fchi.HandlerFunc(fn)
+}
+
+// acquire waits for a slot to process request, it returns error message if request is rejected.
+func (t *adaptiveThrottler) acquire(ctx context.Context, p ThrottlePriority) (msg string, ctxDone bool) {
+	t.mu.Lock()
+
+	if t.backlog.Len() == 0 && t.inFlight < int(t.limit) {
+		t.inFlight++
+		t.syncStats()
+		t.mu.Unlock()
+
+		return "", false
+	}
+
+	if t.backlog.Len() >= t.opts.BacklogLimit {
+		// Evicting a waiter with lower priority to free a place in backlog.
+		lowest := t.backlog.lowest()
+		if lowest == nil || lowest.priority >= p {
+			t.mu.Unlock()
+
+			return errCapacityExceeded, false
+		}
+
+		heap.Remove(&t.backlog, lowest.index)
+		lowest.ready <- errLoadShed
+	}
+
+	t.seq++
+	w := &throttleWaiter{
+		priority: p,
+		seq:      t.seq,
+		enqueued: t.opts.now(),
+		ready:    make(chan string, 1),
+	}
+
+	heap.Push(&t.backlog, w)
+	t.syncStats()
+	t.mu.Unlock()
+
+	timer := time.NewTimer(t.opts.BacklogTimeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-w.ready:
+		return msg, false
+	case <-timer.C:
+		msg, ctxDone = errTimedOut, false
+	case <-ctx.Done():
+		msg, ctxDone = errContextCanceled, true
+	}
+
+	if t.cancel(w) {
+		return msg, ctxDone
+	}
+
+	// Waiter has left backlog concurrently.
+	return <-w.ready, false
+}
+
+// cancel removes waiter from backlog, it returns false if waiter has already left backlog.
+func (t *adaptiveThrottler) cancel(w *throttleWaiter) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if w.index < 0 {
+		return false
+	}
+
+	heap.Remove(&t.backlog, w.index)
+	t.syncStats()
+
+	return true
+}
+
+// release frees a slot of processed request and passes free slots to waiters in backlog.
+func (t *adaptiveThrottler) release(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inFlight--
+
+	if t.opts.Adaptive {
+		if latency > t.opts.TargetLatency {
+			t.limit *= t.opts.Backoff
+			if t.limit < float64(t.opts.MinLimit) {
+				t.limit = float64(t.opts.MinLimit)
+			}
+		} else {
+			t.limit += 1 / t.limit
+			if t.limit > float64(t.opts.MaxLimit) {
+				t.limit = float64(t.opts.MaxLimit)
+			}
+		}
+	}
+
+	now := t.opts.now()
+
+	for t.backlog.Len() > 0 && t.inFlight < int(t.limit) {
+		w := heap.Pop(&t.backlog).(*throttleWaiter)
+
+		if t.opts.TargetQueueTime > 0 && now.Sub(w.enqueued) > t.opts.TargetQueueTime {
+			w.ready <- errLoadShed
+
+			continue
+		}
+
+		t.inFlight++
+		w.ready <- ""
+	}
+
+	t.syncStats()
+}
+
+// syncStats updates gauges, it must be called with mutex locked.
+func (t *adaptiveThrottler) syncStats() {
+	atomic.StoreInt64(&t.stats.inFlight, int64(t.inFlight))
+	atomic.StoreInt64(&t.stats.backlog, int64(t.backlog.Len()))
+	atomic.StoreInt64(&t.stats.limit, int64(t.limit))
+}
+
+// throttleWaiter is a request waiting in backlog.
+type throttleWaiter struct {
+	priority ThrottlePriority
+	seq      uint64
+	enqueued time.Time
+	index    int
+
+	// ready receives empty message when request can be processed or error message if it is shed.
+	ready chan string
+}
+
+// throttleBacklog is a heap of waiters ordered by priority and arrival.
+type throttleBacklog []*throttleWaiter
+
+func (b throttleBacklog) Len() int { return len(b) }
+
+func (b throttleBacklog) Less(i, j int) bool {
+	if b[i].priority != b[j].priority {
+		return b[i].priority > b[j].priority
+	}
+
+	return b[i].seq < b[j].seq
+}
+
+func (b throttleBacklog) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+	b[i].index = i
+	b[j].index = j
+}
+
+func (b *throttleBacklog) Push(x interface{}) {
+	w := x.(*throttleWaiter)
+	w.index = len(*b)
+	*b = append(*b, w)
+}
+
+func (b *throttleBacklog) Pop() interface{} {
+	old := *b
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*b = old[:n-1]
+
+	return w
+}
+
+// lowest returns the waiter that would leave backlog last.
+func (b throttleBacklog) lowest() *throttleWaiter {
+	var l *throttleWaiter
+
+	for _, w := range b {
+		if l == nil || w.priority < l.priority || (w.priority == l.priority && w.seq > l.seq) {
+			l = w
+		}
+	}
+
+	return l
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestThrottleWithOpts_adaptive(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	latency := time.Duration(0)
+	stats := &ThrottleStats{}
+
+	r := fchi.NewRouter()
+	r.Use(ThrottleWithOpts(ThrottleOpts{
+		Limit:         2,
+		MaxLimit:      4,
+		Adaptive:      true,
+		TargetLatency: 100 * time.Millisecond,
+		Stats:         stats,
+		now:           func() time.Time { return now },
+	}))
+	r.Get("/", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		now = now.Add(latency)
+	}))
+
+	latency = 200 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		testHandler(t, r, "GET", "/")
+	}
+
+	if stats.Limit() != 1 {
+		t.Fatalf("limit expected to decrease on slow requests, %d", stats.Limit())
+	}
+
+	latency = 50 * time.Millisecond
+
+	for i := 0; i < 100; i++ {
+		testHandler(t, r, "GET", "/")
+	}
+
+	if stats.Limit() != 4 || stats.InFlight() != 0 {
+		t.Fatalf("limit expected to grow to max on fast requests, %d", stats.Limit())
+	}
+}
+
+func TestThrottleWithOpts_priority(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		now     = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		order   []string
+		unblock = make(chan struct{})
+		stats   = &ThrottleStats{}
+	)
+
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return now
+	}
+
+	h := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		mu.Lock()
+		order = append(order, string(rc.Path()))
+		mu.Unlock()
+
+		if string(rc.Path()) == "/block" {
+			<-unblock
+		}
+	})
+
+	r := fchi.NewRouter()
+	r.Use(ThrottleWithOpts(ThrottleOpts{
+		Limit:           1,
+		BacklogLimit:    2,
+		BacklogTimeout:  10 * time.Second,
+		TargetQueueTime: time.Second,
+		Classifier:      PriorityByRoute,
+		Stats:           stats,
+		now:             clock,
+	}))
+	r.Get("/block", h)
+	r.Get("/low", WithThrottlePriority(h, PriorityLow))
+	r.Get("/normal", h)
+	r.Get("/high", WithThrottlePriority(h, PriorityHigh))
+	r.Get("/health", WithThrottlePriority(h, PriorityCritical))
+
+	var wg sync.WaitGroup
+
+	statuses := make(map[string]int)
+	request := func(path string, cond func() bool) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			status := testHandler(t, r, "GET", path)
+
+			mu.Lock()
+			statuses[path] = status
+			mu.Unlock()
+		}()
+
+		waitFor(t, cond)
+	}
+
+	request("/block", func() bool { return stats.InFlight() == 1 })
+	request("/low", func() bool { return stats.Backlog() == 1 })
+	request("/high", func() bool { return stats.Backlog() == 2 })
+	request("/normal", func() bool { return stats.Shed() == 1 }) // Evicts "/low".
+
+	if status := testHandler(t, r, "GET", "/health"); status != fasthttp.StatusOK {
+		t.Fatalf("critical request expected to bypass throttling, %d", status)
+	}
+
+	close(unblock)
+	wg.Wait()
+
+	if statuses["/low"] != fasthttp.StatusTooManyRequests || statuses["/high"] != 200 || statuses["/normal"] != 200 {
+		t.Fatalf("unexpected statuses: %v", statuses)
+	}
+
+	if got := order; len(got) != 4 || got[1] != "/health" || got[2] != "/high" || got[3] != "/normal" {
+		t.Fatalf("unexpected order of requests: %v", got)
+	}
+
+	unblock = make(chan struct{})
+
+	request("/block", func() bool { return stats.InFlight() == 1 })
+	request("/normal", func() bool { return stats.Backlog() == 1 })
+
+	mu.Lock()
+	now = now.Add(2 * time.Second)
+	mu.Unlock()
+
+	close(unblock)
+	wg.Wait()
+
+	if statuses["/normal"] != fasthttp.StatusTooManyRequests || stats.Shed() != 2 || stats.InFlight() != 0 {
+		t.Fatalf("request expected to be shed after target queue time, %d, %d shed", statuses["/normal"], stats.Shed())
+	}
+}
+
+func testHandler(t *testing.T, h fchi.Handler, method, path string) int {
+	t.Helper()
+
+	rc := &fasthttp.RequestCtx{}
+	rc.Request.Header.SetMethod(method)
+	rc.Request.SetRequestURI(path)
+	h.ServeHTTP(context.Background(), rc)
+
+	return rc.Response.StatusCode()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("condition was not met in time")
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/swaggest/fchi"
@@ -13,7 +14,8 @@ import (
 //
 // It's required that you select the ctx.Done() channel to check for the signal
 // if the context has reached its deadline and return, otherwise the timeout
-// signal will be just ignored.
+// signal will be just ignored. Use TimeoutWithOpts to respond at deadline
+// regardless of the handler.
 //
 // ie. a route/handler may look like:
 //
@@ -48,3 +50,202 @@ func Timeout(timeout time.Duration) func(next fchi.Handler) fchi.Handler {
 		return fchi.HandlerFunc(fn)
 	}
 }
+
+// TimeoutOpts configures TimeoutWithOpts.
+type TimeoutOpts struct {
+	// Timeout is applied to routes that have no timeout in route metadata,
+	// zero value disables timeout for such routes.
+	Timeout time.Duration
+
+	// StatusCode of response at deadline, default 504 Gateway Timeout.
+	StatusCode int
+
+	// Message is a body of response at deadline, default is status message.
+	Message string
+
+	// Stats receives counters of timed out handlers, optional.
+	Stats *TimeoutStats
+}
+
+// TimeoutStats counts handlers that were timed out by TimeoutWithOpts.
+type TimeoutStats struct {
+	timedOut int64
+	running  int64
+}
+
+// TimedOut returns total number of timed out requests.
+func (s *TimeoutStats) TimedOut() int64 {
+	return atomic.LoadInt64(&s.timedOut)
+}
+
+// Running returns number of timed out handlers that are still running.
+func (s *TimeoutStats) Running() int64 {
+	return atomic.LoadInt64(&s.running)
+}
+
+type timeoutMetaKey struct{}
+
+// States of a handler executed by TimeoutWithOpts.
+const (
+	timeoutRunning int32 = iota
+	timeoutDone
+	timeoutExpired
+)
+
+// WithRouteTimeout sets a timeout of the route in route metadata, it is used
+// by TimeoutWithOpts instead of the default timeout.
+//
+//   r.Post("/report", middleware.WithRouteTimeout(reportHandler, time.Minute))
+func WithRouteTimeout(h fchi.Handler, timeout time.Duration) fchi.Handler {
+	return fchi.WithMeta(h, timeoutMetaKey{}, timeout)
+}
+
+// TimeoutWithOpts is a middleware that responds with 504 Gateway Timeout
+// when the handler has not finished by the deadline.
+//
+// Unlike Timeout, the handler is executed in a separate goroutine, so that
+// a handler that ignores ctx.Done() can not hold the response. Response of
+// such handler is discarded: the response at deadline is sent with
+// rc.TimeoutErrorWithCode and fasthttp does not reuse the RequestCtx while the
+// handler is running, routing context is not put back to the pool as well.
+//
+// Panic of the handler is raised again in the goroutine of the request, so that
+// it is handled by Recoverer that wraps this middleware. Panic of a handler that
+// is already timed out is discarded. Cancellation of parent context, e.g. on
+// server shutdown, is passed to the handler without timeout response.
+//
+// Handler must not be used with fasthttp.RequestCtx after it has returned,
+// e.g. in middlewares that wrap this one, if the request is timed out.
+func TimeoutWithOpts(opts TimeoutOpts) func(next fchi.Handler) fchi.Handler {
+	if opts.StatusCode == 0 {
+		opts.StatusCode = fasthttp.StatusGatewayTimeout
+	}
+
+	if opts.Message == "" {
+		opts.Message = fasthttp.StatusMessage(opts.StatusCode)
+	}
+
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			timeout := opts.Timeout
+			if v, ok := fchi.RouteMeta(rc, timeoutMetaKey{}); ok {
+				timeout = v.(time.Duration)
+			}
+
+			if timeout <= 0 {
+				next.ServeHTTP(ctx, rc)
+
+				return
+			}
+
+			ctx, cancel := timeoutContext(ctx, timeout)
+			done := make(chan struct{})
+			state := timeoutRunning
+
+			var rvr interface{}
+
+			// finish waits for the handler and raises its panic.
+			finish := func() {
+				<-done
+
+				if rvr != nil {
+					panic(rvr)
+				}
+			}
+
+			go func() {
+				defer func() {
+					rvr = recover()
+
+					cancel()
+
+					if !atomic.CompareAndSwapInt32(&state, timeoutRunning, timeoutDone) && opts.Stats != nil {
+						atomic.AddInt64(&opts.Stats.running, -1)
+					}
+
+					close(done)
+				}()
+
+				next.ServeHTTP(ctx, rc)
+			}()
+
+			select {
+			case <-done:
+				finish()
+
+				return
+			case <-ctx.Done():
+			}
+
+			if ctx.Err() != context.DeadlineExceeded {
+				// Parent context is canceled, handler is expected to return.
+				finish()
+
+				return
+			}
+
+			if opts.Stats != nil {
+				atomic.AddInt64(&opts.Stats.running, 1)
+			}
+
+			// Handler could have finished concurrently with the deadline.
+			if !atomic.CompareAndSwapInt32(&state, timeoutRunning, timeoutExpired) {
+				if opts.Stats != nil {
+					atomic.AddInt64(&opts.Stats.running, -1)
+				}
+
+				finish()
+
+				return
+			}
+
+			if opts.Stats != nil {
+				atomic.AddInt64(&opts.Stats.timedOut, 1)
+			}
+
+			if rctx := fchi.RouteContext(rc); rctx != nil {
+				rctx.KeepAlive()
+			}
+
+			rc.TimeoutErrorWithCode(opts.Message, opts.StatusCode)
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+// timeoutContext returns a context with timeout that is canceled with parent.
+//
+// Done channel of parent is taken once in the goroutine of the request:
+// fasthttp.RequestCtx.Done races with fasthttp.Server.Shutdown when it is
+// called later, e.g. by context.WithTimeout while the timed out handler runs.
+func timeoutContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	parentDone := parent.Done()
+
+	ctx, cancel := context.WithTimeout(detachedContext{parent}, timeout)
+
+	if parentDone != nil {
+		go func() {
+			select {
+			case <-parentDone:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return ctx, cancel
+}
+
+// detachedContext keeps values and deadline of parent context without its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestTimeoutWithOpts(t *testing.T) {
+	stats := &TimeoutStats{}
+
+	slow := fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		time.Sleep(200 * time.Millisecond) // Ignores ctx.Done().
+		rc.SetStatusCode(http.StatusCreated)
+		rc.WriteString(fchi.URLParam(rc, "id"))
+	})
+
+	r := fchi.NewRouter()
+	r.Use(TimeoutWithOpts(TimeoutOpts{Timeout: 50 * time.Millisecond, Stats: stats}))
+	r.Get("/slow/{id}", slow)
+	r.Get("/long/{id}", WithRouteTimeout(slow, time.Second))
+
+	ts := fchi.NewTestServer(r)
+	defer ts.Close()
+
+	start := time.Now()
+
+	resp, err := http.Get(ts.URL + "/slow/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGatewayTimeout || string(body) != "Gateway Timeout" {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, body)
+	}
+
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("response at deadline expected, got %s", elapsed)
+	}
+
+	if stats.TimedOut() != 1 || stats.Running() != 1 {
+		t.Fatalf("unexpected stats: %d timed out, %d running", stats.TimedOut(), stats.Running())
+	}
+
+	resp, err = http.Get(ts.URL + "/long/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated || string(body) != "2" {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, body)
+	}
+
+	if stats.TimedOut() != 1 || stats.Running() != 0 {
+		t.Fatalf("unexpected stats: %d timed out, %d running", stats.TimedOut(), stats.Running())
+	}
+}
+
+func TestTimeoutWithOpts_panic(t *testing.T) {
+	var buf bytes.Buffer
+
+	r := fchi.NewRouter()
+	r.Use(RecovererWithOptions(RecovererOptions{Output: &buf, JSONStack: true}))
+	r.Use(TimeoutWithOpts(TimeoutOpts{Timeout: time.Second}))
+	r.Get("/", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		panic("oops")
+	}))
+
+	rc := &fasthttp.RequestCtx{}
+	rc.Request.SetRequestURI("/")
+	r.ServeHTTP(context.Background(), rc)
+
+	if rc.Response.StatusCode() != http.StatusInternalServerError || !strings.Contains(buf.String(), "oops") {
+		t.Fatalf("recovered panic expected: %d %s", rc.Response.StatusCode(), buf.String())
+	}
+}
+
+func TestTimeoutWithOpts_canceled(t *testing.T) {
+	h := TimeoutWithOpts(TimeoutOpts{Timeout: time.Second})(fchi.HandlerFunc(
+		func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			<-ctx.Done()
+			rc.WriteString(ctx.Err().Error())
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	rc := &fasthttp.RequestCtx{}
+	h.ServeHTTP(ctx, rc)
+
+	if rc.Response.StatusCode() != http.StatusOK || string(rc.Response.Body()) != context.Canceled.Error() {
+		t.Fatalf("unexpected response: %d %s", rc.Response.StatusCode(), rc.Response.Body())
+	}
+}
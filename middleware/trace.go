@@ -0,0 +1,383 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// W3C Trace Context headers.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// TraceID is a 16 bytes identifier of a trace.
+type TraceID [16]byte
+
+// String returns lowercase hex representation of trace ID.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID is an 8 bytes identifier of a span.
+type SpanID [8]byte
+
+// String returns lowercase hex representation of span ID.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span and carries the state propagated with W3C Trace Context.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid checks if trace and span IDs are not zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent formats span context as a value of traceparent header.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ErrInvalidTraceParent is returned by ParseTraceParent.
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+// ParseTraceParent parses a value of traceparent header.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	// Future versions may append fields, version 00 has exact length.
+	if len(s) < 55 || (len(s) > 55 && (s[:2] == "00" || s[55] != '-')) {
+		return sc, ErrInvalidTraceParent
+	}
+
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' || s[:2] == "ff" {
+		return sc, ErrInvalidTraceParent
+	}
+
+	var version, flags [1]byte
+
+	if !decodeLowerHex(version[:], s[:2]) || !decodeLowerHex(sc.TraceID[:], s[3:35]) ||
+		!decodeLowerHex(sc.SpanID[:], s[36:52]) || !decodeLowerHex(flags[:], s[53:55]) {
+		return sc, ErrInvalidTraceParent
+	}
+
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceParent
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, nil
+}
+
+func decodeLowerHex(dst []byte, s string) bool {
+	if strings.ToLower(s) != s {
+		return false
+	}
+
+	_, err := hex.Decode(dst, []byte(s))
+
+	return err == nil
+}
+
+// Span is a unit of work of a trace.
+type Span interface {
+	SpanContext() SpanContext
+	SetName(name string)
+	SetAttribute(key string, value interface{})
+	SetStatus(code int)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans, implement it to send spans to a tracing backend.
+type Tracer interface {
+	// StartSpan starts a span with given identity, parent is zero for root spans.
+	StartSpan(ctx context.Context, name string, sc SpanContext, parent SpanContext) Span
+}
+
+type ctxKeySpan struct{}
+
+// ContextWithSpan returns a context that carries the span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, ctxKeySpan{}, span)
+}
+
+// SpanFromContext returns a span started by Trace middleware, or nil.
+func SpanFromContext(ctx context.Context) Span {
+	if ctx == nil {
+		return nil
+	}
+
+	span, _ := ctx.Value(ctxKeySpan{}).(Span)
+
+	return span
+}
+
+// InjectTraceContext sets traceparent and tracestate headers of an outbound
+// request, so that the span from context becomes a parent of remote spans.
+func InjectTraceContext(ctx context.Context, h *fasthttp.RequestHeader) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	sc := span.SpanContext()
+
+	h.Set(TraceParentHeader, sc.TraceParent())
+
+	if sc.TraceState != "" {
+		h.Set(TraceStateHeader, sc.TraceState)
+	} else {
+		h.Del(TraceStateHeader)
+	}
+}
+
+var errPanicked = errors.New("handler panicked")
+
+// Trace is a middleware that starts a server span of each request with
+// tracer and puts it in the context passed down.
+//
+// Parent span is read from W3C Trace Context headers, requests without valid
+// traceparent start a new sampled trace. Span is named "METHOD /route/{pattern}"
+// after routing and has status code and error of the request, Trace should be
+// added with Use of a router to observe them.
+//
+// Trace should be added before Recoverer, so that the span has recovered panic
+// as error, otherwise the span of panicked request is ended without details.
+//
+// Not sampled spans are not passed to tracer, but they are still available in
+// context for propagation.
+func Trace(tracer Tracer) func(next fchi.Handler) fchi.Handler {
+	return func(next fchi.Handler) fchi.Handler {
+		fn := func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			sc := SpanContext{Sampled: true}
+
+			parent, err := ParseTraceParent(string(rc.Request.Header.Peek(TraceParentHeader)))
+			if err == nil {
+				sc.TraceID = parent.TraceID
+				sc.Sampled = parent.Sampled
+				sc.TraceState = strings.TrimSpace(string(rc.Request.Header.Peek(TraceStateHeader)))
+				parent.TraceState = sc.TraceState
+			} else {
+				parent = SpanContext{}
+				sc.TraceID = newTraceID()
+			}
+
+			sc.SpanID = newSpanID()
+
+			method := string(rc.Method())
+			name := method + " " + string(rc.Path())
+
+			var span Span
+			if sc.Sampled {
+				span = tracer.StartSpan(ctx, name, sc, parent)
+			} else {
+				span = nonRecordingSpan{sc: sc}
+			}
+
+			panicked := true
+
+			defer func() {
+				if panicked {
+					// Panic is not recovered to keep the stack for Recoverer.
+					span.RecordError(errPanicked)
+					span.SetStatus(fasthttp.StatusInternalServerError)
+					span.End()
+
+					return
+				}
+
+				status := rc.Response.StatusCode()
+
+				if rctx := fchi.RouteContext(rc); rctx != nil {
+					if pattern := rctx.RoutePattern(); pattern != "" {
+						span.SetName(method + " " + pattern)
+						span.SetAttribute("http.route", pattern)
+					}
+
+					if err := fchi.RequestError(rc); err != nil {
+						span.RecordError(err)
+					}
+				}
+
+				span.SetAttribute("http.status_code", status)
+				span.SetStatus(status)
+				span.End()
+			}()
+
+			span.SetAttribute("http.method", method)
+
+			next.ServeHTTP(ContextWithSpan(ctx, span), rc)
+
+			panicked = false
+		}
+
+		return fchi.HandlerFunc(fn)
+	}
+}
+
+type nonRecordingSpan struct {
+	sc SpanContext
+}
+
+func (s nonRecordingSpan) SpanContext() SpanContext       { return s.sc }
+func (nonRecordingSpan) SetName(string)                   {}
+func (nonRecordingSpan) SetAttribute(string, interface{}) {}
+func (nonRecordingSpan) SetStatus(int)                    {}
+func (nonRecordingSpan) RecordError(error)                {}
+func (nonRecordingSpan) End()                             {}
+
+var idGen = struct {
+	sync.Mutex
+	*mrand.Rand
+}{Rand: mrand.New(mrand.NewSource(seed()))}
+
+func seed() int64 {
+	var b [8]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+
+	idGen.Lock()
+	defer idGen.Unlock()
+
+	for id == (TraceID{}) {
+		_, _ = idGen.Read(id[:])
+	}
+
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+
+	idGen.Lock()
+	defer idGen.Unlock()
+
+	for id == (SpanID{}) {
+		_, _ = idGen.Read(id[:])
+	}
+
+	return id
+}
+
+// TraceRecorder is an in-memory Tracer, useful in tests.
+type TraceRecorder struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+var _ Tracer = &TraceRecorder{}
+
+// RecordedSpan is a span ended with TraceRecorder.
+type RecordedSpan struct {
+	Name        string
+	SpanContext SpanContext
+	Parent      SpanContext
+	Attributes  map[string]interface{}
+	Status      int
+	Errors      []error
+	Start, End  time.Time
+}
+
+// StartSpan starts a span.
+func (r *TraceRecorder) StartSpan(_ context.Context, name string, sc SpanContext, parent SpanContext) Span {
+	return &recorderSpan{
+		recorder: r,
+		span: RecordedSpan{
+			Name:        name,
+			SpanContext: sc,
+			Parent:      parent,
+			Attributes:  make(map[string]interface{}),
+			Start:       time.Now(),
+		},
+	}
+}
+
+// Spans returns ended spans.
+func (r *TraceRecorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+type recorderSpan struct {
+	recorder *TraceRecorder
+
+	mu   sync.Mutex
+	span RecordedSpan
+}
+
+func (s *recorderSpan) SpanContext() SpanContext {
+	return s.span.SpanContext
+}
+
+func (s *recorderSpan) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.span.Name = name
+}
+
+func (s *recorderSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.span.Attributes[key] = value
+}
+
+func (s *recorderSpan) SetStatus(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.span.Status = code
+}
+
+func (s *recorderSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.span.Errors = append(s.span.Errors, err)
+}
+
+func (s *recorderSpan) End() {
+	s.mu.Lock()
+	s.span.End = time.Now()
+	span := s.span
+	s.mu.Unlock()
+
+	s.recorder.mu.Lock()
+	s.recorder.spans = append(s.recorder.spans, span)
+	s.recorder.mu.Unlock()
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestTrace(t *testing.T) {
+	defer func(v bool) { RequestIDFromTrace = v }(RequestIDFromTrace)
+
+	RequestIDFromTrace = true
+	tracer := &TraceRecorder{}
+
+	var outbound fasthttp.RequestHeader
+
+	r := fchi.NewRouter()
+	r.Use(Trace(tracer), RequestID)
+	r.Get("/users/{id}", fchi.ErrHandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) error {
+		InjectTraceContext(ctx, &outbound)
+		rc.WriteString(GetReqID(ctx))
+
+		if fchi.URLParam(rc, "id") == "0" {
+			return &fchi.Problem{Status: fasthttp.StatusNotFound, Detail: "user not found"}
+		}
+
+		return nil
+	}))
+
+	request := func(path, traceparent string) *fasthttp.RequestCtx {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.SetRequestURI(path)
+		rc.Request.Header.Set(TraceParentHeader, traceparent)
+		rc.Request.Header.Set(TraceStateHeader, "vendor=value")
+		r.ServeHTTP(context.Background(), rc)
+
+		return rc
+	}
+
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	rc := request("/users/1", parent)
+
+	spans := tracer.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("one span expected, %d", len(spans))
+	}
+
+	s := spans[0]
+	if s.Name != "GET /users/{id}" || s.Status != 200 || s.Parent.SpanID.String() != "b7ad6b7169203331" ||
+		s.SpanContext.TraceID.String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("unexpected span: %+v", s)
+	}
+
+	if string(rc.Response.Body()) != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("request ID expected from trace ID, %s", rc.Response.Body())
+	}
+
+	if tp := string(outbound.Peek(TraceParentHeader)); tp != s.SpanContext.TraceParent() ||
+		string(outbound.Peek(TraceStateHeader)) != "vendor=value" {
+		t.Fatalf("unexpected outbound trace context: %s", tp)
+	}
+
+	request("/users/0", "00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01")
+
+	s = tracer.Spans()[1]
+	if s.Parent.IsValid() || s.SpanContext.TraceID.String() == "0af7651916cd43dd8448eb211c80319c" ||
+		s.Status != 404 || len(s.Errors) != 1 || !errors.As(s.Errors[0], new(*fchi.Problem)) {
+		t.Fatalf("new trace with error expected: %+v", s)
+	}
+
+	rc = request("/users/1", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+
+	if len(tracer.Spans()) != 2 || string(rc.Response.Body()) != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatal("not sampled span should be propagated without recording")
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		valid bool
+	}{
+		{value: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", valid: true},
+		{value: "01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-future", valid: true},
+		{value: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra"},
+		{value: "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		{value: "00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01"},
+		{value: "00-00000000000000000000000000000000-b7ad6b7169203331-01"},
+		{value: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331"},
+	} {
+		sc, err := ParseTraceParent(c.value)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: unexpected error %v", c.value, err)
+		}
+
+		if c.valid && sc.TraceParent()[2:] != c.value[2:55] {
+			t.Errorf("%s: unexpected traceparent %s", c.value, sc.TraceParent())
+		}
+	}
+}
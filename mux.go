@@ -40,6 +40,9 @@ type Mux struct {
 	// Custom route not found handler
 	notFoundHandler Handler
 
+	// Custom renderer of errors handled with HandleError
+	errorRenderer ErrorRenderer
+
 	// The middleware stack
 	middlewares []func(Handler) Handler
 
@@ -69,6 +72,10 @@ func (mx *Mux) ServeHTTP(ctx context.Context, rc *fasthttp.RequestCtx) {
 	// Check if a routing context already exists from a parent router.
 	rctx, _ := rc.UserValue(routeUserValueKey).(*Context)
 	if rctx != nil {
+		if mx.errorRenderer != nil {
+			rctx.errorRenderer = mx.errorRenderer
+		}
+
 		mx.handler.ServeHTTP(ctx, rc)
 		return
 	}
@@ -81,12 +88,15 @@ func (mx *Mux) ServeHTTP(ctx context.Context, rc *fasthttp.RequestCtx) {
 	rctx.Reset()
 	rctx.Routes = mx
 	rctx.parentCtx = ctx
+	rctx.errorRenderer = mx.errorRenderer
 
 	rc.SetUserValue(routeUserValueKey, rctx)
 
 	// Serve the request and once its done, put the request context back in the sync pool
 	mx.handler.ServeHTTP(ctx, rc)
-	mx.pool.Put(rctx)
+	if !rctx.keepAlive {
+		mx.pool.Put(rctx)
+	}
 }
 
 // Use appends a middleware handler to the Mux middleware stack.
@@ -210,6 +220,22 @@ func (mx *Mux) MethodNotAllowed(handler Handler) {
 	})
 }
 
+// RenderErrors sets a custom ErrorRenderer for errors of handlers served
+// by this Mux and its subrouters that don't have own ErrorRenderer.
+// The default renderer is DefaultErrorRenderer.
+//
+// RenderErrors is not a part of Router interface to keep other
+// implementations of Router compatible, a subrouter with own renderer
+// can be made with NewRouter and Mount.
+func (mx *Mux) RenderErrors(renderer ErrorRenderer) {
+	m := mx
+	if mx.inline && mx.parent != nil {
+		m = mx.parent
+	}
+
+	m.errorRenderer = renderer
+}
+
 // With adds inline middlewares for an endpoint handler.
 func (mx *Mux) With(middlewares ...func(Handler) Handler) Router {
 	// Similarly as in handle(), we must build the mux handler once additional
@@ -270,6 +296,9 @@ func (mx *Mux) Mount(pattern string, handler Handler) {
 		panic(fmt.Sprintf("chi: attempting to Mount() a nil handler on '%s'", pattern))
 	}
 
+	// Route metadata is attached to mount routes
+	handler, meta := unwrapMeta(handler)
+
 	// Provide runtime safety for ensuring a pattern isn't mounted on an existing
 	// routing pattern.
 	if mx.tree.findPattern(pattern+"*") || mx.tree.findPattern(pattern+"/*") {
@@ -285,7 +314,7 @@ func (mx *Mux) Mount(pattern string, handler Handler) {
 		subr.MethodNotAllowed(mx.methodNotAllowedHandler)
 	}
 
-	mountHandler := HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+	var mountHandler Handler = HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
 		rctx := RouteContext(rc)
 
 		// shift the url path past the previous subrouter
@@ -300,14 +329,19 @@ func (mx *Mux) Mount(pattern string, handler Handler) {
 		handler.ServeHTTP(ctx, rc)
 	})
 
+	for _, m := range meta {
+		mountHandler = WithMeta(mountHandler, m.key, m.value)
+	}
+
+	subroutes, _ := handler.(Routes)
+
 	if pattern == "" || pattern[len(pattern)-1] != '/' {
-		mx.handle(mALL|mSTUB, pattern, mountHandler)
-		mx.handle(mALL|mSTUB, pattern+"/", mountHandler)
+		mx.handle(mALL|mSTUB, pattern, mountHandler).stubRoutes = subroutes
+		mx.handle(mALL|mSTUB, pattern+"/", mountHandler).stubRoutes = subroutes
 		pattern += "/"
 	}
 
 	method := mALL
-	subroutes, _ := handler.(Routes)
 	if subroutes != nil {
 		method |= mSTUB
 	}
@@ -348,6 +382,11 @@ func (mx *Mux) Match(rctx *Context, method, path string) bool {
 		return node.subroutes.Match(rctx, method, rctx.RoutePath)
 	}
 
+	if node != nil && node.stubRoutes != nil {
+		rctx.RoutePath = mx.nextRoutePath(rctx)
+		return node.stubRoutes.Match(rctx, method, rctx.RoutePath)
+	}
+
 	return h != nil
 }
 
@@ -389,6 +428,9 @@ func (mx *Mux) handle(method methodTyp, pattern string, handler Handler) *node {
 		mx.updateRouteHandler()
 	}
 
+	// Detach route metadata from the handler
+	handler, meta := unwrapMeta(handler)
+
 	// Build endpoint handler with inline middlewares for the route
 	var h Handler
 	if mx.inline {
@@ -399,7 +441,10 @@ func (mx *Mux) handle(method methodTyp, pattern string, handler Handler) *node {
 	}
 
 	// Add the endpoint to the tree and return the node
-	return mx.tree.InsertRoute(method, pattern, h)
+	n := mx.tree.InsertRoute(method, pattern, h)
+	n.setMeta(method, meta)
+
+	return n
 }
 
 // routeHTTP routes a http.Request through the Mux routing tree to serve
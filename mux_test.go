@@ -1679,6 +1679,20 @@ func TestMuxMatch(t *testing.T) {
 	if r.Match(tctx, "HEAD", "/articles/10") == true {
 		t.Fatal("not expecting to find match for route:", "HEAD", "/articles/10")
 	}
+
+	r.Route("/articles/{id}/comments", func(r Router) {
+		r.Get("/", HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {}))
+	})
+
+	tctx.Reset()
+	if r.Match(tctx, "POST", "/articles/10/comments") == true {
+		t.Fatal("not expecting to find match for route:", "POST", "/articles/10/comments")
+	}
+
+	tctx.Reset()
+	if r.Match(tctx, "GET", "/articles/10/comments") == false {
+		t.Fatal("expecting to find match for route:", "GET", "/articles/10/comments")
+	}
 }
 
 func TestServerBaseContext(t *testing.T) {
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balancing is a strategy of choosing an upstream for a request.
+type Balancing int
+
+// Balancing strategies.
+const (
+	// RoundRobin chooses upstreams in turn.
+	RoundRobin Balancing = iota
+
+	// LeastConn chooses upstream with least number of requests in progress.
+	LeastConn
+)
+
+// upstream is a server that serves proxied requests.
+type upstream struct {
+	url      *url.URL
+	inFlight int64 // Accessed atomically.
+
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+// balancer chooses healthy upstreams and tracks their failures.
+type balancer struct {
+	upstreams   []*upstream
+	balancing   Balancing
+	maxFails    int
+	failTimeout time.Duration
+	next        uint64 // Accessed atomically.
+
+	now func() time.Time
+}
+
+// pick returns an upstream that was not tried yet, unhealthy upstreams are
+// only chosen if there are no healthy ones.
+func (b *balancer) pick(tried []*upstream) *upstream {
+	now := b.now()
+	start := atomic.AddUint64(&b.next, 1) - 1
+
+	for _, healthy := range []bool{true, false} {
+		var best *upstream
+
+		for i := range b.upstreams {
+			u := b.upstreams[(start+uint64(i))%uint64(len(b.upstreams))]
+
+			if wasTried(tried, u) || (healthy && !u.healthy(now)) {
+				continue
+			}
+
+			if b.balancing != LeastConn {
+				return u
+			}
+
+			if best == nil || atomic.LoadInt64(&u.inFlight) < atomic.LoadInt64(&best.inFlight) {
+				best = u
+			}
+		}
+
+		if best != nil {
+			return best
+		}
+	}
+
+	return nil
+}
+
+func wasTried(tried []*upstream, u *upstream) bool {
+	for _, t := range tried {
+		if t == u {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u *upstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return !now.Before(u.downUntil)
+}
+
+// report updates passive health state of upstream with request outcome,
+// upstream is skipped for failTimeout after maxFails consecutive failures.
+func (b *balancer) report(u *upstream, failed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !failed {
+		u.fails = 0
+
+		return
+	}
+
+	u.fails++
+
+	if u.fails >= b.maxFails {
+		u.fails = 0
+		u.downUntil = b.now().Add(b.failTimeout)
+	}
+}
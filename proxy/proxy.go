@@ -0,0 +1,611 @@
+// Package proxy provides a reverse proxy handler for fchi routes.
+//
+// Example:
+//  r.Mount("/legacy", proxy.Handler(proxy.Options{
+//  	Upstreams: []string{"http://10.0.0.1:8080/api", "http://10.0.0.2:8080/api"},
+//  	Balancing: proxy.LeastConn,
+//  }))
+//
+// Request to /legacy/users/1 is proxied to /api/users/1 of an upstream.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/swaggest/fchi/middleware"
+	"github.com/valyala/fasthttp"
+)
+
+// Options configures Handler.
+type Options struct {
+	// Upstreams are base URLs of upstream servers, e.g. "http://10.0.0.1:8080/api",
+	// path of request relative to the mount pattern is appended to the base path.
+	Upstreams []string
+
+	// Balancing is RoundRobin by default.
+	Balancing Balancing
+
+	// Transport sends upstream requests, by default it is a transport that
+	// does not use proxy from environment and does not decompress responses.
+	Transport http.RoundTripper
+
+	// Retries is a number of attempts to send idempotent request, e.g. GET
+	// or PUT, to other upstreams after upstream connection failure, default 2,
+	// negative value disables retries. Requests with streamed body are not
+	// retried.
+	Retries int
+
+	// MaxFails is a number of consecutive connection failures after which
+	// upstream is not used for FailTimeout unless all upstreams failed,
+	// default 3.
+	MaxFails int
+
+	// FailTimeout is 10 seconds by default.
+	FailTimeout time.Duration
+
+	// PreserveHost sends Host header of incoming request instead of upstream host.
+	PreserveHost bool
+
+	// Rewrite modifies upstream request before it is sent.
+	Rewrite func(ctx context.Context, req *http.Request)
+
+	now func() time.Time
+}
+
+// Handler returns a handler that proxies requests to upstreams, it is intended
+// to be used with Mux.Mount.
+//
+// X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto and Forwarded headers
+// are added to upstream request together with trace context of
+// middleware.Trace. Bodies are streamed with fasthttp.Server.StreamRequestBody
+// enabled. Upgrade requests, e.g. WebSocket, are proxied over hijacked
+// connection.
+//
+// Unavailable upstream results in 502 Bad Gateway and upstream timeout in
+// 504 Gateway Timeout rendered with fchi.HandleError.
+func Handler(opts Options) fchi.Handler {
+	if len(opts.Upstreams) == 0 {
+		panic("proxy: at least one upstream is required")
+	}
+
+	if opts.Transport == nil {
+		opts.Transport = defaultTransport()
+	}
+
+	if opts.Retries == 0 {
+		opts.Retries = 2
+	}
+
+	if opts.MaxFails <= 0 {
+		opts.MaxFails = 3
+	}
+
+	if opts.FailTimeout <= 0 {
+		opts.FailTimeout = 10 * time.Second
+	}
+
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
+	b := &balancer{
+		balancing:   opts.Balancing,
+		maxFails:    opts.MaxFails,
+		failTimeout: opts.FailTimeout,
+		now:         opts.now,
+	}
+
+	for _, s := range opts.Upstreams {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			panic(fmt.Sprintf("proxy: invalid upstream URL %q", s))
+		}
+
+		b.upstreams = append(b.upstreams, &upstream{url: u})
+	}
+
+	return &reverseProxy{opts: opts, balancer: b}
+}
+
+func defaultTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          1000,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+	}
+}
+
+type reverseProxy struct {
+	opts     Options
+	balancer *balancer
+}
+
+func (p *reverseProxy) ServeHTTP(ctx context.Context, rc *fasthttp.RequestCtx) {
+	// Response body is streamed after the handler returns, so upstream request
+	// is only canceled with handler context until then.
+	pctx, cancel := context.WithCancel(valuesContext{ctx})
+	handlerDone := make(chan struct{})
+
+	defer close(handlerDone)
+
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-handlerDone:
+			}
+		}()
+	}
+
+	upgrade := upgradeType(&rc.Request.Header)
+	attempts := 1
+
+	var body *requestBody
+
+	if rc.Request.IsBodyStream() && rc.Request.Header.ContentLength() != 0 {
+		body = &requestBody{r: rc.RequestBodyStream(), done: make(chan struct{})}
+
+		// Transport can read request body after response is received, while
+		// body stream is reset after the handler returns.
+		defer body.wait(ctx)
+	} else if isIdempotent(rc) && p.opts.Retries > 0 {
+		// Request body stream can not be repeated.
+		attempts += p.opts.Retries
+	}
+
+	var (
+		tried []*upstream
+		resp  *http.Response
+		err   error
+	)
+
+	for len(tried) < attempts {
+		u := p.balancer.pick(tried)
+		if u == nil {
+			break
+		}
+
+		tried = append(tried, u)
+
+		atomic.AddInt64(&u.inFlight, 1)
+
+		resp, err = p.opts.Transport.RoundTrip(p.upstreamRequest(pctx, rc, u, upgrade, body))
+		if err == nil {
+			p.balancer.report(u, false)
+
+			var once sync.Once
+
+			p.serveResponse(ctx, rc, resp, func() {
+				once.Do(func() {
+					atomic.AddInt64(&u.inFlight, -1)
+					cancel()
+				})
+			})
+
+			return
+		}
+
+		atomic.AddInt64(&u.inFlight, -1)
+
+		if ctx.Err() != nil {
+			// Upstream is not blamed for canceled request.
+			break
+		}
+
+		p.balancer.report(u, true)
+	}
+
+	cancel()
+
+	status := fasthttp.StatusBadGateway
+
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		status = fasthttp.StatusGatewayTimeout
+	}
+
+	fchi.HandleError(ctx, rc, &fchi.Problem{Status: status, Detail: "upstream unavailable"})
+}
+
+func isIdempotent(rc *fasthttp.RequestCtx) bool {
+	switch string(rc.Method()) {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions, fasthttp.MethodTrace,
+		fasthttp.MethodPut, fasthttp.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// upgradeType returns requested protocol of an upgrade request or empty string.
+func upgradeType(h *fasthttp.RequestHeader) string {
+	for _, t := range strings.Split(string(h.Peek("Connection")), ",") {
+		if strings.EqualFold(strings.TrimSpace(t), "upgrade") {
+			return string(h.Peek("Upgrade"))
+		}
+	}
+
+	return ""
+}
+
+// hopHeaders are removed when proxying, RFC 7230 section 6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+func (p *reverseProxy) upstreamRequest(
+	ctx context.Context, rc *fasthttp.RequestCtx, u *upstream, upgrade string, body *requestBody,
+) *http.Request {
+	target := *u.url
+	target.RawPath = joinPath(u.url.EscapedPath(), routePath(rc))
+	target.Path, _ = url.PathUnescape(target.RawPath)
+	target.RawQuery = string(rc.URI().QueryString())
+
+	req := (&http.Request{
+		Method:     string(rc.Method()),
+		URL:        &target,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       target.Host,
+	}).WithContext(ctx)
+
+	rc.Request.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case fasthttp.HeaderHost, fasthttp.HeaderContentLength:
+		default:
+			req.Header.Add(string(k), string(v))
+		}
+	})
+
+	removeHopHeaders(req.Header)
+
+	if upgrade != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgrade)
+	}
+
+	setBody(rc, req, body)
+
+	host := string(rc.Host())
+	if p.opts.PreserveHost {
+		req.Host = host
+	}
+
+	setForwarded(rc, req.Header, host)
+
+	var th fasthttp.RequestHeader
+
+	middleware.InjectTraceContext(ctx, &th)
+
+	if tp := th.Peek(middleware.TraceParentHeader); len(tp) > 0 {
+		req.Header.Set(middleware.TraceParentHeader, string(tp))
+		req.Header.Del(middleware.TraceStateHeader)
+
+		if ts := th.Peek(middleware.TraceStateHeader); len(ts) > 0 {
+			req.Header.Set(middleware.TraceStateHeader, string(ts))
+		}
+	}
+
+	if p.opts.Rewrite != nil {
+		p.opts.Rewrite(ctx, req)
+	}
+
+	return req
+}
+
+// routePath returns request path relative to mount pattern.
+func routePath(rc *fasthttp.RequestCtx) string {
+	if rctx := fchi.RouteContext(rc); rctx != nil && rctx.RoutePath != "" {
+		return rctx.RoutePath
+	}
+
+	return string(rc.URI().PathOriginal())
+}
+
+func joinPath(base, p string) string {
+	switch {
+	case base == "" || base == "/":
+		return p
+	case p == "/":
+		// Mount pattern itself is proxied to the base path.
+		return base
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+func setBody(rc *fasthttp.RequestCtx, req *http.Request, stream *requestBody) {
+	if stream != nil {
+		req.ContentLength = streamLength(&rc.Request.Header)
+		req.Body = stream
+
+		return
+	}
+
+	body := rc.Request.Body()
+	if len(body) == 0 {
+		req.Body = http.NoBody
+
+		return
+	}
+
+	// Body buffer of request is reused after handler returns.
+	b := string(body)
+
+	req.ContentLength = int64(len(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(b)), nil
+	}
+	req.Body, _ = req.GetBody()
+}
+
+// streamLength returns Content-Length of streamed request body or -1 if it is unknown.
+//
+// Content-Length header of streamed request is set by fasthttp to the size of
+// prefetched part of the body, so the value is taken from raw headers.
+func streamLength(h *fasthttp.RequestHeader) int64 {
+	for _, line := range bytes.Split(h.RawHeaders(), []byte("\n")) {
+		i := bytes.IndexByte(line, ':')
+		if i < 0 || !bytes.EqualFold(bytes.TrimSpace(line[:i]), []byte(fasthttp.HeaderContentLength)) {
+			continue
+		}
+
+		if n, err := strconv.ParseInt(string(bytes.TrimSpace(line[i+1:])), 10, 64); err == nil && n >= 0 {
+			return n
+		}
+
+		break
+	}
+
+	return -1
+}
+
+func setForwarded(rc *fasthttp.RequestCtx, h http.Header, host string) {
+	ip := rc.RemoteIP().String()
+	proto := "http"
+
+	if rc.IsTLS() {
+		proto = "https"
+	}
+
+	xff := ip
+	if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+		xff = strings.Join(prior, ", ") + ", " + ip
+	}
+
+	h.Set("X-Forwarded-For", xff)
+	h.Set("X-Forwarded-Host", host)
+	h.Set("X-Forwarded-Proto", proto)
+
+	node := ip
+	if strings.Contains(ip, ":") {
+		node = `"[` + ip + `]"`
+	}
+
+	if strings.ContainsAny(host, ":[]") {
+		host = `"` + host + `"`
+	}
+
+	h.Add("Forwarded", "for="+node+";host="+host+";proto="+proto)
+}
+
+func (p *reverseProxy) serveResponse(ctx context.Context, rc *fasthttp.RequestCtx, resp *http.Response, release func()) {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		p.serveUpgrade(ctx, rc, resp, release)
+
+		return
+	}
+
+	copyResponseHeader(rc, resp)
+	removeHopHeadersResponse(rc)
+
+	rc.SetStatusCode(resp.StatusCode)
+
+	if rc.IsHead() || resp.Body == http.NoBody ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+
+		release()
+
+		if rc.IsHead() && resp.ContentLength >= 0 {
+			rc.Response.Header.SetContentLength(int(resp.ContentLength))
+		}
+
+		return
+	}
+
+	rc.Response.SetBodyStream(&upstreamBody{ReadCloser: resp.Body, release: release}, int(resp.ContentLength))
+}
+
+func copyResponseHeader(rc *fasthttp.RequestCtx, resp *http.Response) {
+	h := &rc.Response.Header
+
+	h.SetNoDefaultContentType(true)
+
+	for k, vv := range resp.Header {
+		switch k {
+		case "Content-Length":
+		case "Content-Type", "Server", "Date":
+			h.Set(k, vv[0])
+		default:
+			for _, v := range vv {
+				h.Add(k, v)
+			}
+		}
+	}
+
+	// Connection tokens are used instead of hop headers for upgrade response.
+	for _, v := range resp.Header["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" && !strings.EqualFold(f, "upgrade") {
+				h.Del(f)
+			}
+		}
+	}
+}
+
+func removeHopHeadersResponse(rc *fasthttp.RequestCtx) {
+	for _, k := range hopHeaders {
+		rc.Response.Header.Del(k)
+	}
+}
+
+func (p *reverseProxy) serveUpgrade(ctx context.Context, rc *fasthttp.RequestCtx, resp *http.Response, release func()) {
+	upstreamConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		_ = resp.Body.Close()
+
+		release()
+		fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusBadGateway, Detail: "upstream switched protocols without connection"})
+
+		return
+	}
+
+	copyResponseHeader(rc, resp)
+	rc.SetStatusCode(resp.StatusCode)
+
+	rc.Hijack(func(c net.Conn) {
+		defer release()
+		defer upstreamConn.Close()
+
+		errc := make(chan error, 2)
+
+		go func() {
+			_, err := io.Copy(upstreamConn, c)
+			errc <- err
+		}()
+
+		go func() {
+			_, err := io.Copy(c, upstreamConn)
+			errc <- err
+		}()
+
+		// Connections are closed when either side finishes.
+		<-errc
+	})
+}
+
+// upstreamBody releases upstream when response body is closed by fasthttp.
+type upstreamBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *upstreamBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	b.release()
+
+	return err
+}
+
+// errRequestBodyClosed is returned by reads of request body after the handler returns.
+var errRequestBodyClosed = errors.New("proxy: request body is closed")
+
+// requestBody is a request body stream that is not read after the handler returns.
+type requestBody struct {
+	r io.Reader
+
+	mu     sync.Mutex // Held while reading.
+	closed int32      // Accessed atomically.
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if atomic.LoadInt32(&b.closed) == 1 {
+		return 0, errRequestBodyClosed
+	}
+
+	return b.r.Read(p)
+}
+
+// Close is called by transport when body is sent or request failed.
+func (b *requestBody) Close() error {
+	b.once.Do(func() {
+		atomic.StoreInt32(&b.closed, 1)
+		close(b.done)
+	})
+
+	return nil
+}
+
+// wait blocks until body is sent or request is canceled, and until current read is finished.
+func (b *requestBody) wait(ctx context.Context) {
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+	}
+
+	_ = b.Close()
+
+	// Lock is taken to wait for current read.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+}
+
+// valuesContext keeps values of parent context without its cancellation.
+type valuesContext struct {
+	context.Context
+}
+
+func (valuesContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (valuesContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valuesContext) Err() error {
+	return nil
+}
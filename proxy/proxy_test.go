@@ -0,0 +1,417 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/swaggest/fchi/middleware"
+	"github.com/swaggest/fchi/ws"
+	"github.com/valyala/fasthttp"
+)
+
+func upstreamServer(name string) *fchi.TestServer {
+	r := fchi.NewRouter()
+	r.Handle("/*", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.Response.Header.Set("X-Upstream", name)
+		rc.Response.Header.Set("Connection", "X-Hop")
+		rc.Response.Header.Set("X-Hop", "1")
+		rc.SetContentType("text/plain")
+
+		h := &rc.Request.Header
+		lines := []string{
+			string(rc.Method()) + " " + string(rc.RequestURI()),
+			"body: " + string(rc.Request.Body()),
+			"xff: " + string(h.Peek("X-Forwarded-For")),
+			"xfh: " + string(h.Peek("X-Forwarded-Host")),
+			"xfp: " + string(h.Peek("X-Forwarded-Proto")),
+			"fwd: " + string(h.Peek("Forwarded")),
+			"trace: " + string(h.Peek("traceparent")),
+			"hop: " + string(h.Peek("X-Client-Hop")),
+		}
+
+		rc.WriteString(strings.Join(lines, "\n"))
+	}))
+	r.Get("/api/stream", fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.SetBodyStreamWriter(func(w *bufio.Writer) {
+			_, _ = w.WriteString("first\n")
+			_ = w.Flush()
+
+			time.Sleep(100 * time.Millisecond)
+
+			_, _ = w.WriteString("second\n")
+		})
+	}))
+	r.Get("/api/ws", ws.Handler(func(ctx context.Context, c *ws.Conn) {
+		typ, msg, err := c.ReadMessage()
+		if err == nil {
+			_ = c.WriteMessage(typ, append([]byte(name+": "), msg...))
+		}
+	}))
+
+	return fchi.NewTestServer(r)
+}
+
+type testSpan struct {
+	sc middleware.SpanContext
+}
+
+func (s testSpan) SpanContext() middleware.SpanContext { return s.sc }
+func (testSpan) SetName(string)                        {}
+func (testSpan) SetAttribute(string, interface{})      {}
+func (testSpan) SetStatus(int)                         {}
+func (testSpan) RecordError(error)                     {}
+func (testSpan) End()                                  {}
+
+type testTracer struct{}
+
+func (testTracer) StartSpan(_ context.Context, _ string, sc, _ middleware.SpanContext) middleware.Span {
+	return testSpan{sc: sc}
+}
+
+func deadUpstream(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = l.Close()
+
+	return "http://" + l.Addr().String()
+}
+
+func get(t *testing.T, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	a := upstreamServer("a")
+	defer a.Close()
+
+	b := upstreamServer("b")
+	defer b.Close()
+
+	r := fchi.NewRouter()
+	r.Use(middleware.Trace(testTracer{}))
+	r.Mount("/legacy", Handler(Options{Upstreams: []string{a.URL + "/api/"}}))
+	r.Mount("/balanced", Handler(Options{Upstreams: []string{a.URL, b.URL}}))
+	r.Mount("/retried", Handler(Options{Upstreams: []string{deadUpstream(t), a.URL}, MaxFails: 2}))
+	r.Mount("/dead", Handler(Options{Upstreams: []string{deadUpstream(t)}}))
+
+	front := fchi.NewTestServer(r)
+	defer front.Close()
+
+	host := strings.TrimPrefix(front.URL, "http://")
+
+	t.Run("rewrite", func(t *testing.T) {
+		resp, body := get(t, front.URL+"/legacy/users/a%2Fb?x=1", http.Header{
+			"X-Forwarded-For": {"10.0.0.1"},
+			"Connection":      {"X-Client-Hop"},
+			"X-Client-Hop":    {"1"},
+			"Traceparent":     {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		})
+
+		expected := "GET /api/users/a%2Fb?x=1\n" +
+			"body: \n" +
+			"xff: 10.0.0.1, 127.0.0.1\n" +
+			"xfh: " + host + "\n" +
+			"xfp: http\n" +
+			"fwd: for=127.0.0.1;host=\"" + host + "\";proto=http\n"
+
+		if !strings.HasPrefix(body, expected) {
+			t.Fatalf("unexpected upstream request:\n%s", body)
+		}
+
+		if !strings.Contains(body, "trace: 00-0af7651916cd43dd8448eb211c80319c-") ||
+			strings.Contains(body, "b7ad6b7169203331") || !strings.HasSuffix(body, "hop: ") {
+			t.Fatalf("unexpected upstream headers:\n%s", body)
+		}
+
+		if resp.Header.Get("X-Upstream") != "a" || resp.Header.Get("X-Hop") != "" ||
+			resp.Header.Get("Content-Type") != "text/plain" {
+			t.Fatalf("unexpected response headers: %v", resp.Header)
+		}
+
+		if _, body := get(t, front.URL+"/legacy", nil); !strings.HasPrefix(body, "GET /api/\n") {
+			t.Fatalf("unexpected upstream request:\n%s", body)
+		}
+	})
+
+	t.Run("body", func(t *testing.T) {
+		resp, err := http.Post(front.URL+"/legacy/items", "text/plain", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if !strings.HasPrefix(string(body), "POST /api/items\nbody: payload\n") {
+			t.Fatalf("unexpected upstream request:\n%s", body)
+		}
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		var seen []string
+
+		for i := 0; i < 4; i++ {
+			resp, _ := get(t, front.URL+"/balanced/", nil)
+			seen = append(seen, resp.Header.Get("X-Upstream"))
+		}
+
+		if s := strings.Join(seen, ""); s != "abab" && s != "baba" {
+			t.Fatalf("unexpected balancing: %s", s)
+		}
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			if resp, _ := get(t, front.URL+"/retried/", nil); resp.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status: %d", resp.StatusCode)
+			}
+		}
+
+		resp, err := http.Post(front.URL+"/dead/", "text/plain", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("unexpected status: %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		resp, err := http.Get(front.URL + "/legacy/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		start := time.Now()
+		br := bufio.NewReader(resp.Body)
+
+		if line, err := br.ReadString('\n'); err != nil || line != "first\n" {
+			t.Fatalf("unexpected line: %q, %v", line, err)
+		}
+
+		if time.Since(start) > 80*time.Millisecond {
+			t.Fatal("first line was not streamed")
+		}
+
+		if rest, err := ioutil.ReadAll(br); err != nil || string(rest) != "second\n" {
+			t.Fatalf("unexpected body: %q, %v", rest, err)
+		}
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		conn, err := net.Dial("tcp", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+		req, _ := http.NewRequest(http.MethodGet, front.URL+"/legacy/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+		if err := req.Write(conn); err != nil {
+			t.Fatal(err)
+		}
+
+		br := bufio.NewReader(conn)
+
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Fatalf("unexpected handshake response: %d %v", resp.StatusCode, resp.Header)
+		}
+
+		// Masked text frame "hi" with zero mask.
+		if _, err := conn.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'}); err != nil {
+			t.Fatal(err)
+		}
+
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		msg := make([]byte, hdr[1])
+		if _, err := io.ReadFull(br, msg); err != nil || hdr[0] != 0x81 || string(msg) != "a: hi" {
+			t.Fatalf("unexpected message: %v %q, %v", hdr, msg, err)
+		}
+	})
+}
+
+func TestBalancer(t *testing.T) {
+	now := time.Now()
+	u1, u2, u3 := &upstream{}, &upstream{}, &upstream{}
+	b := &balancer{
+		upstreams:   []*upstream{u1, u2, u3},
+		balancing:   LeastConn,
+		maxFails:    2,
+		failTimeout: time.Second,
+		now:         func() time.Time { return now },
+	}
+
+	u1.inFlight, u2.inFlight, u3.inFlight = 3, 1, 2
+
+	if u := b.pick(nil); u != u2 {
+		t.Fatal("least loaded upstream expected")
+	}
+
+	if u := b.pick([]*upstream{u2}); u != u3 {
+		t.Fatal("least loaded untried upstream expected")
+	}
+
+	b.report(u2, true)
+
+	if u := b.pick(nil); u != u2 {
+		t.Fatal("upstream should stay healthy before max fails")
+	}
+
+	b.report(u2, true)
+
+	if u := b.pick(nil); u != u3 {
+		t.Fatal("failed upstream should be skipped")
+	}
+
+	b.report(u1, true)
+	b.report(u1, true)
+	b.report(u3, true)
+	b.report(u3, true)
+
+	if u := b.pick(nil); u != u2 {
+		t.Fatal("least loaded upstream expected when all are failed")
+	}
+
+	now = now.Add(time.Second)
+
+	if u := b.pick([]*upstream{u2}); u != u3 {
+		t.Fatal("upstream should recover after fail timeout")
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = map[*upstream]int{}
+	)
+
+	b.balancing = RoundRobin
+
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			u := b.pick(nil)
+
+			mu.Lock()
+			seen[u]++
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if seen[u1] != 10 || seen[u2] != 10 || seen[u3] != 10 {
+		t.Fatalf("unexpected distribution: %d %d %d", seen[u1], seen[u2], seen[u3])
+	}
+}
+
+func TestHandler_streamedBody(t *testing.T) {
+	a := upstreamServer("a")
+	defer a.Close()
+
+	// Upstream responds without reading request body.
+	early := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("early"))
+	}))
+	defer early.Close()
+
+	r := fchi.NewRouter()
+	r.Mount("/a", Handler(Options{Upstreams: []string{a.URL + "/api/"}}))
+	r.Mount("/early", Handler(Options{Upstreams: []string{early.URL}}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &fasthttp.Server{Handler: fchi.RequestHandler(r), StreamRequestBody: true, ReadTimeout: time.Second}
+
+	go func() {
+		_ = srv.Serve(l)
+	}()
+
+	defer func() {
+		_ = srv.Shutdown()
+	}()
+
+	payload := strings.Repeat("x", 200<<10)
+
+	for path, expected := range map[string]string{
+		"/a/items": "POST /api/items\nbody: " + payload + "\n",
+		// Response is received while body is being sent.
+		"/early/": "early",
+	} {
+		resp, err := http.Post("http://"+l.Addr().String()+path, "text/plain", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), expected) {
+			t.Fatalf("%s: unexpected response: %d %.100s", path, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package fchi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Lister lists a resource collection, GET /pattern.
+type Lister interface {
+	List(ctx context.Context, rc *fasthttp.RequestCtx)
+}
+
+// Creator creates an item in a resource collection, POST /pattern.
+type Creator interface {
+	Create(ctx context.Context, rc *fasthttp.RequestCtx)
+}
+
+// Getter reads a single resource item, GET /pattern/{id}.
+type Getter interface {
+	Get(ctx context.Context, rc *fasthttp.RequestCtx)
+}
+
+// Updater replaces a single resource item, PUT /pattern/{id}.
+type Updater interface {
+	Update(ctx context.Context, rc *fasthttp.RequestCtx)
+}
+
+// Patcher partially updates a single resource item, PATCH /pattern/{id}.
+type Patcher interface {
+	Patch(ctx context.Context, rc *fasthttp.RequestCtx)
+}
+
+// Deleter deletes a single resource item, DELETE /pattern/{id}.
+type Deleter interface {
+	Delete(ctx context.Context, rc *fasthttp.RequestCtx)
+}
+
+// IDParamer customizes URL parameter of resource item, default is "id".
+//
+// Parameter can have a regexp, e.g. "id:[0-9]+", the value is then
+// available as URLParam(rc, "id").
+type IDParamer interface {
+	IDParam() string
+}
+
+// Resource mounts CRUD-style handlers of `res` along the `pattern`.
+//
+// Collection routes are served at `pattern`, item routes are served
+// at `pattern/{id}`, handlers are chosen by optional interfaces that `res`
+// implements: Lister, Creator, Getter, Updater, Patcher and Deleter.
+// Requests with methods that `res` does not implement are responded by
+// MethodNotAllowed handler with an Allow header, item routes are not found
+// if `res` implements none of item interfaces.
+//
+// Resource returns the item router, nested resources can be added to it.
+//
+//   r.Resource("/users", users).Resource("/posts", posts)
+//
+// serves /users, /users/{id}, /users/{id}/posts and /users/{id}/posts/{postID}
+// if `posts` implements IDParamer with "postID".
+//
+// Resource is a method of Mux and not of Router interface, so that other
+// implementations of Router stay compatible. In Route or Group callbacks
+// a resource can be added with a separate Mux:
+//
+//   users := fchi.NewRouter()
+//   users.Resource("/", usersResource{})
+//   r.Mount("/users", users)
+func (mx *Mux) Resource(pattern string, res interface{}) *Mux {
+	if res == nil {
+		panic(fmt.Sprintf("chi: attempting to Resource() a nil resource on '%s'", pattern))
+	}
+
+	name := strings.TrimPrefix(fmt.Sprintf("%T", res), "*")
+	idParam := "id"
+
+	if p, ok := res.(IDParamer); ok {
+		idParam = p.IDParam()
+	}
+
+	collection := NewRouter()
+	item := NewRouter()
+
+	var collectionMethods, itemMethods []string
+
+	if h, ok := res.(Lister); ok {
+		collection.Get("/", resourceHandler{name + ".List", h.List})
+		collectionMethods = append(collectionMethods, fasthttp.MethodGet)
+	}
+
+	if h, ok := res.(Creator); ok {
+		collection.Post("/", resourceHandler{name + ".Create", h.Create})
+		collectionMethods = append(collectionMethods, fasthttp.MethodPost)
+	}
+
+	if h, ok := res.(Getter); ok {
+		item.Get("/", resourceHandler{name + ".Get", h.Get})
+		itemMethods = append(itemMethods, fasthttp.MethodGet)
+	}
+
+	if h, ok := res.(Updater); ok {
+		item.Put("/", resourceHandler{name + ".Update", h.Update})
+		itemMethods = append(itemMethods, fasthttp.MethodPut)
+	}
+
+	if h, ok := res.(Patcher); ok {
+		item.Patch("/", resourceHandler{name + ".Patch", h.Patch})
+		itemMethods = append(itemMethods, fasthttp.MethodPatch)
+	}
+
+	if h, ok := res.(Deleter); ok {
+		item.Delete("/", resourceHandler{name + ".Delete", h.Delete})
+		itemMethods = append(itemMethods, fasthttp.MethodDelete)
+	}
+
+	// Without methods, routes of nested resources or of the returned item
+	// router use MethodNotAllowed handler of the mux, an empty Allow header
+	// is not valid.
+	if len(collectionMethods) > 0 {
+		collection.MethodNotAllowed(mx.allowHandler(collectionMethods))
+	}
+
+	if len(itemMethods) > 0 {
+		item.MethodNotAllowed(mx.allowHandler(itemMethods))
+	}
+
+	collection.Mount("/{"+idParam+"}", item)
+	mx.Mount(pattern, collection)
+
+	return item
+}
+
+// allowHandler calls MethodNotAllowedHandler of the mux and sets Allow header.
+//
+// Allow header is set after the call to take precedence over Allow headers
+// of parent resources.
+func (mx *Mux) allowHandler(methods []string) Handler {
+	allow := strings.Join(methods, ", ")
+
+	return HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		mx.MethodNotAllowedHandler().ServeHTTP(ctx, rc)
+		rc.Response.Header.Set("Allow", allow)
+	})
+}
+
+// resourceHandler is a named handler of a resource method, the name is
+// visible in Walk as the handler string representation.
+type resourceHandler struct {
+	name string
+	fn   HandlerFunc
+}
+
+func (h resourceHandler) ServeHTTP(ctx context.Context, rc *fasthttp.RequestCtx) {
+	h.fn(ctx, rc)
+}
+
+// String returns resource type and method name, e.g. "main.usersResource.List".
+func (h resourceHandler) String() string {
+	return h.name
+}
@@ -0,0 +1,180 @@
+package fchi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+type testUsers struct{}
+
+func (testUsers) List(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rc.WriteString("users list")
+}
+
+func (testUsers) Create(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rc.WriteString("users create")
+}
+
+func (testUsers) Get(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rc.WriteString("users get " + URLParam(rc, "id"))
+}
+
+func (testUsers) Delete(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rc.WriteString("users delete " + URLParam(rc, "id"))
+}
+
+type testPosts struct{}
+
+func (testPosts) IDParam() string {
+	return "postID:[0-9]+"
+}
+
+func (testPosts) List(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rc.WriteString("posts list " + URLParam(rc, "id"))
+}
+
+func (testPosts) Patch(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rc.WriteString("posts patch " + URLParam(rc, "id") + " " + URLParam(rc, "postID"))
+}
+
+func TestMuxResource(t *testing.T) {
+	r := NewRouter()
+	r.Resource("/users", testUsers{}).Resource("/posts", testPosts{})
+
+	ts := NewTestServer(r)
+	defer ts.Close()
+
+	cases := []struct {
+		method, path string
+		status       int
+		body, allow  string
+	}{
+		{method: "GET", path: "/users", status: 200, body: "users list"},
+		{method: "POST", path: "/users/", status: 200, body: "users create"},
+		{method: "PUT", path: "/users", status: 405, allow: "GET, POST"},
+		{method: "GET", path: "/users/42", status: 200, body: "users get 42"},
+		{method: "DELETE", path: "/users/42", status: 200, body: "users delete 42"},
+		{method: "PATCH", path: "/users/42", status: 405, allow: "GET, DELETE"},
+		{method: "GET", path: "/users/42/posts", status: 200, body: "posts list 42"},
+		{method: "PATCH", path: "/users/42/posts/7", status: 200, body: "posts patch 42 7"},
+		{method: "GET", path: "/users/42/posts/7", status: 405, allow: "PATCH"},
+		{method: "PATCH", path: "/users/42/posts/abc", status: 404, body: "404 page not found"},
+	}
+
+	for _, c := range cases {
+		resp, body := testRequest(t, ts, c.method, c.path, nil)
+		if resp.StatusCode != c.status || body != c.body || resp.Header.Get("Allow") != c.allow {
+			t.Errorf("%s %s: unexpected response %d %q, Allow: %q",
+				c.method, c.path, resp.StatusCode, body, resp.Header.Get("Allow"))
+		}
+	}
+
+	var routes []string
+
+	err := Walk(r, func(method string, route string, handler Handler, middlewares ...func(Handler) Handler) error {
+		routes = append(routes, fmt.Sprintf("%s %s %s", method, route, handler))
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(routes)
+
+	expected := []string{
+		"DELETE /users/{id}/ fchi.testUsers.Delete",
+		"GET /users/ fchi.testUsers.List",
+		"GET /users/{id}/ fchi.testUsers.Get",
+		"GET /users/{id}/posts/ fchi.testPosts.List",
+		"PATCH /users/{id}/posts/{postID:[0-9]+}/ fchi.testPosts.Patch",
+		"POST /users/ fchi.testUsers.Create",
+	}
+
+	if strings.Join(routes, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected routes:\n%s", strings.Join(routes, "\n"))
+	}
+}
+
+func TestMuxResource_mount(t *testing.T) {
+	r := NewRouter()
+	r.Route("/api", func(r Router) {
+		users := NewRouter()
+		users.Resource("/", testUsers{})
+		r.Mount("/users", users)
+	})
+
+	ts := NewTestServer(r)
+	defer ts.Close()
+
+	cases := []struct {
+		method, path string
+		status       int
+		body, allow  string
+	}{
+		{method: "GET", path: "/api/users", status: 200, body: "users list"},
+		{method: "PUT", path: "/api/users", status: 405, allow: "GET, POST"},
+		{method: "GET", path: "/api/users/42", status: 200, body: "users get 42"},
+		{method: "PATCH", path: "/api/users/42", status: 405, allow: "GET, DELETE"},
+	}
+
+	for _, c := range cases {
+		resp, body := testRequest(t, ts, c.method, c.path, nil)
+		if resp.StatusCode != c.status || body != c.body || resp.Header.Get("Allow") != c.allow {
+			t.Errorf("%s %s: unexpected response %d %q, Allow: %q",
+				c.method, c.path, resp.StatusCode, body, resp.Header.Get("Allow"))
+		}
+	}
+}
+
+type testTags struct{}
+
+func (testTags) List(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rc.WriteString("tags list")
+}
+
+func TestMuxResource_collectionOnly(t *testing.T) {
+	r := NewRouter()
+	r.Resource("/tags", testTags{}).Get("/stats", HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		rc.WriteString("tag stats " + URLParam(rc, "id"))
+	}))
+
+	cases := []struct {
+		method, path string
+		status       int
+		body, allow  string
+	}{
+		{method: "GET", path: "/tags", status: 200, body: "tags list"},
+		{method: "POST", path: "/tags", status: 405, allow: "GET"},
+		{method: "GET", path: "/tags/1", status: 404, body: "404 page not found"},
+		{method: "DELETE", path: "/tags/1", status: 404, body: "404 page not found"},
+		{method: "GET", path: "/tags/1/stats", status: 200, body: "tag stats 1"},
+		{method: "POST", path: "/tags/1/stats", status: 405, allow: "GET"},
+	}
+
+	for _, c := range cases {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod(c.method)
+		rc.Request.SetRequestURI(c.path)
+		r.ServeHTTP(context.Background(), rc)
+
+		allow, hasAllow := "", false
+
+		rc.Response.Header.VisitAll(func(k, v []byte) {
+			if string(k) == "Allow" {
+				allow, hasAllow = string(v), true
+			}
+		})
+
+		if rc.Response.StatusCode() != c.status || string(rc.Response.Body()) != c.body ||
+			allow != c.allow || hasAllow != (c.allow != "") {
+			t.Errorf("%s %s: unexpected response %d %q, Allow: %q",
+				c.method, c.path, rc.Response.StatusCode(), rc.Response.Body(), allow)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package sse provides Server-Sent Events streaming for fchi handlers.
+//
+// A handler function receives a Stream to send events to the client, its
+// context is canceled when the client disconnects or the server shuts down.
+//
+// Example:
+//  r.Get("/events", sse.Handler(func(ctx context.Context, s *sse.Stream) {
+//  	sub := bus.Subscribe(s.LastEventID())
+//  	defer sub.Close()
+//
+//  	for {
+//  		select {
+//  		case <-ctx.Done():
+//  			return
+//  		case msg := <-sub.C:
+//  			if err := s.Send("message", msg.ID, msg.Text); err != nil {
+//  				return
+//  			}
+//  		}
+//  	}
+//  }))
+package sse
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// ErrInvalidField is returned when event name or id contains a line break.
+var ErrInvalidField = errors.New("sse: event and id must not contain line breaks")
+
+// Options configures HandlerWithOptions.
+type Options struct {
+	// KeepAlive is an interval of comments that keep idle connection open
+	// and detect disconnected clients, default 15 seconds, negative value
+	// disables keepalive.
+	KeepAlive time.Duration
+
+	// Retry is a reconnection delay hint sent to the client when stream
+	// starts, zero value leaves client default.
+	Retry time.Duration
+}
+
+// Handler returns a handler that streams events sent by fn.
+func Handler(fn func(ctx context.Context, s *Stream)) fchi.Handler {
+	return HandlerWithOptions(Options{}, fn)
+}
+
+// HandlerWithOptions returns a handler that streams events sent by fn.
+//
+// Stream function is called after the handler returns, so that middlewares
+// that buffer response body (e.g. ETag or Cache) should not be used with it.
+// Context of fn keeps values of request context, but not its deadline or
+// cancellation.
+func HandlerWithOptions(opts Options, fn func(ctx context.Context, s *Stream)) fchi.Handler {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 15 * time.Second
+	}
+
+	return fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		// Request is not available in stream writer once response is done.
+		lastEventID := string(rc.Request.Header.Peek("Last-Event-ID"))
+		serverDone := rc.Done()
+		parent := valuesContext{ctx}
+
+		rc.SetContentType("text/event-stream; charset=utf-8")
+		rc.Response.Header.Set("Cache-Control", "no-cache")
+		rc.Response.Header.Set("X-Accel-Buffering", "no")
+
+		rc.SetBodyStreamWriter(func(w *bufio.Writer) {
+			ctx, cancel := context.WithCancel(parent)
+			defer cancel()
+
+			s := &Stream{w: w, cancel: cancel, lastEventID: lastEventID}
+
+			if opts.Retry > 0 {
+				_ = s.Retry(opts.Retry)
+			} else {
+				// Sending response headers.
+				_ = s.flush()
+			}
+
+			var wg sync.WaitGroup
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				s.watch(ctx, serverDone, opts.KeepAlive)
+			}()
+
+			fn(ctx, s)
+
+			cancel()
+			wg.Wait()
+		})
+	})
+}
+
+// Stream sends events to the client.
+type Stream struct {
+	mu          sync.Mutex
+	w           *bufio.Writer
+	err         error
+	cancel      func()
+	lastEventID string
+}
+
+// LastEventID returns id of the last event received by reconnecting client
+// from Last-Event-ID request header, or id of the last sent event.
+func (s *Stream) LastEventID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastEventID
+}
+
+// Send sends an event to the client, empty event name and id are omitted.
+//
+// Multiline data is sent in multiple data fields. Error is returned if the
+// client has disconnected.
+func (s *Stream) Send(event, id, data string) error {
+	if strings.ContainsAny(event, "\r\n") || strings.ContainsAny(id, "\r\n") {
+		return ErrInvalidField
+	}
+
+	var b strings.Builder
+
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id != "" {
+		s.lastEventID = id
+	}
+
+	return s.write(b.String())
+}
+
+// Retry sends reconnection delay hint to the client.
+func (s *Stream) Retry(d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n")
+}
+
+// Comment sends a comment that is ignored by the client.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(b.String())
+}
+
+func (s *Stream) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write("")
+}
+
+// write writes and flushes message, stream is canceled on failure.
+func (s *Stream) write(msg string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if _, err := s.w.WriteString(msg); err != nil {
+		s.fail(err)
+
+		return err
+	}
+
+	if err := s.w.Flush(); err != nil {
+		s.fail(err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *Stream) fail(err error) {
+	s.err = err
+	s.cancel()
+}
+
+// watch sends keepalive comments and cancels stream on server shutdown.
+func (s *Stream) watch(ctx context.Context, serverDone <-chan struct{}, keepAlive time.Duration) {
+	var tick <-chan time.Time
+
+	if keepAlive > 0 {
+		t := time.NewTicker(keepAlive)
+		defer t.Stop()
+
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-serverDone:
+			s.cancel()
+
+			return
+		case <-tick:
+			_ = s.Comment("keepalive")
+		}
+	}
+}
+
+// valuesContext keeps values of parent context without its cancellation.
+type valuesContext struct {
+	context.Context
+}
+
+func (valuesContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (valuesContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valuesContext) Err() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+package sse_test
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/swaggest/fchi/sse"
+)
+
+func TestHandler(t *testing.T) {
+	disconnected := make(chan struct{})
+
+	r := fchi.NewRouter()
+	r.Get("/events", sse.HandlerWithOptions(sse.Options{Retry: 3 * time.Second}, func(ctx context.Context, s *sse.Stream) {
+		_ = s.Send("greeting", "1", "hello\nworld")
+		_ = s.Send("", "", "from "+s.LastEventID())
+
+		if err := s.Send("bad\nevent", "", ""); err != sse.ErrInvalidField {
+			_ = s.Send("", "", "unexpected error: "+err.Error())
+		}
+	}))
+	r.Get("/resume", sse.Handler(func(ctx context.Context, s *sse.Stream) {
+		_ = s.Send("", "", "after "+s.LastEventID())
+	}))
+	r.Get("/idle", sse.HandlerWithOptions(sse.Options{KeepAlive: 20 * time.Millisecond}, func(ctx context.Context, s *sse.Stream) {
+		time.Sleep(70 * time.Millisecond)
+		_ = s.Send("", "", "done")
+	}))
+	r.Get("/endless", sse.HandlerWithOptions(sse.Options{KeepAlive: 10 * time.Millisecond}, func(ctx context.Context, s *sse.Stream) {
+		_ = s.Send("", "", "first")
+
+		<-ctx.Done()
+		close(disconnected)
+	}))
+
+	ts := fchi.NewTestServer(r)
+	defer ts.Close()
+
+	get := func(path, lastEventID string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	read := func(path, lastEventID string) string {
+		resp := get(path, lastEventID)
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream; charset=utf-8" {
+			t.Fatalf("unexpected content type: %s", ct)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(body)
+	}
+
+	if body := read("/events", ""); body != "retry: 3000\n\n"+
+		"event: greeting\nid: 1\ndata: hello\ndata: world\n\n"+
+		"data: from 1\n\n" {
+		t.Errorf("unexpected events: %q", body)
+	}
+
+	if body := read("/resume", "42"); body != "data: after 42\n\n" {
+		t.Errorf("unexpected events: %q", body)
+	}
+
+	if body := read("/idle", ""); strings.Count(body, ": keepalive\n\n") < 2 || !strings.HasSuffix(body, "data: done\n\n") {
+		t.Errorf("unexpected events: %q", body)
+	}
+
+	resp := get("/endless", "")
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Fatalf("unexpected event: %q, %v", line, err)
+	}
+
+	_ = resp.Body.Close()
+
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream context was not canceled on client disconnect")
+	}
+}
@@ -76,6 +76,10 @@ type node struct {
 	// subroutes on the leaf node
 	subroutes Routes
 
+	// stubRoutes are subroutes of a mount stub without wildcard, they are
+	// only used by Match to avoid duplicate routes in Walk.
+	stubRoutes Routes
+
 	// regexp matcher for regexp nodes
 	rex *regexp.Regexp
 
@@ -112,6 +116,9 @@ type endpoint struct {
 
 	// parameter keys recorded on handler nodes
 	paramKeys []string
+
+	// route metadata, see WithMeta
+	meta []metaPair
 }
 
 func (s endpoints) Value(method methodTyp) *endpoint {
@@ -358,6 +365,18 @@ func (n *node) setEndpoint(method methodTyp, handler Handler, pattern string) {
 	}
 }
 
+// setMeta sets route metadata of the endpoints for the method type.
+func (n *node) setMeta(method methodTyp, meta []metaPair) {
+	if method&mALL == mALL {
+		n.endpoints.Value(mALL).meta = meta
+		for _, m := range methodMap {
+			n.endpoints.Value(m).meta = meta
+		}
+	} else {
+		n.endpoints.Value(method).meta = meta
+	}
+}
+
 func (n *node) FindRoute(rctx *Context, method methodTyp, path string) (*node, endpoints, Handler) {
 	// Reset the context routing pattern and params
 	rctx.routePattern = ""
@@ -374,6 +393,12 @@ func (n *node) FindRoute(rctx *Context, method methodTyp, path string) (*node, e
 	rctx.URLParams.Keys = append(rctx.URLParams.Keys, rctx.routeParams.Keys...)
 	rctx.URLParams.Values = append(rctx.URLParams.Values, rctx.routeParams.Values...)
 
+	// Record the route metadata in the request lifecycle
+	rctx.meta = append(rctx.meta, rn.endpoints[method].meta...)
+	if rn.endpoints[mSTUB] == nil {
+		rctx.routed = true
+	}
+
 	// Record the routing pattern in the request lifecycle
 	if rn.endpoints[method].pattern != "" {
 		rctx.routePattern = rn.endpoints[method].pattern
@@ -0,0 +1,124 @@
+package versioning
+
+import (
+	"github.com/swaggest/fchi"
+)
+
+// mergedRoutes exposes routes of a version together with routes
+// inherited from its fallback version.
+type mergedRoutes struct {
+	primary  fchi.Routes
+	fallback fchi.Routes
+}
+
+// Routes returns primary routes amended with fallback routes and methods
+// that primary does not have.
+func (m mergedRoutes) Routes() []fchi.Route {
+	primary := m.primary.Routes()
+	fallback := m.fallback.Routes()
+	rts := make([]fchi.Route, 0, len(primary)+len(fallback))
+	byPattern := make(map[string]int, len(primary))
+
+	for _, rt := range primary {
+		byPattern[rt.Pattern] = len(rts)
+		rts = append(rts, withMiddlewares(rt, m.primary.Middlewares()))
+	}
+
+	for _, rt := range fallback {
+		rt = withMiddlewares(rt, m.fallback.Middlewares())
+
+		i, ok := byPattern[rt.Pattern]
+		if !ok {
+			rts = append(rts, rt)
+
+			continue
+		}
+
+		p := rts[i]
+
+		if p.SubRoutes != nil && rt.SubRoutes != nil {
+			p.SubRoutes = mergedRoutes{primary: p.SubRoutes, fallback: rt.SubRoutes}
+		}
+
+		hs := make(map[string]fchi.Handler, len(p.Handlers)+len(rt.Handlers))
+
+		for method, h := range rt.Handlers {
+			hs[method] = h
+		}
+
+		for method, h := range p.Handlers {
+			hs[method] = h
+		}
+
+		p.Handlers = hs
+		rts[i] = p
+	}
+
+	return rts
+}
+
+// Middlewares returns nil, middlewares of merged routers are attached to their routes.
+func (m mergedRoutes) Middlewares() fchi.Middlewares {
+	return nil
+}
+
+// Match checks primary routes first and fallback routes next.
+func (m mergedRoutes) Match(rctx *fchi.Context, method, path string) bool {
+	if m.primary.Match(fchi.NewRouteContext(), method, path) {
+		return m.primary.Match(rctx, method, path)
+	}
+
+	return m.fallback.Match(rctx, method, path)
+}
+
+// routesWithMiddlewares prepends middlewares to the ones of wrapped routes.
+type routesWithMiddlewares struct {
+	routes fchi.Routes
+	mws    fchi.Middlewares
+}
+
+func (r routesWithMiddlewares) Routes() []fchi.Route {
+	return r.routes.Routes()
+}
+
+func (r routesWithMiddlewares) Middlewares() fchi.Middlewares {
+	mws := make(fchi.Middlewares, 0, len(r.mws)+len(r.routes.Middlewares()))
+	mws = append(mws, r.mws...)
+
+	return append(mws, r.routes.Middlewares()...)
+}
+
+func (r routesWithMiddlewares) Match(rctx *fchi.Context, method, path string) bool {
+	return r.routes.Match(rctx, method, path)
+}
+
+// withMiddlewares attaches router middlewares to a route, so that fchi.Walk
+// reports them after routes of different routers are merged.
+func withMiddlewares(rt fchi.Route, mws fchi.Middlewares) fchi.Route {
+	if len(mws) == 0 {
+		return rt
+	}
+
+	if rt.SubRoutes != nil {
+		rt.SubRoutes = routesWithMiddlewares{routes: rt.SubRoutes, mws: mws}
+
+		return rt
+	}
+
+	hs := make(map[string]fchi.Handler, len(rt.Handlers))
+
+	for method, h := range rt.Handlers {
+		if ch, ok := h.(*fchi.ChainHandler); ok {
+			chain := make(fchi.Middlewares, 0, len(mws)+len(ch.Middlewares))
+			chain = append(chain, mws...)
+			chain = append(chain, ch.Middlewares...)
+			hs[method] = chain.Handler(ch.Endpoint)
+		} else {
+			hs[method] = mws.Handler(h)
+		}
+	}
+
+	rt.Handlers = hs
+
+	return rt
+}
@@ -0,0 +1,133 @@
+package versioning
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a dotted numeric API version, for example "2" or "2.1".
+//
+// Missing trailing components are treated as zeros, so "2" and "2.0" are equal.
+type Version []int
+
+// ParseVersion parses a version string with an optional "v" prefix,
+// e.g. "v2", "2.1" or "V3.0.1".
+func ParseVersion(s string) (Version, error) {
+	if len(s) > 0 && (s[0] == 'v' || s[0] == 'V') {
+		s = s[1:]
+	}
+
+	if s == "" {
+		return nil, fmt.Errorf("versioning: empty version")
+	}
+
+	parts := strings.Split(s, ".")
+	v := make(Version, len(parts))
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p[0] == '+' {
+			return nil, fmt.Errorf("versioning: invalid version %q", s)
+		}
+
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater than w.
+func (v Version) Compare(w Version) int {
+	n := len(v)
+	if len(w) > n {
+		n = len(w)
+	}
+
+	for i := 0; i < n; i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+
+		if i < len(w) {
+			b = w[i]
+		}
+
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// String returns version in dotted form without prefix.
+func (v Version) String() string {
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = strconv.Itoa(n)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Range is an inclusive range of versions.
+type Range struct {
+	Min, Max Version
+}
+
+// ParseRange parses an exact version ("v2") or an inclusive range of
+// versions separated by a dash ("1.0-1.4").
+func ParseRange(s string) (Range, error) {
+	var (
+		r   Range
+		err error
+	)
+
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if r.Min, err = ParseVersion(strings.TrimSpace(s[:i])); err != nil {
+			return r, err
+		}
+
+		if r.Max, err = ParseVersion(strings.TrimSpace(s[i+1:])); err != nil {
+			return r, err
+		}
+
+		if r.Min.Compare(r.Max) > 0 {
+			return r, fmt.Errorf("versioning: empty range %q", s)
+		}
+
+		return r, nil
+	}
+
+	if r.Min, err = ParseVersion(strings.TrimSpace(s)); err != nil {
+		return r, err
+	}
+
+	r.Max = r.Min
+
+	return r, nil
+}
+
+// Contains checks if version belongs to range.
+func (r Range) Contains(v Version) bool {
+	return r.Min.Compare(v) <= 0 && r.Max.Compare(v) >= 0
+}
+
+// Overlaps checks if two ranges have common versions.
+func (r Range) Overlaps(o Range) bool {
+	return r.Min.Compare(o.Max) <= 0 && o.Min.Compare(r.Max) <= 0
+}
+
+// String returns range in the form accepted by ParseRange.
+func (r Range) String() string {
+	if r.Min.Compare(r.Max) == 0 {
+		return r.Min.String()
+	}
+
+	return r.Min.String() + "-" + r.Max.String()
+}
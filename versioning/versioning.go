@@ -0,0 +1,427 @@
+// Package versioning provides API versioning on top of fchi.Mux.
+//
+// A versioning Router dispatches requests to a handler registered for the
+// requested API version. The version can be taken from the URL path prefix
+// (/v2/articles), a custom request header (X-API-Version: 2) or a parameter of
+// Accept media type (Accept: application/json; version=2).
+//
+// Example:
+//  vr := versioning.New(versioning.Options{
+//  	PathPrefix: true,
+//  	Header:     "X-API-Version",
+//  	Default:    "3",
+//  })
+//
+//  vr.Handle("1.0-1.9", v1Router(), versioning.Deprecated(deprecatedAt), versioning.Sunset(sunsetAt))
+//  vr.Handle("2", v2Router())
+//  vr.Handle("3", v3Router(), versioning.FallbackTo("2"))
+//
+//  r := fchi.NewRouter()
+//  r.Mount("/api", vr)
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+var _ fchi.Routes = &Router{}
+
+// Options configures version resolution of a Router.
+type Options struct {
+	// PathPrefix enables version selection by the first path segment,
+	// for example /v2/articles. The segment must start with "v".
+	PathPrefix bool
+
+	// Header is the name of request header that carries version,
+	// for example "X-API-Version". Header resolution is disabled if empty.
+	Header string
+
+	// MediaTypeParam is the name of Accept media type parameter that carries version,
+	// for example "version" for "Accept: application/json; version=2".
+	// Media type resolution is disabled if empty.
+	MediaTypeParam string
+
+	// Default is a version to use when request does not specify one.
+	// Such requests are rejected if Default is empty.
+	Default string
+
+	// Unsupported handles requests with unknown or missing version.
+	// Default handler responds with 400 Bad Request.
+	Unsupported fchi.Handler
+}
+
+// Option customizes a registered version.
+type Option func(e *entry)
+
+// Deprecated marks version as deprecated since the given time, responses
+// of such version have Deprecation header.
+func Deprecated(since time.Time) Option {
+	return func(e *entry) {
+		e.deprecated = since
+	}
+}
+
+// Sunset sets the time when version is going to be retired, responses of such
+// version have Sunset header.
+func Sunset(at time.Time) Option {
+	return func(e *entry) {
+		e.sunset = at
+	}
+}
+
+// DeprecationLink adds a Link header with "deprecation" relation to
+// responses of the version.
+func DeprecationLink(url string) Option {
+	return func(e *entry) {
+		e.link = url
+	}
+}
+
+// FallbackTo serves requests from another version when the route is not
+// available in this version.
+//
+// For example, with FallbackTo("2") on version 3 a request to a route that
+// only exists in version 2 router is served by version 2 handler.
+func FallbackTo(version string) Option {
+	v, err := ParseVersion(version)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return func(e *entry) {
+		e.fallback = v
+	}
+}
+
+type entry struct {
+	rng      Range
+	prefix   string
+	handler  fchi.Handler
+	routes   fchi.Routes
+	fallback Version
+
+	deprecated time.Time
+	sunset     time.Time
+	link       string
+}
+
+type ctxKeyVersion struct{}
+
+// FromContext returns API version of the request, or nil if the request
+// was not served by a versioning Router.
+func FromContext(ctx context.Context) Version {
+	v, _ := ctx.Value(ctxKeyVersion{}).(Version)
+
+	return v
+}
+
+// Router dispatches requests to handlers of API versions.
+//
+// Router implements fchi.Routes, each version is reported under its path
+// prefix (e.g. /v2/articles) regardless of enabled resolution methods.
+type Router struct {
+	opts     Options
+	def      Version
+	entries  []*entry
+	mux      *fchi.Mux
+	mediaKey string
+}
+
+// New creates a versioning Router.
+func New(opts Options) *Router {
+	vr := &Router{
+		opts:     opts,
+		mux:      fchi.NewMux(),
+		mediaKey: strings.ToLower(opts.MediaTypeParam),
+	}
+
+	if opts.Default != "" {
+		v, err := ParseVersion(opts.Default)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		vr.def = v
+	}
+
+	if vr.opts.Unsupported == nil {
+		vr.opts.Unsupported = fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			rc.Error("unsupported API version", fasthttp.StatusBadRequest)
+		})
+	}
+
+	vr.mux.Handle("/*", fchi.HandlerFunc(vr.serve))
+
+	return vr
+}
+
+// Handle registers a handler for a version or an inclusive range of versions,
+// e.g. "2", "v2.1" or "1.0-1.4". Ranges of different handlers must not overlap.
+func (vr *Router) Handle(versions string, h fchi.Handler, options ...Option) {
+	if h == nil {
+		panic(fmt.Sprintf("versioning: attempting to Handle() a nil handler for '%s'", versions))
+	}
+
+	rng, err := ParseRange(versions)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	for _, e := range vr.entries {
+		if e.rng.Overlaps(rng) {
+			panic(fmt.Sprintf("versioning: versions '%s' overlap with '%s'", rng, e.rng))
+		}
+	}
+
+	e := &entry{
+		rng:     rng,
+		prefix:  "/v" + rng.Min.String(),
+		handler: h,
+	}
+
+	if routes, ok := h.(fchi.Routes); ok {
+		e.routes = routes
+	} else {
+		m := fchi.NewMux()
+		m.Handle("/*", h)
+		e.routes = m
+	}
+
+	for _, o := range options {
+		o(e)
+	}
+
+	vr.entries = append(vr.entries, e)
+}
+
+// ServeHTTP dispatches the request to a handler of requested version.
+func (vr *Router) ServeHTTP(ctx context.Context, rc *fasthttp.RequestCtx) {
+	vr.mux.ServeHTTP(ctx, rc)
+}
+
+// Routes returns routes of every registered version under its path prefix.
+func (vr *Router) Routes() []fchi.Route {
+	rts := make([]fchi.Route, 0, len(vr.entries))
+
+	for _, e := range vr.entries {
+		rts = append(rts, fchi.Route{
+			SubRoutes: vr.effectiveRoutes(e),
+			Handlers:  map[string]fchi.Handler{"*": e.handler},
+			Pattern:   e.prefix + "/*",
+		})
+	}
+
+	return rts
+}
+
+// Middlewares returns an empty list as Router has no own middlewares.
+func (vr *Router) Middlewares() fchi.Middlewares {
+	return nil
+}
+
+// Match searches the routing tree of a version that matches the
+// path prefix of the path, e.g. /v2/articles.
+func (vr *Router) Match(rctx *fchi.Context, method, path string) bool {
+	v, rest, ok := versionFromPath(path)
+	if !ok {
+		return false
+	}
+
+	e := vr.find(v)
+	if e == nil {
+		return false
+	}
+
+	rctx.RoutePath = rest
+
+	return vr.effectiveRoutes(e).Match(rctx, method, rest)
+}
+
+func (vr *Router) serve(ctx context.Context, rc *fasthttp.RequestCtx) {
+	rctx := fchi.RouteContext(rc)
+	routePath := "/" + rctx.URLParam("*")
+
+	// Reset the wildcard URLParam similarly to fchi.Mux.Mount.
+	n := len(rctx.URLParams.Keys) - 1
+	if n >= 0 && rctx.URLParams.Keys[n] == "*" && len(rctx.URLParams.Values) > n {
+		rctx.URLParams.Values[n] = ""
+	}
+
+	v, rest, fromPath := vr.resolve(rc, routePath)
+	if v == nil {
+		vr.opts.Unsupported.ServeHTTP(ctx, rc)
+
+		return
+	}
+
+	e := vr.find(v)
+	if e == nil {
+		vr.opts.Unsupported.ServeHTTP(ctx, rc)
+
+		return
+	}
+
+	vr.setHeaders(rc, e)
+
+	if fromPath {
+		rctx.RoutePatterns = append(rctx.RoutePatterns, e.prefix+"/*")
+	}
+
+	rctx.RoutePath = rest
+	ctx = context.WithValue(ctx, ctxKeyVersion{}, v)
+
+	vr.handlerFor(e, string(rc.Method()), rest).ServeHTTP(ctx, rc)
+}
+
+// resolve finds requested version and the remaining routing path.
+func (vr *Router) resolve(rc *fasthttp.RequestCtx, routePath string) (v Version, rest string, fromPath bool) {
+	if vr.opts.PathPrefix {
+		if v, rest, ok := versionFromPath(routePath); ok {
+			return v, rest, true
+		}
+	}
+
+	if vr.opts.Header != "" {
+		if h := rc.Request.Header.Peek(vr.opts.Header); len(h) > 0 {
+			v, err := ParseVersion(strings.TrimSpace(string(h)))
+			if err != nil {
+				return nil, routePath, false
+			}
+
+			return v, routePath, false
+		}
+	}
+
+	if vr.mediaKey != "" {
+		if p, ok := mediaTypeParam(string(rc.Request.Header.Peek("Accept")), vr.mediaKey); ok {
+			v, err := ParseVersion(p)
+			if err != nil {
+				return nil, routePath, false
+			}
+
+			return v, routePath, false
+		}
+	}
+
+	return vr.def, routePath, false
+}
+
+// find returns entry that contains the version.
+func (vr *Router) find(v Version) *entry {
+	for _, e := range vr.entries {
+		if e.rng.Contains(v) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// handlerFor returns handler of the entry or of its fallback if the entry has no matching route.
+func (vr *Router) handlerFor(e *entry, method, path string) fchi.Handler {
+	seen := 0
+
+	for cur := e; cur != nil && seen <= len(vr.entries); seen++ {
+		if cur.fallback == nil || cur.routes.Match(fchi.NewRouteContext(), method, path) {
+			return cur.handler
+		}
+
+		fb := vr.find(cur.fallback)
+		if fb == nil {
+			return cur.handler
+		}
+
+		cur = fb
+	}
+
+	return e.handler
+}
+
+// effectiveRoutes returns routes of the entry merged with routes of its fallbacks.
+func (vr *Router) effectiveRoutes(e *entry) fchi.Routes {
+	routes := e.routes
+	seen := map[*entry]bool{e: true}
+
+	for cur := e; cur.fallback != nil; {
+		fb := vr.find(cur.fallback)
+		if fb == nil || seen[fb] {
+			break
+		}
+
+		seen[fb] = true
+		routes = mergedRoutes{primary: routes, fallback: fb.routes}
+		cur = fb
+	}
+
+	return routes
+}
+
+func (vr *Router) setHeaders(rc *fasthttp.RequestCtx, e *entry) {
+	h := &rc.Response.Header
+
+	if vr.opts.Header != "" {
+		h.Add("Vary", vr.opts.Header)
+	}
+
+	if vr.mediaKey != "" {
+		h.Add("Vary", "Accept")
+	}
+
+	if !e.deprecated.IsZero() {
+		h.Set("Deprecation", "@"+strconv.FormatInt(e.deprecated.Unix(), 10))
+	}
+
+	if !e.sunset.IsZero() {
+		h.Set("Sunset", string(fasthttp.AppendHTTPDate(nil, e.sunset)))
+	}
+
+	if e.link != "" {
+		h.Add("Link", "<"+e.link+`>; rel="deprecation"`)
+	}
+}
+
+// versionFromPath parses version from the first segment of path, e.g. /v2/articles.
+func versionFromPath(path string) (Version, string, bool) {
+	if len(path) < 3 || path[0] != '/' || (path[1] != 'v' && path[1] != 'V') || path[2] < '0' || path[2] > '9' {
+		return nil, path, false
+	}
+
+	segment, rest := path[1:], "/"
+	if i := strings.IndexByte(segment, '/'); i >= 0 {
+		segment, rest = segment[:i], segment[i:]
+	}
+
+	v, err := ParseVersion(segment)
+	if err != nil {
+		return nil, path, false
+	}
+
+	return v, rest, true
+}
+
+// mediaTypeParam finds the value of a parameter in Accept header media ranges.
+func mediaTypeParam(accept, key string) (string, bool) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+
+		for _, p := range params[1:] {
+			i := strings.IndexByte(p, '=')
+			if i < 0 {
+				continue
+			}
+
+			if strings.ToLower(strings.TrimSpace(p[:i])) == key {
+				return strings.Trim(strings.TrimSpace(p[i+1:]), `"`), true
+			}
+		}
+	}
+
+	return "", false
+}
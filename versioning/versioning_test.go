@@ -0,0 +1,141 @@
+package versioning_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/swaggest/fchi/versioning"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRouter(t *testing.T) {
+	deprecated := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	vr := versioning.New(versioning.Options{
+		PathPrefix:     true,
+		Header:         "X-API-Version",
+		MediaTypeParam: "version",
+		Default:        "3",
+	})
+	vr.Handle("1.0-1.9", versionRouter("v1", "/articles"), versioning.Deprecated(deprecated), versioning.Sunset(sunset))
+	vr.Handle("2", versionRouter("v2", "/articles", "/authors"))
+	vr.Handle("3", versionRouter("v3", "/articles"), versioning.FallbackTo("2"))
+
+	r := fchi.NewRouter()
+	r.Mount("/api", vr)
+
+	cases := []struct {
+		path, header, accept string
+		body                 string
+	}{
+		{path: "/api/v1/articles", body: "v1 /api/v1.0/articles 1"},
+		{path: "/api/v1.5/articles", body: "v1 /api/v1.0/articles 1.5"},
+		{path: "/api/v2/articles", body: "v2 /api/v2/articles 2"},
+		{path: "/api/articles", body: "v3 /api/articles 3"},
+		{path: "/api/articles", header: "2", body: "v2 /api/articles 2"},
+		{path: "/api/articles", accept: `application/json; version="1.2"`, body: "v1 /api/articles 1.2"},
+		{path: "/api/v3/authors", body: "v2 /api/v3/authors 3"},
+		{path: "/api/v4/articles", body: "unsupported API version"},
+		{path: "/api/articles", header: "foo", body: "unsupported API version"},
+	}
+
+	for _, c := range cases {
+		rc := &fasthttp.RequestCtx{}
+		rc.Request.Header.SetMethod("GET")
+		rc.Request.SetRequestURI(c.path)
+
+		if c.header != "" {
+			rc.Request.Header.Set("X-API-Version", c.header)
+		}
+
+		if c.accept != "" {
+			rc.Request.Header.Set("Accept", c.accept)
+		}
+
+		r.ServeHTTP(context.Background(), rc)
+
+		if body := string(rc.Response.Body()); body != c.body {
+			t.Errorf("%s: expected %q, got %q", c.path, c.body, body)
+		}
+
+		deprecation := string(rc.Response.Header.Peek("Deprecation"))
+		if strings.HasPrefix(c.body, "v1") {
+			if deprecation != "@1609459200" {
+				t.Errorf("%s: unexpected Deprecation header %q", c.path, deprecation)
+			}
+
+			if s := string(rc.Response.Header.Peek("Sunset")); s != "Sat, 01 Jan 2022 00:00:00 GMT" {
+				t.Errorf("%s: unexpected Sunset header %q", c.path, s)
+			}
+		} else if deprecation != "" {
+			t.Errorf("%s: unexpected Deprecation header %q", c.path, deprecation)
+		}
+	}
+
+	var routes []string
+
+	err := fchi.Walk(r, func(method string, route string, handler fchi.Handler, middlewares ...func(fchi.Handler) fchi.Handler) error {
+		if method == "GET" {
+			routes = append(routes, route)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(routes)
+
+	expected := "/api/v1.0/articles /api/v2/articles /api/v2/authors /api/v3/articles /api/v3/authors"
+	if s := strings.Join(routes, " "); s != expected {
+		t.Fatalf("unexpected routes: %s", s)
+	}
+
+	if !r.Match(fchi.NewRouteContext(), "GET", "/api/v3/authors") {
+		t.Fatal("fallback route expected to match")
+	}
+
+	if r.Match(fchi.NewRouteContext(), "GET", "/api/v1/authors") {
+		t.Fatal("missing route expected to not match")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	r, err := versioning.ParseRange("v1.2 - 1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for v, expected := range map[string]bool{"1.1": false, "1.2": true, "1.9": true, "1.10": true, "1.10.1": false, "2": false} {
+		ver, err := versioning.ParseVersion(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.Contains(ver) != expected {
+			t.Errorf("%s: expected %v", v, expected)
+		}
+	}
+
+	if _, err := versioning.ParseRange("2-1"); err == nil {
+		t.Fatal("error expected for empty range")
+	}
+}
+
+func versionRouter(name string, patterns ...string) fchi.Router {
+	r := fchi.NewRouter()
+
+	for _, p := range patterns {
+		r.Get(p, fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			rc.WriteString(name + " " + fchi.RouteContext(rc).RoutePattern() + " " + versioning.FromContext(ctx).String())
+		}))
+	}
+
+	return r
+}
@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// deflateResponse accepts permessage-deflate without context takeover, so
+// that every message is compressed independently.
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// offersDeflate checks if the client offers permessage-deflate with
+// parameters acceptable for deflateResponse.
+func offersDeflate(rc *fasthttp.RequestCtx) bool {
+	for _, ext := range headerValues(rc, "Sec-WebSocket-Extensions") {
+		params := strings.Split(ext, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		acceptable := true
+
+		for _, p := range params[1:] {
+			name := strings.TrimSpace(strings.SplitN(p, "=", 2)[0])
+
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			default:
+				// Limiting server window is not supported.
+				acceptable = false
+			}
+		}
+
+		if acceptable {
+			return true
+		}
+	}
+
+	return false
+}
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+
+		return w
+	},
+}
+
+// compress returns payload of a compressed message.
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	w.Reset(&buf)
+
+	// Writing to bytes.Buffer does not fail.
+	_, _ = w.Write(data)
+	_ = w.Flush()
+
+	// Sync flush marker is removed from the end of message, RFC 7692 section 7.2.1.
+	return bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+}
+
+// deflateTail restores sync flush marker and terminates the stream with an
+// empty final block.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// decompress returns payload of a compressed message limited by size.
+func decompress(data []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)))
+	defer r.Close()
+
+	var buf bytes.Buffer
+
+	n, err := buf.ReadFrom(io.LimitReader(r, limit+1))
+
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	case n > limit:
+		return nil, ErrMessageTooBig
+	}
+
+	return buf.Bytes(), nil
+}
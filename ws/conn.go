@@ -0,0 +1,467 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/swaggest/fchi"
+)
+
+// MessageType is a type of data message.
+type MessageType int
+
+// Data message types.
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Close codes defined in RFC 6455, section 11.7.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseInternalServerErr       = 1011
+)
+
+// Connection errors.
+var (
+	ErrClosed         = errors.New("ws: connection closed")
+	ErrProtocol       = errors.New("ws: protocol error")
+	ErrMessageTooBig  = errors.New("ws: message too big")
+	ErrInvalidPayload = errors.New("ws: invalid message payload")
+)
+
+// CloseError is returned by ReadMessage when the peer closes connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+// Error implements error.
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("ws: connection closed by peer: %d %s", e.Code, e.Reason)
+}
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsvBits = 0x70
+	maskBit = 0x80
+
+	maxControlPayload = 125
+)
+
+// Conn is a server side WebSocket connection.
+//
+// Write methods are safe for concurrent use, ReadMessage should be called
+// from one goroutine at a time.
+type Conn struct {
+	nc     net.Conn
+	br     *bufio.Reader
+	cancel func()
+
+	rctx        *fchi.Context
+	subprotocol string
+	compress    bool
+	readLimit   int64
+	readErr     error
+
+	mu        sync.Mutex
+	writeErr  error
+	closeSent bool
+}
+
+func (c *Conn) init(nc net.Conn, cancel func()) {
+	c.nc = nc
+	c.br = bufio.NewReader(nc)
+	c.cancel = cancel
+}
+
+// RouteContext returns routing context of the upgrade request.
+func (c *Conn) RouteContext() *fchi.Context {
+	return c.rctx
+}
+
+// URLParam returns URL parameter of the upgrade request.
+func (c *Conn) URLParam(key string) string {
+	if c.rctx == nil {
+		return ""
+	}
+
+	return c.rctx.URLParam(key)
+}
+
+// Subprotocol returns negotiated subprotocol or empty string.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compressed tells if permessage-deflate extension is negotiated.
+func (c *Conn) Compressed() bool {
+	return c.compress
+}
+
+// RemoteAddr returns remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.nc.RemoteAddr()
+}
+
+// SetReadDeadline sets deadline of reading messages.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.nc.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets deadline of writing messages.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.nc.SetWriteDeadline(t)
+}
+
+// ReadMessage reads next data message.
+//
+// Pings are answered with pongs while reading. When the peer closes
+// connection, close frame is echoed and *CloseError is returned. Protocol
+// violations close connection with an error status.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+
+		c.failRead(err)
+	}
+
+	return typ, msg, err
+}
+
+func (c *Conn) failRead(err error) {
+	var closeErr *CloseError
+
+	switch {
+	case errors.As(err, &closeErr):
+		code := closeErr.Code
+		if code == CloseNoStatusReceived {
+			code = CloseNormalClosure
+		}
+
+		_ = c.writeClose(code, "")
+	case errors.Is(err, ErrMessageTooBig):
+		_ = c.writeClose(CloseMessageTooBig, "")
+	case errors.Is(err, ErrInvalidPayload):
+		_ = c.writeClose(CloseInvalidFramePayloadData, "")
+	case errors.Is(err, ErrProtocol):
+		_ = c.writeClose(CloseProtocolError, "")
+	}
+
+	_ = c.nc.Close()
+	c.cancel()
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		typ        MessageType
+		msg        []byte
+		compressed bool
+	)
+
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if h.op >= opClose {
+			if err := c.handleControl(h); err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		}
+
+		switch {
+		case h.op == opContinuation && typ == 0:
+			return 0, nil, fmt.Errorf("%w: unexpected continuation frame", ErrProtocol)
+		case h.op != opContinuation && typ != 0:
+			return 0, nil, fmt.Errorf("%w: unfinished fragmented message", ErrProtocol)
+		case h.op != opContinuation && h.op != opText && h.op != opBinary:
+			return 0, nil, fmt.Errorf("%w: unknown opcode %d", ErrProtocol, h.op)
+		case h.rsv1 && (!c.compress || h.op == opContinuation):
+			return 0, nil, fmt.Errorf("%w: unexpected RSV1 bit", ErrProtocol)
+		}
+
+		if h.op != opContinuation {
+			typ = MessageType(h.op)
+			compressed = h.rsv1
+		}
+
+		if int64(len(msg))+h.length > c.readLimit {
+			return 0, nil, ErrMessageTooBig
+		}
+
+		if msg, err = c.readPayload(h, msg); err != nil {
+			return 0, nil, err
+		}
+
+		if h.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+
+		if msg, err = decompress(msg, c.readLimit); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, fmt.Errorf("%w: invalid UTF-8 text", ErrInvalidPayload)
+	}
+
+	return typ, msg, nil
+}
+
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	op     byte
+	length int64
+	mask   [4]byte
+}
+
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var (
+		h   frameHeader
+		buf [8]byte
+	)
+
+	if _, err := io.ReadFull(c.br, buf[:2]); err != nil {
+		return h, err
+	}
+
+	h.fin = buf[0]&finBit != 0
+	h.rsv1 = buf[0]&rsv1Bit != 0
+	h.op = buf[0] & 0x0f
+	h.length = int64(buf[1] & 0x7f)
+
+	if buf[0]&rsvBits&^rsv1Bit != 0 {
+		return h, fmt.Errorf("%w: unexpected RSV bits", ErrProtocol)
+	}
+
+	if buf[1]&maskBit == 0 {
+		return h, fmt.Errorf("%w: unmasked client frame", ErrProtocol)
+	}
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, buf[:2]); err != nil {
+			return h, err
+		}
+
+		h.length = int64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, buf[:8]); err != nil {
+			return h, err
+		}
+
+		l := binary.BigEndian.Uint64(buf[:8])
+		if l > 1<<63-1 {
+			return h, fmt.Errorf("%w: invalid frame length", ErrProtocol)
+		}
+
+		h.length = int64(l)
+	}
+
+	if h.op >= opClose && (!h.fin || h.length > maxControlPayload) {
+		return h, fmt.Errorf("%w: invalid control frame", ErrProtocol)
+	}
+
+	if _, err := io.ReadFull(c.br, h.mask[:]); err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+// readPayload reads unmasked frame payload appending it to buf.
+func (c *Conn) readPayload(h frameHeader, buf []byte) ([]byte, error) {
+	n := len(buf)
+	buf = append(buf, make([]byte, h.length)...)
+
+	if _, err := io.ReadFull(c.br, buf[n:]); err != nil {
+		return nil, err
+	}
+
+	for i := range buf[n:] {
+		buf[n+i] ^= h.mask[i&3]
+	}
+
+	return buf, nil
+}
+
+func (c *Conn) handleControl(h frameHeader) error {
+	payload, err := c.readPayload(h, nil)
+	if err != nil {
+		return err
+	}
+
+	switch h.op {
+	case opPing:
+		return c.writeFrame(opPong, false, payload)
+	case opPong:
+		return nil
+	case opClose:
+		closeErr := &CloseError{Code: CloseNoStatusReceived}
+
+		switch {
+		case len(payload) == 1:
+			return fmt.Errorf("%w: invalid close frame", ErrProtocol)
+		case len(payload) >= 2:
+			closeErr.Code = int(binary.BigEndian.Uint16(payload))
+			closeErr.Reason = string(payload[2:])
+
+			if !validCloseCode(closeErr.Code) {
+				return fmt.Errorf("%w: invalid close code %d", ErrProtocol, closeErr.Code)
+			}
+
+			if !utf8.ValidString(closeErr.Reason) {
+				return fmt.Errorf("%w: invalid UTF-8 close reason", ErrInvalidPayload)
+			}
+		}
+
+		return closeErr
+	default:
+		return fmt.Errorf("%w: unknown opcode %d", ErrProtocol, h.op)
+	}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1011:
+		return false
+	}
+
+	return code != 1004 && code != CloseNoStatusReceived && code != CloseAbnormalClosure
+}
+
+// WriteMessage writes a data message, it is compressed if permessage-deflate
+// is negotiated.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("ws: invalid message type %d", typ)
+	}
+
+	if c.compress {
+		return c.writeFrame(byte(typ), true, compress(data))
+	}
+
+	return c.writeFrame(byte(typ), false, data)
+}
+
+// Ping sends a ping with optional payload of up to 125 bytes.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("ws: ping payload too long")
+	}
+
+	return c.writeFrame(opPing, false, data)
+}
+
+// Close sends close frame with status code and reason, and closes connection.
+//
+// Connection is also closed when the handler function returns.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeClose(code, reason)
+
+	_ = c.nc.Close()
+	c.cancel()
+
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	return c.writeFrame(opClose, false, payload)
+}
+
+func (c *Conn) writeFrame(op byte, rsv1 bool, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	hdr := make([]byte, 2, 10+len(payload))
+	hdr[0] = finBit | op
+
+	if rsv1 {
+		hdr[0] |= rsv1Bit
+	}
+
+	switch l := len(payload); {
+	case l <= 125:
+		hdr[1] = byte(l)
+	case l <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, byte(l>>8), byte(l))
+	default:
+		hdr = hdr[:10]
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+	}
+
+	if op == opClose {
+		c.closeSent = true
+	}
+
+	if _, err := c.nc.Write(append(hdr, payload...)); err != nil {
+		c.writeErr = err
+		c.cancel()
+
+		return err
+	}
+
+	return nil
+}
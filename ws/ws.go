@@ -0,0 +1,283 @@
+// Package ws provides WebSocket (RFC 6455) handlers routed with fchi.Mux.
+//
+// Handler performs the opening handshake, hijacks the connection and passes
+// a framed connection to the handler function together with the routing
+// context of the upgrade request.
+//
+// Example:
+//  r.Get("/ws/{room}", ws.Handler(func(ctx context.Context, c *ws.Conn) {
+//  	room := c.URLParam("room")
+//
+//  	for {
+//  		typ, msg, err := c.ReadMessage()
+//  		if err != nil {
+//  			return
+//  		}
+//
+//  		if err := c.WriteMessage(typ, append([]byte(room+": "), msg...)); err != nil {
+//  			return
+//  		}
+//  	}
+//  }))
+package ws
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/valyala/fasthttp"
+)
+
+// Options configures HandlerWithOptions.
+type Options struct {
+	// Subprotocols lists supported subprotocols in order of preference, the
+	// first one offered by the client is selected, see Conn.Subprotocol.
+	Subprotocols []string
+
+	// CheckOrigin validates Origin header of the request, by default
+	// requests with Origin host different from Host are rejected with
+	// 403 Forbidden.
+	CheckOrigin func(rc *fasthttp.RequestCtx) bool
+
+	// EnableCompression enables permessage-deflate extension (RFC 7692)
+	// if offered by the client.
+	EnableCompression bool
+
+	// ReadLimit is a maximum size of received message, default 32 MiB.
+	ReadLimit int64
+}
+
+// Handler returns a handler that upgrades requests to WebSocket and serves
+// connections with fn.
+func Handler(fn func(ctx context.Context, c *Conn)) fchi.Handler {
+	return HandlerWithOptions(Options{}, fn)
+}
+
+// HandlerWithOptions returns a handler that upgrades requests to WebSocket
+// and serves connections with fn.
+//
+// Invalid handshake requests are rejected with fchi.HandleError. The
+// connection is served after the handler returns, so context of fn keeps
+// values of request context, but not its deadline or cancellation. Context
+// of fn is canceled when the connection fails or the server shuts down, and
+// the connection is closed when fn returns.
+//
+// Values with string keys are taken from user values of fasthttp.RequestCtx
+// copied at upgrade, because the RequestCtx is reused while the connection is
+// served. Values set with context.WithValue by middlewares are available if
+// their keys are not strings.
+func HandlerWithOptions(opts Options, fn func(ctx context.Context, c *Conn)) fchi.Handler {
+	if opts.CheckOrigin == nil {
+		opts.CheckOrigin = sameOrigin
+	}
+
+	if opts.ReadLimit <= 0 {
+		opts.ReadLimit = 32 << 20
+	}
+
+	return fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+		accept, ok := checkHandshake(ctx, rc, opts)
+		if !ok {
+			return
+		}
+
+		c := &Conn{
+			readLimit:   opts.ReadLimit,
+			subprotocol: selectSubprotocol(rc, opts.Subprotocols),
+			rctx:        fchi.RouteContext(rc),
+		}
+
+		if opts.EnableCompression && offersDeflate(rc) {
+			c.compress = true
+
+			rc.Response.Header.Set("Sec-WebSocket-Extensions", deflateResponse)
+		}
+
+		if c.rctx != nil {
+			// Routing context is used by the connection after the request is served.
+			c.rctx.KeepAlive()
+		}
+
+		rc.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+		rc.Response.Header.Set("Upgrade", "websocket")
+		rc.Response.Header.Set("Connection", "Upgrade")
+		rc.Response.Header.Set("Sec-WebSocket-Accept", accept)
+
+		if c.subprotocol != "" {
+			rc.Response.Header.Set("Sec-WebSocket-Protocol", c.subprotocol)
+		}
+
+		serverDone := rc.Done()
+		parent := hijackedContext{Context: ctx, userValues: make(map[string]interface{})}
+
+		rc.VisitUserValues(func(k []byte, v interface{}) {
+			parent.userValues[string(k)] = v
+		})
+
+		rc.Hijack(func(nc net.Conn) {
+			ctx, cancel := context.WithCancel(parent)
+			defer cancel()
+
+			c.init(nc, cancel)
+
+			watchDone := make(chan struct{})
+
+			go func() {
+				defer close(watchDone)
+
+				select {
+				case <-ctx.Done():
+				case <-serverDone:
+					_ = c.Close(CloseGoingAway, "server shutdown")
+				}
+			}()
+
+			fn(ctx, c)
+
+			_ = c.Close(CloseNormalClosure, "")
+
+			cancel()
+			<-watchDone
+		})
+	})
+}
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// checkHandshake validates opening handshake and returns Sec-WebSocket-Accept
+// value, error response is rendered if ok is false.
+func checkHandshake(ctx context.Context, rc *fasthttp.RequestCtx, opts Options) (accept string, ok bool) {
+	h := &rc.Request.Header
+
+	switch {
+	case !rc.IsGet():
+		fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusMethodNotAllowed})
+		rc.Response.Header.Set("Allow", "GET")
+
+		return "", false
+
+	case !headerHasToken(h.Peek("Connection"), "upgrade") || !headerHasToken(h.Peek("Upgrade"), "websocket"):
+		fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusUpgradeRequired, Detail: "websocket upgrade required"})
+		rc.Response.Header.Set("Upgrade", "websocket")
+		rc.Response.Header.Set("Connection", "Upgrade")
+
+		return "", false
+
+	case string(h.Peek("Sec-WebSocket-Version")) != "13":
+		fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusUpgradeRequired, Detail: "unsupported websocket version"})
+		rc.Response.Header.Set("Sec-WebSocket-Version", "13")
+
+		return "", false
+
+	case !opts.CheckOrigin(rc):
+		fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusForbidden, Detail: "origin not allowed"})
+
+		return "", false
+	}
+
+	key := strings.TrimSpace(string(h.Peek("Sec-WebSocket-Key")))
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		fchi.HandleError(ctx, rc, &fchi.Problem{Status: fasthttp.StatusBadRequest, Detail: "invalid Sec-WebSocket-Key"})
+
+		return "", false
+	}
+
+	// SHA-1 is required by RFC 6455.
+	sum := sha1.Sum([]byte(key + acceptGUID))
+
+	return base64.StdEncoding.EncodeToString(sum[:]), true
+}
+
+// sameOrigin allows requests without Origin header and requests with Origin
+// host equal to Host header.
+func sameOrigin(rc *fasthttp.RequestCtx) bool {
+	origin := rc.Request.Header.Peek("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(string(origin))
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, string(rc.Host()))
+}
+
+func selectSubprotocol(rc *fasthttp.RequestCtx, supported []string) string {
+	offered := headerValues(rc, "Sec-WebSocket-Protocol")
+
+	for _, s := range supported {
+		for _, o := range offered {
+			if s == o {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
+
+// headerValues returns comma separated values of all request headers with the name.
+func headerValues(rc *fasthttp.RequestCtx, name string) []string {
+	var values []string
+
+	rc.Request.Header.VisitAll(func(k, v []byte) {
+		if !strings.EqualFold(string(k), name) {
+			return
+		}
+
+		for _, s := range strings.Split(string(v), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	})
+
+	return values
+}
+
+func headerHasToken(v []byte, token string) bool {
+	for _, s := range strings.Split(string(v), ",") {
+		if strings.EqualFold(strings.TrimSpace(s), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hijackedContext keeps values of request context without its cancellation.
+//
+// Values with string keys are served from a copy of user values, as
+// fasthttp.RequestCtx.Value reads user values of reset RequestCtx after hijack.
+type hijackedContext struct {
+	context.Context
+	userValues map[string]interface{}
+}
+
+func (hijackedContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (hijackedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (hijackedContext) Err() error {
+	return nil
+}
+
+func (c hijackedContext) Value(key interface{}) interface{} {
+	if k, ok := key.(string); ok {
+		return c.userValues[k]
+	}
+
+	return c.Context.Value(key)
+}
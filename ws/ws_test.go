@@ -0,0 +1,328 @@
+package ws_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swaggest/fchi"
+	"github.com/swaggest/fchi/ws"
+	"github.com/valyala/fasthttp"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dial(t *testing.T, url string, header http.Header) *testClient {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+
+	if c.resp, err = http.ReadResponse(c.br, req); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func (c *testClient) writeFrame(fin bool, rsv1 bool, op byte, payload []byte) {
+	c.t.Helper()
+
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+
+	if rsv1 {
+		b0 |= 0x40
+	}
+
+	frame := []byte{b0}
+
+	switch l := len(payload); {
+	case l <= 125:
+		frame = append(frame, 0x80|byte(l))
+	case l <= 0xffff:
+		frame = append(frame, 0x80|126, byte(l>>8), byte(l))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(l))
+	}
+
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) readFrame() (rsv1 bool, op byte, payload []byte) {
+	c.t.Helper()
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if hdr[1]&0x80 != 0 {
+		c.t.Fatal("masked server frame")
+	}
+
+	l := int(hdr[1] & 0x7f)
+
+	switch l {
+	case 126:
+		var ext [2]byte
+
+		_, _ = io.ReadFull(c.br, ext[:])
+		l = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+
+		_, _ = io.ReadFull(c.br, ext[:])
+		l = int(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	payload = make([]byte, l)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return hdr[0]&0x40 != 0, hdr[0] & 0x0f, payload
+}
+
+func (c *testClient) expectClose(code int) {
+	c.t.Helper()
+
+	_, op, payload := c.readFrame()
+	if op != 0x8 || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		c.t.Fatalf("close %d expected, received opcode %d: %v", code, op, payload)
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	p := make([]byte, 2)
+	binary.BigEndian.PutUint16(p, uint16(code))
+
+	return append(p, reason...)
+}
+
+func TestHandler(t *testing.T) {
+	closed := make(chan error, 1)
+
+	echo := func(ctx context.Context, c *ws.Conn) {
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err != nil {
+				closed <- err
+
+				return
+			}
+
+			reply := c.URLParam("room") + " " + c.RouteContext().RoutePattern() + " " + c.Subprotocol() + ": " + string(msg)
+			if err := c.WriteMessage(typ, []byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+
+	r := fchi.NewRouter()
+	r.Get("/ws/{room}", ws.HandlerWithOptions(ws.Options{
+		Subprotocols:      []string{"chat.v2", "chat.v1"},
+		EnableCompression: true,
+		ReadLimit:         1 << 10,
+	}, echo))
+
+	type ctxKey struct{}
+
+	r.With(func(next fchi.Handler) fchi.Handler {
+		return fchi.HandlerFunc(func(ctx context.Context, rc *fasthttp.RequestCtx) {
+			rc.SetUserValue("tenant", "acme")
+			next.ServeHTTP(context.WithValue(ctx, ctxKey{}, "value"), rc)
+		})
+	}).Get("/values", ws.Handler(func(ctx context.Context, c *ws.Conn) {
+		// Request context is reset after upgrade.
+		time.Sleep(10 * time.Millisecond)
+
+		_ = c.WriteMessage(ws.TextMessage, []byte(fmt.Sprintf("%v %v", ctx.Value("tenant"), ctx.Value(ctxKey{}))))
+	}))
+
+	ts := fchi.NewTestServer(r)
+	defer ts.Close()
+
+	t.Run("context values", func(t *testing.T) {
+		c := dial(t, ts.URL+"/values", nil)
+		defer c.conn.Close()
+
+		if _, op, p := c.readFrame(); op != 0x1 || string(p) != "acme value" {
+			t.Fatalf("unexpected message %d %q", op, p)
+		}
+	})
+
+	t.Run("echo", func(t *testing.T) {
+		c := dial(t, ts.URL+"/ws/lobby", http.Header{"Sec-Websocket-Protocol": {"chat.v1, chat.v2"}})
+		defer c.conn.Close()
+
+		if c.resp.StatusCode != http.StatusSwitchingProtocols ||
+			c.resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+			c.resp.Header.Get("Sec-WebSocket-Protocol") != "chat.v2" ||
+			c.resp.Header.Get("Sec-WebSocket-Extensions") != "" {
+			t.Fatalf("unexpected handshake response: %d %v", c.resp.StatusCode, c.resp.Header)
+		}
+
+		c.writeFrame(false, false, 0x1, []byte("hel"))
+		c.writeFrame(true, false, 0x9, []byte("ping"))
+		c.writeFrame(true, false, 0x0, []byte("lo"))
+
+		if _, op, p := c.readFrame(); op != 0xa || string(p) != "ping" {
+			t.Fatalf("pong expected, received %d %q", op, p)
+		}
+
+		if _, op, p := c.readFrame(); op != 0x1 || string(p) != "lobby /ws/{room} chat.v2: hello" {
+			t.Fatalf("unexpected message %d %q", op, p)
+		}
+
+		c.writeFrame(true, false, 0x8, closePayload(1000, "bye"))
+		c.expectClose(1000)
+
+		if err := <-closed; err.Error() != "ws: connection closed by peer: 1000 bye" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("compression", func(t *testing.T) {
+		c := dial(t, ts.URL+"/ws/lobby", http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; client_max_window_bits"}})
+		defer c.conn.Close()
+
+		if ext := c.resp.Header.Get("Sec-WebSocket-Extensions"); ext != "permessage-deflate; server_no_context_takeover; client_no_context_takeover" {
+			t.Fatalf("unexpected extensions: %q", ext)
+		}
+
+		var buf bytes.Buffer
+
+		w, _ := flate.NewWriter(&buf, flate.BestCompression)
+		_, _ = w.Write([]byte(strings.Repeat("compressed ", 10)))
+		_ = w.Flush()
+
+		c.writeFrame(true, true, 0x1, bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff}))
+
+		rsv1, op, p := c.readFrame()
+		if !rsv1 || op != 0x1 {
+			t.Fatalf("compressed text message expected, received %v %d", rsv1, op)
+		}
+
+		msg, err := ioutil.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(p), strings.NewReader("\x00\x00\xff\xff\x01\x00\x00\xff\xff"))))
+		if err != nil || string(msg) != "lobby /ws/{room} : "+strings.Repeat("compressed ", 10) {
+			t.Fatalf("unexpected message %q: %v", msg, err)
+		}
+
+		// Compressed message that exceeds read limit.
+		buf.Reset()
+		w.Reset(&buf)
+		_, _ = w.Write(make([]byte, 2<<10))
+		_ = w.Flush()
+
+		c.writeFrame(true, true, 0x2, bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff}))
+		c.expectClose(1009)
+
+		if err := <-closed; err != ws.ErrMessageTooBig {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("protocol errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name  string
+			write func(c *testClient)
+			code  int
+		}{
+			{name: "continuation", code: 1002, write: func(c *testClient) { c.writeFrame(true, false, 0x0, []byte("x")) }},
+			{name: "fragmented ping", code: 1002, write: func(c *testClient) { c.writeFrame(false, false, 0x9, nil) }},
+			{name: "invalid utf-8", code: 1007, write: func(c *testClient) { c.writeFrame(true, false, 0x1, []byte{0xff, 0xfe}) }},
+			{name: "too big", code: 1009, write: func(c *testClient) { c.writeFrame(true, false, 0x2, make([]byte, 2<<10)) }},
+		} {
+			c := dial(t, ts.URL+"/ws/lobby", nil)
+
+			tc.write(c)
+			c.expectClose(tc.code)
+			<-closed
+
+			_ = c.conn.Close()
+		}
+	})
+
+	t.Run("handshake errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			header http.Header
+			status int
+		}{
+			{name: "version", header: http.Header{"Sec-Websocket-Version": {"8"}}, status: http.StatusUpgradeRequired},
+			{name: "key", header: http.Header{"Sec-Websocket-Key": {"short"}}, status: http.StatusBadRequest},
+			{name: "origin", header: http.Header{"Origin": {"http://evil.example"}}, status: http.StatusForbidden},
+		} {
+			c := dial(t, ts.URL+"/ws/lobby", tc.header)
+
+			if c.resp.StatusCode != tc.status {
+				t.Errorf("%s: unexpected status %d", tc.name, c.resp.StatusCode)
+			}
+
+			_ = c.conn.Close()
+		}
+
+		resp, err := http.Get(ts.URL + "/ws/lobby")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUpgradeRequired {
+			t.Errorf("unexpected status %d", resp.StatusCode)
+		}
+	})
+}